@@ -1,57 +1,171 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
+	"time"
 
+	"gosocial/blob"
+	"gosocial/configs"
+	"gosocial/errs"
+	"gosocial/logging"
+	"gosocial/ratelimit"
 	"gosocial/store"
+	"gosocial/stream"
 	"gosocial/types"
+	"gosocial/validate"
 
 	"github.com/gorilla/mux"
 )
 
 type apiServer struct {
-	addr  string
-	store *store.MySQLStorage
+	addr           string
+	store          store.Storage
+	blobs          blob.Store
+	metrics        *metrics
+	limiter        ratelimit.Limiter
+	limits         rateLimits
+	trustedProxies []*net.IPNet
+	hub            *stream.Hub
+	// streamHeartbeat is how often idle streams are kept alive.
+	streamHeartbeat time.Duration
 }
 
-func NewAPIServer(addr string, store *store.MySQLStorage) *apiServer {
-	return &apiServer{
-		addr:  addr,
-		store: store,
+func NewAPIServer(addr string, store store.Storage, blobs blob.Store) (*apiServer, error) {
+	trustedProxies, err := parseTrustedProxies(configs.Envs.TrustedProxies)
+	if err != nil {
+		return nil, err
 	}
+	return &apiServer{
+		addr:            addr,
+		store:           store,
+		blobs:           blobs,
+		metrics:         newMetrics(store),
+		limiter:         ratelimit.NewMemoryLimiter(),
+		limits:          newRateLimits(configs.Envs),
+		trustedProxies:  trustedProxies,
+		hub:             stream.NewHub(int(configs.Envs.StreamHistorySize), int(configs.Envs.StreamBufferSize)),
+		streamHeartbeat: time.Second * time.Duration(configs.Envs.StreamHeartbeatInSeconds),
+	}, nil
 }
 
-func (s *apiServer) Run() error {
-	router := mux.NewRouter()
+// Run serves the API until ctx is cancelled, then stops accepting
+// connections, waits up to the configured drain deadline for in-flight
+// requests and closes the storage. It returns an error if the server failed
+// or could not shut down cleanly.
+func (s *apiServer) Run(ctx context.Context) error {
+	server := &http.Server{
+		Addr:              s.addr,
+		Handler:           s.routes(),
+		ReadTimeout:       time.Second * time.Duration(configs.Envs.ReadTimeoutInSeconds),
+		ReadHeaderTimeout: time.Second * time.Duration(configs.Envs.ReadHeaderTimeoutInSeconds),
+		WriteTimeout:      time.Second * time.Duration(configs.Envs.WriteTimeoutInSeconds),
+		IdleTimeout:       time.Second * time.Duration(configs.Envs.IdleTimeoutInSeconds),
+		MaxHeaderBytes:    int(configs.Envs.MaxHeaderBytes),
+	}
+	// Shutdown does not wait for hijacked or streaming connections; closing
+	// the hub ends them.
+	server.RegisterOnShutdown(s.hub.Close)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Println("server running at", s.addr)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		s.store.Close()
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("shutting down, draining in-flight requests")
+	drainCtx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(configs.Envs.ShutdownTimeoutInSeconds))
+	defer cancel()
+
+	shutdownErr := server.Shutdown(drainCtx)
+	if shutdownErr != nil {
+		// Requests still running past the deadline are cut off.
+		server.Close()
+		shutdownErr = fmt.Errorf("draining requests: %w", shutdownErr)
+	}
+	if err := s.store.Close(); err != nil {
+		return errors.Join(shutdownErr, fmt.Errorf("closing storage: %w", err))
+	}
+	return shutdownErr
+}
 
-	router.HandleFunc("/signup", makeHTTPHandlerFunc(s.handleUserSignup)).Methods(http.MethodPost)
-	router.HandleFunc("/login", makeHTTPHandlerFunc(s.handleLogin)).Methods(http.MethodPost)
-	router.HandleFunc("/profile", WithJWTAuth(makeHTTPHandlerFunc(s.handleGetUser), s.store)).Methods(http.MethodGet)
-	router.HandleFunc("/profile", WithJWTAuth(makeHTTPHandlerFunc(s.handleUpdateUser), s.store)).Methods(http.MethodPut)
-	router.HandleFunc("/posts", WithJWTAuth(makeHTTPHandlerFunc(s.handleCreatePost), s.store)).Methods(http.MethodPost)
-	router.HandleFunc("/posts/{id}", WithJWTAuth(makeHTTPHandlerFunc(s.handleUpdatePost), s.store)).Methods(http.MethodPut)
-	router.HandleFunc("/posts/{id}/like", WithJWTAuth(makeHTTPHandlerFunc(s.handleLikePost), s.store)).Methods(http.MethodPost)
-	router.HandleFunc("/posts/{id}/comment", WithJWTAuth(makeHTTPHandlerFunc(s.handleCommentPost), s.store)).Methods(http.MethodPost)
+// routes returns the router wrapped in the middleware that every request
+// goes through, whether or not it matches a route.
+func (s *apiServer) routes() http.Handler {
+	return withRequestID(withRequestLogging(s.metrics.instrument(s.router())))
+}
 
-	log.Println("server running at", s.addr)
-	return http.ListenAndServe(s.addr, router)
+func (s *apiServer) router() *mux.Router {
+	router := mux.NewRouter()
+	router.Use(withRoute)
+
+	router.Handle("/metrics", s.metrics.handler()).Methods(http.MethodGet)
+	router.HandleFunc("/healthz", makeHTTPHandlerFunc(s.handleHealthz)).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", makeHTTPHandlerFunc(s.handleReadyz)).Methods(http.MethodGet)
+
+	router.HandleFunc("/.well-known/jwks.json", handleJWKS).Methods(http.MethodGet)
+	router.HandleFunc("/openapi.json", handleOpenAPI).Methods(http.MethodGet)
+	router.HandleFunc("/docs", handleDocs).Methods(http.MethodGet)
+	router.HandleFunc("/signup", s.rateLimit(s.limits.auth, makeHTTPHandlerFunc(s.handleUserSignup))).Methods(http.MethodPost)
+	router.HandleFunc("/login", s.rateLimit(s.limits.auth, makeHTTPHandlerFunc(s.handleLogin))).Methods(http.MethodPost)
+	router.HandleFunc("/token/refresh", s.rateLimit(s.limits.auth, makeHTTPHandlerFunc(s.handleRefreshToken))).Methods(http.MethodPost)
+	router.HandleFunc("/logout", s.authenticated(s.limits.write, s.handleLogout)).Methods(http.MethodPost)
+	router.HandleFunc("/profile", s.authenticated(s.limits.read, s.handleGetUser)).Methods(http.MethodGet)
+	router.HandleFunc("/profile", s.authenticated(s.limits.write, s.handleUpdateUser)).Methods(http.MethodPut)
+	router.HandleFunc("/posts", s.authenticated(s.limits.write, s.handleCreatePost)).Methods(http.MethodPost)
+	router.HandleFunc("/feed", s.authenticated(s.limits.read, s.handleGetFeed)).Methods(http.MethodGet)
+	router.HandleFunc("/users/{id}/posts", s.authenticated(s.limits.read, s.handleGetUserPosts)).Methods(http.MethodGet)
+	router.HandleFunc("/users/{id}/follow", s.authenticated(s.limits.write, s.handleFollowUser)).Methods(http.MethodPost)
+	router.HandleFunc("/users/{id}/follow", s.authenticated(s.limits.write, s.handleUnfollowUser)).Methods(http.MethodDelete)
+	router.HandleFunc("/users/{id}/followers", s.authenticated(s.limits.read, s.handleGetFollowers)).Methods(http.MethodGet)
+	router.HandleFunc("/users/{id}/mentions", s.authenticated(s.limits.read, s.handleGetUserMentions)).Methods(http.MethodGet)
+	router.HandleFunc("/users/{id}/following", s.authenticated(s.limits.read, s.handleGetFollowing)).Methods(http.MethodGet)
+	router.HandleFunc("/posts/{id}", s.authenticated(s.limits.read, s.handleGetPost)).Methods(http.MethodGet)
+	router.HandleFunc("/posts/{id}", s.authenticated(s.limits.write, s.handleUpdatePost)).Methods(http.MethodPut)
+	router.HandleFunc("/posts/{id}", s.authenticated(s.limits.write, s.handleDeletePost)).Methods(http.MethodDelete)
+	router.HandleFunc("/posts/{id}/like", s.authenticated(s.limits.write, s.handleLikePost)).Methods(http.MethodPost)
+	router.HandleFunc("/posts/{id}/likes", s.authenticated(s.limits.read, s.handleGetPostLikes)).Methods(http.MethodGet)
+	router.HandleFunc("/posts/{id}/comment", s.authenticated(s.limits.write, s.handleCommentPost)).Methods(http.MethodPost)
+	router.HandleFunc("/posts/{id}/attachments", s.authenticated(s.limits.write, s.handleUploadAttachments)).Methods(http.MethodPost)
+	router.HandleFunc("/posts/{postID}/attachments/{attachmentID}", s.authenticated(s.limits.write, s.handleDeleteAttachment)).Methods(http.MethodDelete)
+	router.HandleFunc("/attachments/{id}", s.authenticated(s.limits.read, s.handleGetAttachment)).Methods(http.MethodGet, http.MethodHead)
+	router.HandleFunc("/attachments/{id}/thumbnail", s.authenticated(s.limits.read, s.handleGetAttachmentThumbnail)).Methods(http.MethodGet, http.MethodHead)
+	router.HandleFunc("/posts/{postID}/comments/{commentID}", s.authenticated(s.limits.write, s.handleDeleteComment)).Methods(http.MethodDelete)
+	router.HandleFunc("/tags/{tag}/posts", s.authenticated(s.limits.read, s.handleGetTagPosts)).Methods(http.MethodGet)
+	router.HandleFunc("/notifications", s.authenticated(s.limits.read, s.handleGetNotifications)).Methods(http.MethodGet)
+	router.HandleFunc("/notifications/read", s.authenticated(s.limits.write, s.handleMarkNotificationsRead)).Methods(http.MethodPost)
+	router.HandleFunc("/stream", s.authenticated(s.limits.read, s.handleStream)).Methods(http.MethodGet)
+	router.HandleFunc("/search", s.authenticated(s.limits.read, s.handleSearch)).Methods(http.MethodGet)
+
+	return router
 }
 
 func (s *apiServer) handleUserSignup(w http.ResponseWriter, r *http.Request) error {
 	var userSignupReq types.UserSignupRequest
-	err := json.NewDecoder(r.Body).Decode(&userSignupReq)
-	defer r.Body.Close()
-	if err != nil {
+	if err := decodeRequest(w, r, &userSignupReq); err != nil {
 		return err
 	}
 
-	_, err = s.store.GetUserByUsername(userSignupReq.Username)
+	_, err := s.store.GetUserByUsername(r.Context(), userSignupReq.Username)
+	if err != nil && !errs.Is(err, errs.KindNotFound) {
+		return err
+	}
 	if err == nil {
-		return fmt.Errorf("username %s already exists", userSignupReq.Username)
+		return errs.Conflict("username_taken", fmt.Sprintf("username %s already exists", userSignupReq.Username))
 	}
 
 	hashed, err := hashPassword(userSignupReq.Password)
@@ -60,57 +174,167 @@ func (s *apiServer) handleUserSignup(w http.ResponseWriter, r *http.Request) err
 	}
 
 	user := types.NewUser(userSignupReq.Username, hashed, userSignupReq.UserProfile)
-	if err = s.store.CreateUser(user); err != nil {
+	if err = s.store.CreateUser(r.Context(), user); err != nil {
 		return err
 	}
+	s.metrics.signups.Inc()
 
 	return WriteJSON(w, http.StatusCreated, map[string]string{"msg": "user created"})
 }
 
 func (s *apiServer) handleLogin(w http.ResponseWriter, r *http.Request) error {
 	var userLoginReq types.UserLoginRequest
-	err := json.NewDecoder(r.Body).Decode(&userLoginReq)
-	defer r.Body.Close()
+	if err := decodeRequest(w, r, &userLoginReq); err != nil {
+		return err
+	}
+
+	userKey, ipKey := loginUserKey(userLoginReq.Username), loginIPKey(s.clientIP(r))
+	if err := s.checkLoginLock(r.Context(), w, userKey, ipKey); err != nil {
+		return err
+	}
+
+	// Unknown users and wrong passwords take the same time and get the same
+	// response, so that usernames cannot be enumerated.
+	user, err := s.store.GetUserByUsername(r.Context(), userLoginReq.Username)
+	if err != nil && !errs.Is(err, errs.KindNotFound) {
+		return err
+	}
+	if user == nil {
+		compareDummyPassword(userLoginReq.Password)
+	}
+	if user == nil || !comparePasswords(user.Password, userLoginReq.Password) {
+		s.metrics.failedLogins.Inc()
+		if err := s.recordLoginFailure(r.Context(), userKey, ipKey); err != nil {
+			return err
+		}
+		return errInvalidCredentials
+	}
+	// A success also clears the failures of the client IP, so that users
+	// sharing an address do not build toward a lockout of each other.
+	for _, key := range []string{userKey, ipKey} {
+		if err := s.store.ClearLoginAttempt(r.Context(), key); err != nil {
+			return err
+		}
+	}
+
+	session, err := s.store.CreateSession(r.Context(), user.ID)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := s.issueTokens(r.Context(), user.ID, session.ID)
+	if err != nil {
+		return err
+	}
+
+	s.metrics.logins.Inc()
+	return WriteJSON(w, http.StatusOK, tokens)
+}
+
+// handleRefreshToken exchanges a refresh token for a new access and refresh
+// token pair. Refresh tokens rotate on every use; presenting one that has
+// already been used is treated as theft and revokes the whole session.
+func (s *apiServer) handleRefreshToken(w http.ResponseWriter, r *http.Request) error {
+	var tokenRefreshReq types.TokenRefreshRequest
+	if err := decodeRequest(w, r, &tokenRefreshReq); err != nil {
+		return err
+	}
+
+	rt, err := s.store.GetRefreshTokenByHash(r.Context(), hashToken(tokenRefreshReq.RefreshToken))
+	if errs.Is(err, errs.KindNotFound) {
+		return errInvalidRefreshToken
+	}
+	if err != nil {
+		return err
+	}
+
+	session, err := s.store.GetSessionByID(r.Context(), rt.SessionID)
+	if err != nil {
+		return err
+	}
+	if session.RevokedAt != nil || time.Now().After(rt.ExpiresAt) {
+		return errInvalidRefreshToken
+	}
+
+	fresh, err := s.store.MarkRefreshTokenUsed(r.Context(), rt.ID)
 	if err != nil {
 		return err
 	}
+	if !fresh {
+		logging.FromContext(r.Context()).Warn("refresh token reuse detected, revoking session", "session_id", session.ID)
+		if err := s.store.RevokeSession(r.Context(), session.ID); err != nil {
+			return err
+		}
+		return errInvalidRefreshToken
+	}
 
-	user, err := s.store.GetUserByUsername(userLoginReq.Username)
+	tokens, err := s.issueTokens(r.Context(), session.UserID, session.ID)
 	if err != nil {
-		return fmt.Errorf("invalid credentials")
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, tokens)
+}
+
+func (s *apiServer) handleLogout(w http.ResponseWriter, r *http.Request) error {
+	if err := s.store.RevokeSession(r.Context(), GetSessionIDFromContext(r.Context())); err != nil {
+		return err
 	}
 
-	if !comparePasswords(user.Password, userLoginReq.Password) {
-		return fmt.Errorf("invalid credentials")
+	return WriteJSON(w, http.StatusOK, map[string]string{"msg": "logged out"})
+}
+
+// issueTokens creates an access token and a new refresh token for the session.
+func (s *apiServer) issueTokens(ctx context.Context, userID int, sessionID string) (*types.TokenResponse, error) {
+	token, err := CreateJWT(userID, sessionID)
+	if err != nil {
+		return nil, err
 	}
 
-	token, err := CreateJWT(user.ID)
+	refreshToken, hash, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	expiration := time.Second * time.Duration(configs.Envs.RefreshTokenExpirationInSeconds)
+	err = s.store.CreateRefreshToken(ctx, &types.RefreshToken{
+		SessionID: sessionID,
+		UserID:    userID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(expiration),
+	})
 	if err != nil {
-		return ServerError(w)
+		return nil, err
 	}
 
-	return WriteJSON(w, http.StatusOK, map[string]string{"token": token})
+	return &types.TokenResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    configs.Envs.JWTExpirationInSeconds,
+	}, nil
 }
 
 func (s *apiServer) handleGetUser(w http.ResponseWriter, r *http.Request) error {
 	userID := GetUserIDFromContext(r.Context())
-	user, err := s.store.GetUserByID(userID)
+	user, err := s.store.GetUserByID(r.Context(), userID)
 	if err != nil {
-		return WriteJSON(w, http.StatusInternalServerError, &apiError{Error: "internal server error"})
+		return err
+	}
+
+	user.FollowerCount, user.FollowingCount, err = s.store.GetFollowCounts(r.Context(), user.ID)
+	if err != nil {
+		return err
 	}
 	return WriteJSON(w, http.StatusOK, user)
 }
 
 func (s *apiServer) handleUpdateUser(w http.ResponseWriter, r *http.Request) error {
 	var userUpdateReq types.UserUpdateRequest
-	err := json.NewDecoder(r.Body).Decode(&userUpdateReq)
-	defer r.Body.Close()
-	if err != nil {
+	if err := decodeRequest(w, r, &userUpdateReq); err != nil {
 		return err
 	}
 
 	if userUpdateReq.Password == "" && userUpdateReq.UserProfile == "" {
-		return fmt.Errorf("no info to update")
+		return errs.Validation("nothing_to_update", "no info to update")
 	}
 	user := &types.User{
 		ID:          GetUserIDFromContext(r.Context()),
@@ -125,8 +349,15 @@ func (s *apiServer) handleUpdateUser(w http.ResponseWriter, r *http.Request) err
 		user.Password = hashed
 	}
 
-	if err := s.store.UpdateUser(user); err != nil {
-		return ServerError(w)
+	if err := s.store.UpdateUser(r.Context(), user); err != nil {
+		return err
+	}
+
+	// A password change ends every session, including the current one.
+	if user.Password != "" {
+		if err := s.store.RevokeUserSessions(r.Context(), user.ID); err != nil {
+			return err
+		}
 	}
 
 	return WriteJSON(w, http.StatusOK, map[string]string{"msg": "user info updated"})
@@ -134,84 +365,356 @@ func (s *apiServer) handleUpdateUser(w http.ResponseWriter, r *http.Request) err
 
 func (s *apiServer) handleCreatePost(w http.ResponseWriter, r *http.Request) error {
 	var postCreateReq types.PostCreateRequest
-	if err := decodeRequest(r, &postCreateReq); err != nil {
+	if err := decodeRequest(w, r, &postCreateReq); err != nil {
 		return err
 	}
 
 	userID := GetUserIDFromContext(r.Context())
 	post := types.NewPost(userID, postCreateReq.Content)
-	if err := s.store.CreatePost(post); err != nil {
-		return ServerError(w)
+	ents, err := s.parseEntities(r.Context(), post.Content)
+	if err != nil {
+		return err
+	}
+	post.Entities = ents
+	if err := s.store.CreatePost(r.Context(), post); err != nil {
+		return err
 	}
+	s.metrics.posts.Inc()
+	s.publishPost(r.Context(), post)
 
 	return WriteJSON(w, http.StatusCreated, map[string]string{"msg": "post created"})
 }
 
-func (s *apiServer) handleUpdatePost(w http.ResponseWriter, r *http.Request) error {
+func (s *apiServer) handleGetFeed(w http.ResponseWriter, r *http.Request) error {
+	page, err := getPage(r)
+	if err != nil {
+		return err
+	}
+
+	userID := GetUserIDFromContext(r.Context())
+	posts, err := s.store.GetFeed(r.Context(), userID, page.Cursor, page.Limit+1)
+	if err != nil {
+		return err
+	}
+
+	resp := newFeedResponse(posts, page.Limit)
+	if err := s.setFeedPostEntities(r.Context(), resp.Posts); err != nil {
+		return err
+	}
+	if err := s.setFeedPostAttachments(r.Context(), resp.Posts); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, resp)
+}
+
+func (s *apiServer) handleGetUserPosts(w http.ResponseWriter, r *http.Request) error {
+	userID, err := getID(r)
+	if err != nil {
+		return errInvalidID
+	}
+
+	page, err := getPage(r)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	posts, err := s.store.GetPostsByUserID(r.Context(), user.ID, page.Cursor, page.Limit+1)
+	if err != nil {
+		return err
+	}
+
+	resp := newFeedResponse(posts, page.Limit)
+	if err := s.setFeedPostEntities(r.Context(), resp.Posts); err != nil {
+		return err
+	}
+	if err := s.setFeedPostAttachments(r.Context(), resp.Posts); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, resp)
+}
+
+func (s *apiServer) handleFollowUser(w http.ResponseWriter, r *http.Request) error {
+	followeeID, err := getID(r)
+	if err != nil {
+		return errInvalidID
+	}
+
+	followerID := GetUserIDFromContext(r.Context())
+	if followeeID == followerID {
+		return errs.Validation("self_follow", "cannot follow yourself")
+	}
+
+	followee, err := s.store.GetUserByID(r.Context(), followeeID)
+	if err != nil {
+		return err
+	}
+
+	follow, err := s.store.GetFollow(r.Context(), followerID, followee.ID)
+	if err != nil {
+		return err
+	}
+	if follow.ID != 0 {
+		return errs.Conflict("already_following", "already following user")
+	}
+
+	if err := s.store.FollowUser(r.Context(), followerID, followee.ID); err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, map[string]string{"msg": "user followed"})
+}
+
+func (s *apiServer) handleUnfollowUser(w http.ResponseWriter, r *http.Request) error {
+	followeeID, err := getID(r)
+	if err != nil {
+		return errInvalidID
+	}
+
+	followerID := GetUserIDFromContext(r.Context())
+	follow, err := s.store.GetFollow(r.Context(), followerID, followeeID)
+	if err != nil {
+		return err
+	}
+	if follow.ID == 0 {
+		return errs.NotFound("not_following", "not following user")
+	}
+
+	if err := s.store.UnfollowUser(r.Context(), followerID, followeeID); err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, map[string]string{"msg": "user unfollowed"})
+}
+
+func (s *apiServer) handleGetFollowers(w http.ResponseWriter, r *http.Request) error {
+	return s.handleFollowList(w, r, s.store.GetFollowers)
+}
+
+func (s *apiServer) handleGetFollowing(w http.ResponseWriter, r *http.Request) error {
+	return s.handleFollowList(w, r, s.store.GetFollowing)
+}
+
+type followListFunc func(ctx context.Context, userID int, cursor *types.Cursor, limit int) ([]*types.FollowListEntry, error)
+
+func (s *apiServer) handleFollowList(w http.ResponseWriter, r *http.Request, list followListFunc) error {
+	userID, err := getID(r)
+	if err != nil {
+		return errInvalidID
+	}
+
+	page, err := getPage(r)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	entries, err := list(r.Context(), user.ID, page.Cursor, page.Limit+1)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, newFollowListResponse(entries, page.Limit))
+}
+
+func (s *apiServer) handleGetPost(w http.ResponseWriter, r *http.Request) error {
+	postID, err := getID(r)
+	if err != nil {
+		return errInvalidID
+	}
+
+	page, err := getPage(r)
+	if err != nil {
+		return err
+	}
+
+	post, err := s.store.GetPostByID(r.Context(), postID)
+	if err != nil {
+		return err
+	}
+
+	author, err := s.store.GetUserByID(r.Context(), post.UserID)
+	if err != nil {
+		return err
+	}
+
+	likeCount, err := s.store.GetPostLikeCount(r.Context(), post.ID)
+	if err != nil {
+		return err
+	}
+
+	userID := GetUserIDFromContext(r.Context())
+	like, err := s.store.GetPostLikeByUserID(r.Context(), post.ID, userID)
+	if err != nil {
+		return err
+	}
+
+	comments, err := s.store.GetPostComments(r.Context(), post.ID, page.Cursor, page.Limit+1)
+	if err != nil {
+		return err
+	}
+
+	detail := &types.PostDetail{
+		Post: *post,
+		Author: types.UserSummary{
+			ID:          author.ID,
+			Username:    author.Username,
+			UserProfile: author.UserProfile,
+		},
+		LikeCount: likeCount,
+		LikedByMe: like.ID != 0,
+		Comments:  comments,
+	}
+	if len(comments) > page.Limit {
+		detail.Comments = comments[:page.Limit]
+		last := detail.Comments[page.Limit-1]
+		detail.NextCommentsCursor = encodeCursor(types.Cursor{CreatedAt: last.Timestamp, ID: last.ID})
+	}
+
+	if err := s.setPostEntities(r.Context(), &detail.Post); err != nil {
+		return err
+	}
+	if err := s.setPostAttachments(r.Context(), &detail.Post); err != nil {
+		return err
+	}
+	commentsOnPage := make([]*types.PostComment, len(detail.Comments))
+	for i, c := range detail.Comments {
+		commentsOnPage[i] = &c.PostComment
+	}
+	if err := s.setCommentEntities(r.Context(), commentsOnPage...); err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, detail)
+}
+
+func (s *apiServer) handleGetPostLikes(w http.ResponseWriter, r *http.Request) error {
 	postID, err := getID(r)
 	if err != nil {
-		return fmt.Errorf("invalid ID format")
+		return errInvalidID
+	}
+
+	page, err := getPage(r)
+	if err != nil {
+		return err
+	}
+
+	post, err := s.store.GetPostByID(r.Context(), postID)
+	if err != nil {
+		return err
+	}
+
+	likers, err := s.store.GetPostLikers(r.Context(), post.ID, page.Cursor, page.Limit+1)
+	if err != nil {
+		return err
 	}
 
-	post, err := s.store.GetPostByID(postID)
+	return WriteJSON(w, http.StatusOK, newPostLikersResponse(likers, page.Limit))
+}
+
+func (s *apiServer) handleUpdatePost(w http.ResponseWriter, r *http.Request) error {
+	postID, err := getID(r)
 	if err != nil {
-		return ServerError(w)
+		return errInvalidID
 	}
-	if post.ID == 0 {
-		return fmt.Errorf("post not found")
+
+	post, err := s.store.GetPostByID(r.Context(), postID)
+	if err != nil {
+		return err
 	}
 
 	currentUserID := GetUserIDFromContext(r.Context())
 	if post.UserID != currentUserID {
-		return WriteJSON(w, http.StatusForbidden, &apiError{Error: "permission denied"})
+		return errPermissionDenied
 	}
 
 	var postUpdateRequest types.PostUpdateRequest
-	if err := decodeRequest(r, &postUpdateRequest); err != nil {
+	if err := decodeRequest(w, r, &postUpdateRequest); err != nil {
 		return err
 	}
 
 	post.Content = postUpdateRequest.Content
-	if err := s.store.UpdatePost(post); err != nil {
-		return ServerError(w)
+	post.Entities, err = s.parseEntities(r.Context(), post.Content)
+	if err != nil {
+		return err
+	}
+	if err := s.store.UpdatePost(r.Context(), post); err != nil {
+		return err
 	}
 
 	return WriteJSON(w, http.StatusOK, map[string]string{"msg": "post updated"})
 }
 
-func (s *apiServer) handleLikePost(w http.ResponseWriter, r *http.Request) error {
+func (s *apiServer) handleDeletePost(w http.ResponseWriter, r *http.Request) error {
 	postID, err := getID(r)
 	if err != nil {
-		return fmt.Errorf("invalid ID format")
+		return errInvalidID
+	}
+
+	post, err := s.store.GetPostByID(r.Context(), postID)
+	if err != nil {
+		return err
+	}
+
+	currentUserID := GetUserIDFromContext(r.Context())
+	if post.UserID != currentUserID {
+		return errPermissionDenied
+	}
+
+	attachments, err := s.store.GetPostAttachments(r.Context(), []int{post.ID})
+	if err != nil {
+		return err
 	}
+	if err := s.store.DeletePost(r.Context(), post.ID); err != nil {
+		return err
+	}
+	s.deleteAttachmentBlobs(r.Context(), attachments[post.ID]...)
 
-	post, err := s.store.GetPostByID(postID)
+	return WriteJSON(w, http.StatusOK, map[string]string{"msg": "post deleted"})
+}
+
+func (s *apiServer) handleLikePost(w http.ResponseWriter, r *http.Request) error {
+	postID, err := getID(r)
 	if err != nil {
-		return ServerError(w)
+		return errInvalidID
 	}
 
-	if post.ID == 0 {
-		return fmt.Errorf("post not found")
+	post, err := s.store.GetPostByID(r.Context(), postID)
+	if err != nil {
+		return err
 	}
 
 	userID := GetUserIDFromContext(r.Context())
 
-	like, err := s.store.GetPostLikeByUserID(postID, userID)
+	like, err := s.store.GetPostLikeByUserID(r.Context(), postID, userID)
 	if err != nil {
-		return ServerError(w)
+		return err
 	}
 	var msg string
 	if like.ID == 0 {
-		err = s.store.LikePost(postID, userID)
-		msg = "post unliked"
-	} else {
-		err = s.store.UnlikePost(postID, userID)
+		err = s.store.LikePost(r.Context(), postID, userID)
 		msg = "post liked"
+	} else {
+		err = s.store.UnlikePost(r.Context(), postID, userID)
+		msg = "post unliked"
 	}
 
 	if err != nil {
-		return ServerError(w)
+		return err
+	}
+	if like.ID == 0 {
+		s.metrics.likes.Inc()
 	}
+	s.notifyLike(r.Context(), post, userID, like.ID == 0)
+	s.publishLike(r.Context(), post, userID, like.ID == 0)
 
 	return WriteJSON(w, http.StatusOK, map[string]string{"msg": msg})
 }
@@ -219,66 +722,186 @@ func (s *apiServer) handleLikePost(w http.ResponseWriter, r *http.Request) error
 func (s *apiServer) handleCommentPost(w http.ResponseWriter, r *http.Request) error {
 	postID, err := getID(r)
 	if err != nil {
-		return fmt.Errorf("invalid id format")
+		return errInvalidID
 	}
 
-	post, err := s.store.GetPostByID(postID)
+	post, err := s.store.GetPostByID(r.Context(), postID)
 	if err != nil {
-		return ServerError(w)
-	}
-
-	if post.ID == 0 {
-		return fmt.Errorf("post not found")
+		return err
 	}
 
 	var postCommentReq types.PostCommentRequest
-	if err := decodeRequest(r, &postCommentReq); err != nil {
-		return ServerError(w)
+	if err := decodeRequest(w, r, &postCommentReq); err != nil {
+		return err
 	}
 
 	userID := GetUserIDFromContext(r.Context())
 	postComment := types.NewPostComment(post.ID, userID, postCommentReq.Content)
-	if err := s.store.CommentPost(postComment); err != nil {
-		return ServerError(w)
+	postComment.Entities, err = s.parseEntities(r.Context(), postComment.Content)
+	if err != nil {
+		return err
 	}
+	if err := s.store.CommentPost(r.Context(), postComment); err != nil {
+		return err
+	}
+	s.metrics.comments.Inc()
+	s.notifyComment(r.Context(), post, postComment)
+	s.publishComment(r.Context(), post, postComment)
 
 	return WriteJSON(w, http.StatusOK, map[string]string{"msg": "comment submitted"})
 }
 
+func (s *apiServer) handleDeleteComment(w http.ResponseWriter, r *http.Request) error {
+	postID, err := getIntVar(r, "postID")
+	if err != nil {
+		return errInvalidID
+	}
+	commentID, err := getIntVar(r, "commentID")
+	if err != nil {
+		return errInvalidID
+	}
+
+	comment, err := s.store.GetCommentByID(r.Context(), commentID)
+	if err != nil {
+		return err
+	}
+	if comment.PostID != postID {
+		return errs.NotFound("comment_not_found", "comment not found")
+	}
+
+	currentUserID := GetUserIDFromContext(r.Context())
+	if comment.UserID != currentUserID {
+		return errPermissionDenied
+	}
+
+	if err := s.store.DeleteComment(r.Context(), comment.ID); err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, map[string]string{"msg": "comment deleted"})
+}
+
+var (
+	errInvalidID           = errs.BadRequest("invalid_id", "invalid ID format")
+	errInvalidCredentials  = errs.Unauthorized("invalid_credentials", "invalid credentials")
+	errInvalidRefreshToken = errs.Unauthorized("invalid_refresh_token", "invalid refresh token")
+	errPermissionDenied    = errs.Forbidden("permission_denied", "permission denied")
+)
+
 type apiFunc func(http.ResponseWriter, *http.Request) error
 
 type apiError struct {
-	Error string `json:"error"`
+	Error  string            `json:"error"`
+	Code   string            `json:"code"`
+	Fields []errs.FieldError `json:"fields,omitempty"`
 }
 
+// makeHTTPHandlerFunc adapts an apiFunc to http.HandlerFunc. A returned error
+// is written as a JSON apiError whose status and code come from its errs.Kind,
+// unless the handler has already started writing the response.
 func makeHTTPHandlerFunc(f apiFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if err := f(w, r); err != nil {
-			WriteJSON(w, http.StatusBadRequest, &apiError{Error: err.Error()})
+		rw := &responseWriter{ResponseWriter: w}
+		if err := f(rw, r); err != nil {
+			if rw.wroteHeader {
+				logging.FromContext(r.Context()).Error("error after response was written", "error", err)
+				return
+			}
+			writeError(rw, r, err)
 		}
 	}
 }
 
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	e := errs.From(err)
+	if e.Kind == errs.KindInternal {
+		logging.FromContext(r.Context()).Error("internal error", "error", err)
+	}
+	WriteJSON(w, statusForKind(e.Kind), &apiError{Error: e.Message, Code: e.Code, Fields: e.Fields})
+}
+
+func statusForKind(k errs.Kind) int {
+	switch k {
+	case errs.KindBadRequest:
+		return http.StatusBadRequest
+	case errs.KindValidation:
+		return http.StatusUnprocessableEntity
+	case errs.KindTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case errs.KindUnsupportedMediaType:
+		return http.StatusUnsupportedMediaType
+	case errs.KindUnauthorized:
+		return http.StatusUnauthorized
+	case errs.KindForbidden:
+		return http.StatusForbidden
+	case errs.KindNotFound:
+		return http.StatusNotFound
+	case errs.KindConflict:
+		return http.StatusConflict
+	case errs.KindTooManyRequests:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// responseWriter records whether the header has been written so that an
+// error returned after a successful write does not produce a second response.
+type responseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	rw.wroteHeader = true
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	return rw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 func WriteJSON(w http.ResponseWriter, status int, payload any) error {
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(status)
 	return json.NewEncoder(w).Encode(payload)
 }
 
-func ServerError(w http.ResponseWriter) error {
-	return WriteJSON(w, http.StatusInternalServerError, &apiError{Error: "internal server error"})
+func getID(r *http.Request) (int, error) {
+	return getIntVar(r, "id")
 }
 
-func getID(r *http.Request) (int, error) {
-	idStr := mux.Vars(r)["id"]
-	return strconv.Atoi(idStr)
+func getIntVar(r *http.Request, name string) (int, error) {
+	return strconv.Atoi(mux.Vars(r)[name])
 }
 
-func decodeRequest(r *http.Request, payload any) error {
-	err := json.NewDecoder(r.Body).Decode(payload)
+// decodeRequest strictly decodes a JSON body into payload and validates it.
+// Bodies larger than the configured limit, unknown fields and trailing data
+// are rejected.
+func decodeRequest(w http.ResponseWriter, r *http.Request, payload any) error {
 	defer r.Body.Close()
-	if err != nil {
-		return err
+	body := http.MaxBytesReader(w, r.Body, configs.Envs.MaxRequestBodyBytes)
+
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(payload); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return errs.Wrap(errs.KindTooLarge, "body_too_large", fmt.Sprintf("request body exceeds %d bytes", maxBytesErr.Limit), err)
+		}
+		return errs.Wrap(errs.KindBadRequest, "invalid_json", "invalid request body", err)
 	}
-	return nil
+	// Anything but the end of the body after the value is trailing data,
+	// including a stray closing brace or bracket.
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		return errs.BadRequest("invalid_json", "invalid request body: trailing data")
+	}
+
+	return validate.Struct(payload)
 }
@@ -0,0 +1,644 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"gosocial/blob"
+	"gosocial/store"
+	"gosocial/types"
+)
+
+type testClient struct {
+	t   *testing.T
+	srv *httptest.Server
+}
+
+// testStorages are the backends every handler test runs against. MySQL is
+// left out as it needs a running server.
+var testStorages = map[string]func(t *testing.T) store.Storage{
+	"memory": func(t *testing.T) store.Storage {
+		return store.NewMemoryStorage()
+	},
+	"sqlite": func(t *testing.T) store.Storage {
+		s, err := store.NewSQLiteStorage(filepath.Join(t.TempDir(), "test.db"), store.PoolConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	},
+}
+
+// forEachStorage runs the test once per storage backend.
+func forEachStorage(t *testing.T, test func(t *testing.T, c *testClient)) {
+	for name, newStorage := range testStorages {
+		t.Run(name, func(t *testing.T) {
+			test(t, newTestClient(t, newStorage(t)))
+		})
+	}
+}
+
+func newTestClient(t *testing.T, storage store.Storage) *testClient {
+	t.Helper()
+	if err := storage.Init(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewAPIServer("", storage, blob.NewLocalStore(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(s.routes())
+	t.Cleanup(srv.Close)
+	return &testClient{t: t, srv: srv}
+}
+
+// do sends a request with an optional JSON body and decodes the JSON response
+// into out when it is not nil. It returns the status code.
+func (c *testClient) do(method, path, token string, body any, out any) int {
+	c.t.Helper()
+
+	var r io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		r = strings.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		r = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.srv.URL+path, r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			c.t.Fatalf("%s %s: decoding response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func (c *testClient) expect(want int, method, path, token string, body any, out any) {
+	c.t.Helper()
+	if got := c.do(method, path, token, body, out); got != want {
+		c.t.Fatalf("%s %s: got status %d, want %d", method, path, got, want)
+	}
+}
+
+func (c *testClient) expectError(want int, code, method, path, token string, body any) apiError {
+	c.t.Helper()
+	var apiErr apiError
+	c.expect(want, method, path, token, body, &apiErr)
+	if apiErr.Code != code {
+		c.t.Fatalf("%s %s: got error code %q, want %q", method, path, apiErr.Code, code)
+	}
+	return apiErr
+}
+
+// signup creates a user and logs them in, returning their tokens and ID.
+func (c *testClient) signup(username string) (*types.TokenResponse, int) {
+	c.t.Helper()
+	creds := map[string]string{"username": username, "password": "password123"}
+	c.expect(http.StatusCreated, http.MethodPost, "/signup", "", creds, nil)
+
+	tokens := new(types.TokenResponse)
+	c.expect(http.StatusOK, http.MethodPost, "/login", "", creds, tokens)
+
+	var user types.User
+	c.expect(http.StatusOK, http.MethodGet, "/profile", tokens.Token, nil, &user)
+	return tokens, user.ID
+}
+
+func (c *testClient) createPost(token, content string) {
+	c.t.Helper()
+	c.expect(http.StatusCreated, http.MethodPost, "/posts", token, map[string]string{"content": content}, nil)
+}
+
+func TestSignupAndLogin(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		c.signup("alice")
+
+		c.expectError(http.StatusConflict, "username_taken", http.MethodPost, "/signup", "",
+			map[string]string{"username": "alice", "password": "password123"})
+		c.expectError(http.StatusUnauthorized, "invalid_credentials", http.MethodPost, "/login", "",
+			map[string]string{"username": "alice", "password": "wrong-password"})
+		c.expectError(http.StatusUnauthorized, "invalid_credentials", http.MethodPost, "/login", "",
+			map[string]string{"username": "nobody", "password": "password123"})
+	})
+}
+
+// TestUsernameIgnoresCase checks that every backend matches the default
+// MySQL collation, which compares usernames ignoring case.
+func TestUsernameIgnoresCase(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		c.signup("Alice")
+		c.expectError(http.StatusConflict, "username_taken", http.MethodPost, "/signup", "",
+			map[string]string{"username": "alice", "password": "password123"})
+
+		tokens := new(types.TokenResponse)
+		c.expect(http.StatusOK, http.MethodPost, "/login", "", map[string]string{"username": "ALICE", "password": "password123"}, tokens)
+		var user types.User
+		c.expect(http.StatusOK, http.MethodGet, "/profile", tokens.Token, nil, &user)
+		if user.Username != "Alice" {
+			t.Errorf("logged in as %q, want Alice", user.Username)
+		}
+	})
+}
+
+func TestRequestValidation(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		apiErr := c.expectError(http.StatusUnprocessableEntity, "validation_failed", http.MethodPost, "/signup", "",
+			map[string]string{"username": "", "password": "short"})
+		if len(apiErr.Fields) != 2 {
+			t.Errorf("got %d field errors, want 2: %+v", len(apiErr.Fields), apiErr.Fields)
+		}
+
+		c.expectError(http.StatusBadRequest, "invalid_json", http.MethodPost, "/signup", "",
+			`{"username": "bob", "password": "password123", "admin": true}`)
+		c.expectError(http.StatusBadRequest, "invalid_json", http.MethodPost, "/signup", "", `{"username": `)
+		for _, trailing := range []string{"}", "]", "{}", `"x"`} {
+			c.expectError(http.StatusBadRequest, "invalid_json", http.MethodPost, "/signup", "",
+				`{"username": "bob", "password": "password123"}`+trailing)
+		}
+
+		tokens, _ := c.signup("bob")
+		c.expectError(http.StatusUnprocessableEntity, "validation_failed", http.MethodPost, "/posts", tokens.Token,
+			map[string]string{"content": strings.Repeat("x", 5001)})
+		c.expectError(http.StatusRequestEntityTooLarge, "body_too_large", http.MethodPost, "/posts", tokens.Token,
+			fmt.Sprintf(`{"content": %q}`, strings.Repeat("x", 2<<20)))
+	})
+}
+
+func TestValidationFailures(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		alice, _ := c.signup("alice")
+		c.createPost(alice.Token, "post")
+
+		for _, tc := range []struct {
+			method, path string
+			body         any
+			// fields lists the failing fields as field:code, in order.
+			fields []string
+		}{
+			{http.MethodPost, "/signup", map[string]string{"username": "ab", "password": strings.Repeat("é", 37)},
+				[]string{"username:too_short", "password:too_long"}},
+			{http.MethodPost, "/signup", map[string]string{"username": "bad name!", "password": "password123", "userProfile": "a\x00b"},
+				[]string{"username:invalid_charset", "userProfile:invalid_charset"}},
+			{http.MethodPost, "/login", map[string]string{"username": "alice"},
+				[]string{"password:required"}},
+			{http.MethodPut, "/profile", map[string]string{"password": "short"},
+				[]string{"password:too_short"}},
+			{http.MethodPost, "/posts", map[string]string{"content": ""},
+				[]string{"content:required"}},
+			{http.MethodPut, "/posts/1", map[string]string{"content": strings.Repeat("x", 5001)},
+				[]string{"content:too_long"}},
+			{http.MethodPost, "/posts/1/comment", map[string]string{"content": strings.Repeat("x", 1001)},
+				[]string{"content:too_long"}},
+			{http.MethodPost, "/token/refresh", map[string]string{},
+				[]string{"refreshToken:required"}},
+		} {
+			apiErr := c.expectError(http.StatusUnprocessableEntity, "validation_failed", tc.method, tc.path, alice.Token, tc.body)
+			var got []string
+			for _, f := range apiErr.Fields {
+				got = append(got, f.Field+":"+f.Code)
+			}
+			if strings.Join(got, ",") != strings.Join(tc.fields, ",") {
+				t.Errorf("%s %s: got fields %v, want %v", tc.method, tc.path, got, tc.fields)
+			}
+		}
+
+		// Rejected requests change nothing.
+		c.expect(http.StatusOK, http.MethodPost, "/login", "", map[string]string{"username": "alice", "password": "password123"}, nil)
+		var detail types.PostDetail
+		c.expect(http.StatusOK, http.MethodGet, "/posts/1", alice.Token, nil, &detail)
+		if detail.Content != "post" || len(detail.Comments) != 0 {
+			t.Errorf("got %+v, want the post untouched", detail)
+		}
+	})
+}
+
+func TestAuthRequired(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		c.expectError(http.StatusUnauthorized, "invalid_token", http.MethodGet, "/profile", "", nil)
+		c.expectError(http.StatusUnauthorized, "invalid_token", http.MethodGet, "/feed", "garbage", nil)
+	})
+}
+
+func TestProfile(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		tokens, _ := c.signup("alice")
+
+		c.expect(http.StatusOK, http.MethodPut, "/profile", tokens.Token, map[string]string{"userProfile": "hello"}, nil)
+
+		var user types.User
+		c.expect(http.StatusOK, http.MethodGet, "/profile", tokens.Token, nil, &user)
+		if user.Username != "alice" || user.UserProfile != "hello" {
+			t.Errorf("unexpected profile: %+v", user)
+		}
+
+		// Updating only the profile must keep the password.
+		c.expect(http.StatusOK, http.MethodPost, "/login", "", map[string]string{"username": "alice", "password": "password123"}, nil)
+	})
+}
+
+func TestFeedPagination(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		alice, aliceID := c.signup("alice")
+		bob, bobID := c.signup("bob")
+		carol, _ := c.signup("carol")
+
+		for i := 0; i < 3; i++ {
+			c.createPost(alice.Token, fmt.Sprintf("alice %d", i))
+			c.createPost(bob.Token, fmt.Sprintf("bob %d", i))
+			c.createPost(carol.Token, fmt.Sprintf("carol %d", i))
+		}
+		c.expect(http.StatusOK, http.MethodPost, fmt.Sprintf("/users/%d/follow", bobID), alice.Token, nil, nil)
+
+		var contents []string
+		cursor := ""
+		for page := 0; ; page++ {
+			var resp feedResponse
+			c.expect(http.StatusOK, http.MethodGet, "/feed?limit=4&cursor="+cursor, alice.Token, nil, &resp)
+			for _, p := range resp.Posts {
+				contents = append(contents, p.Content)
+			}
+			if resp.NextCursor == "" {
+				break
+			}
+			if page > 3 {
+				t.Fatal("pagination does not terminate")
+			}
+			cursor = resp.NextCursor
+		}
+
+		want := []string{"bob 2", "alice 2", "bob 1", "alice 1", "bob 0", "alice 0"}
+		if strings.Join(contents, ",") != strings.Join(want, ",") {
+			t.Errorf("got feed %v, want %v", contents, want)
+		}
+
+		var resp feedResponse
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/users/%d/posts", aliceID), bob.Token, nil, &resp)
+		if len(resp.Posts) != 3 || resp.Posts[0].Content != "alice 2" {
+			t.Errorf("unexpected user posts: %+v", resp.Posts)
+		}
+
+		c.expectError(http.StatusBadRequest, "invalid_cursor", http.MethodGet, "/feed?cursor=!!", alice.Token, nil)
+		c.expectError(http.StatusBadRequest, "invalid_limit", http.MethodGet, "/feed?limit=0", alice.Token, nil)
+		c.expectError(http.StatusNotFound, "user_not_found", http.MethodGet, "/users/999/posts", alice.Token, nil)
+	})
+}
+
+func TestFeedPaginationEdges(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		alice, aliceID := c.signup("alice")
+		bob, _ := c.signup("bob")
+
+		var resp feedResponse
+		c.expect(http.StatusOK, http.MethodGet, "/feed", alice.Token, nil, &resp)
+		if resp.Posts == nil || len(resp.Posts) != 0 || resp.NextCursor != "" {
+			t.Errorf("got %+v for an empty feed, want an empty list and no cursor", resp)
+		}
+
+		for i := 0; i < 4; i++ {
+			c.createPost(alice.Token, fmt.Sprintf("post %d", i))
+		}
+		c.expect(http.StatusOK, http.MethodPost, "/posts/1/like", bob.Token, nil, nil)
+		c.expect(http.StatusOK, http.MethodPost, "/posts/1/comment", bob.Token, map[string]string{"content": "hi"}, nil)
+
+		// A page holding exactly what is left is the last one.
+		resp = feedResponse{}
+		c.expect(http.StatusOK, http.MethodGet, "/feed?limit=2", alice.Token, nil, &resp)
+		next := resp.NextCursor
+		resp = feedResponse{}
+		c.expect(http.StatusOK, http.MethodGet, "/feed?limit=2&cursor="+next, alice.Token, nil, &resp)
+		if len(resp.Posts) != 2 || resp.NextCursor != "" {
+			t.Fatalf("got %d posts and cursor %q, want the last 2 and no cursor", len(resp.Posts), resp.NextCursor)
+		}
+		if last := resp.Posts[1]; last.Content != "post 0" || last.LikeCount != 1 || last.CommentCount != 1 {
+			t.Errorf("got %+v, want the first post with its like and comment counted", last)
+		}
+
+		// Limits above the maximum are capped rather than rejected.
+		resp = feedResponse{}
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/users/%d/posts?limit=100000", aliceID), bob.Token, nil, &resp)
+		if len(resp.Posts) != 4 {
+			t.Errorf("got %d posts with a huge limit, want all 4", len(resp.Posts))
+		}
+
+		for _, cursor := range []string{
+			"!!",
+			base64.RawURLEncoding.EncodeToString([]byte("nope")),
+			base64.RawURLEncoding.EncodeToString([]byte("123:")),
+			base64.RawURLEncoding.EncodeToString([]byte("abc:1")),
+		} {
+			c.expectError(http.StatusBadRequest, "invalid_cursor", http.MethodGet, "/feed?cursor="+cursor, alice.Token, nil)
+		}
+		for _, limit := range []string{"0", "-1", "ten"} {
+			c.expectError(http.StatusBadRequest, "invalid_limit", http.MethodGet, "/feed?limit="+limit, alice.Token, nil)
+		}
+	})
+}
+
+func TestFollow(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		alice, aliceID := c.signup("alice")
+		bob, bobID := c.signup("bob")
+
+		follow := fmt.Sprintf("/users/%d/follow", bobID)
+		c.expect(http.StatusOK, http.MethodPost, follow, alice.Token, nil, nil)
+		c.expectError(http.StatusConflict, "already_following", http.MethodPost, follow, alice.Token, nil)
+		c.expectError(http.StatusUnprocessableEntity, "self_follow", http.MethodPost, fmt.Sprintf("/users/%d/follow", aliceID), alice.Token, nil)
+		c.expectError(http.StatusNotFound, "user_not_found", http.MethodPost, "/users/999/follow", alice.Token, nil)
+
+		var followers followListResponse
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/users/%d/followers", bobID), alice.Token, nil, &followers)
+		if len(followers.Users) != 1 || followers.Users[0].Username != "alice" {
+			t.Errorf("unexpected followers: %+v", followers.Users)
+		}
+
+		var following followListResponse
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/users/%d/following", aliceID), bob.Token, nil, &following)
+		if len(following.Users) != 1 || following.Users[0].Username != "bob" {
+			t.Errorf("unexpected following: %+v", following.Users)
+		}
+
+		var user types.User
+		c.expect(http.StatusOK, http.MethodGet, "/profile", bob.Token, nil, &user)
+		if user.FollowerCount != 1 || user.FollowingCount != 0 {
+			t.Errorf("got counts %d/%d, want 1/0", user.FollowerCount, user.FollowingCount)
+		}
+		// The rejected self and duplicate follows left nothing behind.
+		c.expect(http.StatusOK, http.MethodGet, "/profile", alice.Token, nil, &user)
+		if user.FollowerCount != 0 || user.FollowingCount != 1 {
+			t.Errorf("alice got counts %d/%d, want 0/1", user.FollowerCount, user.FollowingCount)
+		}
+
+		// Followers page newest first.
+		carol, _ := c.signup("carol")
+		c.expect(http.StatusOK, http.MethodPost, follow, carol.Token, nil, nil)
+		var names []string
+		cursor := ""
+		for page := 0; ; page++ {
+			var resp followListResponse
+			c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/users/%d/followers?limit=1&cursor=%s", bobID, cursor), bob.Token, nil, &resp)
+			for _, u := range resp.Users {
+				names = append(names, u.Username)
+			}
+			if resp.NextCursor == "" {
+				break
+			}
+			if page > 2 {
+				t.Fatal("pagination does not terminate")
+			}
+			cursor = resp.NextCursor
+		}
+		if strings.Join(names, ",") != "carol,alice" {
+			t.Errorf("got followers %v, want carol then alice", names)
+		}
+
+		c.expect(http.StatusOK, http.MethodDelete, follow, alice.Token, nil, nil)
+		c.expectError(http.StatusNotFound, "not_following", http.MethodDelete, follow, alice.Token, nil)
+	})
+}
+
+func TestPostLifecycle(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		alice, _ := c.signup("alice")
+		bob, _ := c.signup("bob")
+		c.createPost(alice.Token, "hello")
+
+		var feed feedResponse
+		c.expect(http.StatusOK, http.MethodGet, "/feed", alice.Token, nil, &feed)
+		postID := feed.Posts[0].ID
+		post := fmt.Sprintf("/posts/%d", postID)
+
+		var msg map[string]string
+		c.expect(http.StatusOK, http.MethodPost, post+"/like", bob.Token, nil, &msg)
+		if msg["msg"] != "post liked" {
+			t.Errorf("got %q, want %q", msg["msg"], "post liked")
+		}
+		c.expect(http.StatusOK, http.MethodPost, post+"/like", alice.Token, nil, nil)
+		c.expect(http.StatusOK, http.MethodPost, post+"/comment", bob.Token, map[string]string{"content": "nice"}, nil)
+
+		var detail types.PostDetail
+		c.expect(http.StatusOK, http.MethodGet, post, bob.Token, nil, &detail)
+		if detail.Author.Username != "alice" || detail.LikeCount != 2 || !detail.LikedByMe {
+			t.Errorf("unexpected post detail: %+v", detail)
+		}
+		if len(detail.Comments) != 1 || detail.Comments[0].Username != "bob" {
+			t.Fatalf("unexpected comments: %+v", detail.Comments)
+		}
+		commentID := detail.Comments[0].ID
+
+		var likers postLikersResponse
+		c.expect(http.StatusOK, http.MethodGet, post+"/likes?limit=1", bob.Token, nil, &likers)
+		if len(likers.Users) != 1 || likers.Users[0].Username != "alice" || likers.NextCursor == "" {
+			t.Errorf("unexpected likers page: %+v", likers)
+		}
+
+		c.expect(http.StatusOK, http.MethodPost, post+"/like", bob.Token, nil, &msg)
+		if msg["msg"] != "post unliked" {
+			t.Errorf("got %q, want %q", msg["msg"], "post unliked")
+		}
+
+		c.expectError(http.StatusForbidden, "permission_denied", http.MethodPut, post, bob.Token, map[string]string{"content": "hacked"})
+		c.expect(http.StatusOK, http.MethodPut, post, alice.Token, map[string]string{"content": "edited"}, nil)
+
+		comment := fmt.Sprintf("%s/comments/%d", post, commentID)
+		c.expectError(http.StatusForbidden, "permission_denied", http.MethodDelete, comment, alice.Token, nil)
+		c.expectError(http.StatusNotFound, "comment_not_found", http.MethodDelete, fmt.Sprintf("/posts/999/comments/%d", commentID), bob.Token, nil)
+		c.expect(http.StatusOK, http.MethodDelete, comment, bob.Token, nil, nil)
+		c.expectError(http.StatusNotFound, "comment_not_found", http.MethodDelete, comment, bob.Token, nil)
+
+		c.expect(http.StatusOK, http.MethodPost, post+"/comment", bob.Token, map[string]string{"content": "again"}, nil)
+		c.expectError(http.StatusForbidden, "permission_denied", http.MethodDelete, post, bob.Token, nil)
+		c.expect(http.StatusOK, http.MethodDelete, post, alice.Token, nil, nil)
+		c.expectError(http.StatusNotFound, "post_not_found", http.MethodGet, post, alice.Token, nil)
+		c.expectError(http.StatusNotFound, "post_not_found", http.MethodPost, post+"/like", alice.Token, nil)
+		c.expectError(http.StatusBadRequest, "invalid_id", http.MethodGet, "/posts/abc", alice.Token, nil)
+	})
+}
+
+// TestNotFoundBeforeForbidden checks that a missing resource is a 404 for
+// everyone, and that only existing resources of others are a 403.
+func TestNotFoundBeforeForbidden(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		alice, _ := c.signup("alice")
+		bob, _ := c.signup("bob")
+		carol, _ := c.signup("carol")
+		c.createPost(alice.Token, "alice's post")
+		c.createPost(bob.Token, "bob's post")
+		c.expect(http.StatusOK, http.MethodPost, "/posts/1/comment", bob.Token, map[string]string{"content": "hi"}, nil)
+
+		edit := map[string]string{"content": "edited"}
+		for _, tc := range []struct {
+			status       int
+			code, method string
+			path         string
+			body         any
+		}{
+			{http.StatusForbidden, "permission_denied", http.MethodPut, "/posts/1", edit},
+			{http.StatusNotFound, "post_not_found", http.MethodPut, "/posts/999", edit},
+			{http.StatusForbidden, "permission_denied", http.MethodDelete, "/posts/1", nil},
+			{http.StatusNotFound, "post_not_found", http.MethodDelete, "/posts/999", nil},
+			{http.StatusNotFound, "post_not_found", http.MethodGet, "/posts/999", nil},
+			{http.StatusNotFound, "post_not_found", http.MethodPost, "/posts/999/comment", map[string]string{"content": "hi"}},
+			{http.StatusForbidden, "permission_denied", http.MethodDelete, "/posts/1/comments/1", nil},
+			{http.StatusNotFound, "comment_not_found", http.MethodDelete, "/posts/1/comments/999", nil},
+			// A comment addressed through another post does not exist there.
+			{http.StatusNotFound, "comment_not_found", http.MethodDelete, "/posts/2/comments/1", nil},
+			{http.StatusNotFound, "user_not_found", http.MethodGet, "/users/999/followers", nil},
+		} {
+			apiErr := c.expectError(tc.status, tc.code, tc.method, tc.path, carol.Token, tc.body)
+			if apiErr.Error == "" {
+				t.Errorf("%s %s: got no error message", tc.method, tc.path)
+			}
+		}
+
+		// Once deleted, the owner's own post is missing rather than theirs.
+		c.expect(http.StatusOK, http.MethodDelete, "/posts/1", alice.Token, nil, nil)
+		c.expectError(http.StatusNotFound, "post_not_found", http.MethodPut, "/posts/1", alice.Token, edit)
+		c.expectError(http.StatusNotFound, "comment_not_found", http.MethodDelete, "/posts/1/comments/1", bob.Token, nil)
+	})
+}
+
+func TestPostDetail(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		alice, _ := c.signup("alice")
+		bob, _ := c.signup("bob")
+		carol, _ := c.signup("carol")
+		c.createPost(alice.Token, "hello")
+		c.expect(http.StatusOK, http.MethodPost, "/posts/1/like", bob.Token, nil, nil)
+		for _, content := range []string{"one", "two", "three"} {
+			c.expect(http.StatusOK, http.MethodPost, "/posts/1/comment", carol.Token, map[string]string{"content": content}, nil)
+		}
+
+		var detail types.PostDetail
+		c.expect(http.StatusOK, http.MethodGet, "/posts/1?limit=2", carol.Token, nil, &detail)
+		if detail.LikeCount != 1 || detail.LikedByMe || detail.Author.Username != "alice" {
+			t.Errorf("unexpected post detail for carol: %+v", detail)
+		}
+		if len(detail.Comments) != 2 || detail.Comments[0].Content != "three" || detail.NextCommentsCursor == "" {
+			t.Fatalf("got comments %+v, want the newest 2 and a cursor", detail.Comments)
+		}
+		next := detail.NextCommentsCursor
+		detail = types.PostDetail{}
+		c.expect(http.StatusOK, http.MethodGet, "/posts/1?limit=2&cursor="+next, carol.Token, nil, &detail)
+		if len(detail.Comments) != 1 || detail.Comments[0].Content != "one" || detail.Comments[0].Username != "carol" || detail.NextCommentsCursor != "" {
+			t.Errorf("got comments %+v on the last page, want the oldest", detail.Comments)
+		}
+
+		// The author comes without their password hash.
+		var raw map[string]any
+		c.expect(http.StatusOK, http.MethodGet, "/posts/1", bob.Token, nil, &raw)
+		author, _ := raw["author"].(map[string]any)
+		if _, ok := author["password"]; ok || author["username"] != "alice" || raw["likedByMe"] != true {
+			t.Errorf("unexpected post detail for bob: %v", raw)
+		}
+
+		var likers postLikersResponse
+		c.expect(http.StatusOK, http.MethodGet, "/posts/1/likes", alice.Token, nil, &likers)
+		if len(likers.Users) != 1 || likers.Users[0].Username != "bob" || likers.NextCursor != "" {
+			t.Errorf("unexpected likers: %+v", likers)
+		}
+		c.expectError(http.StatusNotFound, "post_not_found", http.MethodGet, "/posts/999/likes", alice.Token, nil)
+	})
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		first, _ := c.signup("alice")
+
+		second := new(types.TokenResponse)
+		c.expect(http.StatusOK, http.MethodPost, "/token/refresh", "", map[string]string{"refreshToken": first.RefreshToken}, second)
+		c.expect(http.StatusOK, http.MethodGet, "/profile", second.Token, nil, nil)
+
+		// Replaying the rotated token revokes the whole session.
+		c.expectError(http.StatusUnauthorized, "invalid_refresh_token", http.MethodPost, "/token/refresh", "",
+			map[string]string{"refreshToken": first.RefreshToken})
+		c.expectError(http.StatusUnauthorized, "invalid_token", http.MethodGet, "/profile", second.Token, nil)
+		c.expectError(http.StatusUnauthorized, "invalid_refresh_token", http.MethodPost, "/token/refresh", "",
+			map[string]string{"refreshToken": second.RefreshToken})
+	})
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		first, _ := c.signup("alice")
+		other := new(types.TokenResponse)
+		c.expect(http.StatusOK, http.MethodPost, "/login", "", map[string]string{"username": "alice", "password": "password123"}, other)
+
+		// Of two refreshes racing with one token, one wins and the other
+		// is taken for reuse.
+		statuses := make(chan int, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				statuses <- c.do(http.MethodPost, "/token/refresh", "", map[string]string{"refreshToken": first.RefreshToken}, nil)
+			}()
+		}
+		got := []int{<-statuses, <-statuses}
+		slices.Sort(got)
+		if got[0] != http.StatusOK || got[1] != http.StatusUnauthorized {
+			t.Errorf("got statuses %v, want one refresh and one rejection", got)
+		}
+		c.expectError(http.StatusUnauthorized, "invalid_token", http.MethodGet, "/profile", first.Token, nil)
+
+		// The reuse only ends the session it happened in.
+		c.expect(http.StatusOK, http.MethodGet, "/profile", other.Token, nil, nil)
+		c.expect(http.StatusOK, http.MethodPost, "/token/refresh", "", map[string]string{"refreshToken": other.RefreshToken}, nil)
+
+		c.expectError(http.StatusUnauthorized, "invalid_refresh_token", http.MethodPost, "/token/refresh", "",
+			map[string]string{"refreshToken": "unknown"})
+	})
+}
+
+func TestLogout(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		tokens, _ := c.signup("alice")
+
+		c.expect(http.StatusOK, http.MethodPost, "/logout", tokens.Token, nil, nil)
+		c.expectError(http.StatusUnauthorized, "invalid_token", http.MethodGet, "/profile", tokens.Token, nil)
+		c.expectError(http.StatusUnauthorized, "invalid_refresh_token", http.MethodPost, "/token/refresh", "",
+			map[string]string{"refreshToken": tokens.RefreshToken})
+	})
+}
+
+func TestPasswordChangeRevokesSessions(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		first, _ := c.signup("alice")
+
+		other := new(types.TokenResponse)
+		c.expect(http.StatusOK, http.MethodPost, "/login", "", map[string]string{"username": "alice", "password": "password123"}, other)
+
+		c.expect(http.StatusOK, http.MethodPut, "/profile", first.Token, map[string]string{"password": "new-password"}, nil)
+		for _, token := range []string{first.Token, other.Token} {
+			c.expectError(http.StatusUnauthorized, "invalid_token", http.MethodGet, "/profile", token, nil)
+		}
+
+		c.expect(http.StatusOK, http.MethodPost, "/login", "", map[string]string{"username": "alice", "password": "new-password"}, nil)
+	})
+}
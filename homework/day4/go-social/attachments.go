@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"gosocial/blob"
+	"gosocial/configs"
+	"gosocial/errs"
+	"gosocial/logging"
+	"gosocial/media"
+	"gosocial/types"
+)
+
+// attachmentField is the multipart field holding uploaded files.
+const attachmentField = "file"
+
+// multipartOverheadBytes is what an upload may add to its files: part
+// headers and boundaries.
+const multipartOverheadBytes = 64 << 10
+
+var errAttachmentNotFound = errs.NotFound("attachment_not_found", "attachment not found")
+
+type attachmentsResponse struct {
+	Attachments []*types.Attachment `json:"attachments"`
+}
+
+// newBlobStore opens the blob store selected by BLOB_STORE.
+func newBlobStore(c configs.Config) (blob.Store, error) {
+	switch c.BlobStore {
+	case "local":
+		return blob.NewLocalStore(c.BlobDir), nil
+	case "s3":
+		return blob.NewS3Store(blob.S3Config{
+			Endpoint:        c.S3Endpoint,
+			Region:          c.S3Region,
+			Bucket:          c.S3Bucket,
+			AccessKeyID:     c.S3AccessKeyID,
+			SecretAccessKey: c.S3SecretAccessKey,
+		})
+	default:
+		return nil, fmt.Errorf("unknown BLOB_STORE %q", c.BlobStore)
+	}
+}
+
+// newBlobKey returns a fresh key for an attachment of the post. Keys are
+// random so that a deleted attachment's blob is never reused.
+func newBlobKey(postID int) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("posts/%d/%s", postID, hex.EncodeToString(b)), nil
+}
+
+// setAttachmentURLs sets where the API serves the attachments.
+func setAttachmentURLs(attachments ...*types.Attachment) {
+	for _, a := range attachments {
+		a.URL = fmt.Sprintf("/attachments/%d", a.ID)
+		a.ThumbnailURL = fmt.Sprintf("/attachments/%d/thumbnail", a.ID)
+	}
+}
+
+// setPostAttachments sets the attachments of posts read from the store.
+func (s *apiServer) setPostAttachments(ctx context.Context, posts ...*types.Post) error {
+	ids := make([]int, len(posts))
+	for i, p := range posts {
+		ids[i] = p.ID
+	}
+	attachments, err := s.store.GetPostAttachments(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for _, p := range posts {
+		p.Attachments = attachments[p.ID]
+		if p.Attachments == nil {
+			p.Attachments = []*types.Attachment{}
+		}
+		setAttachmentURLs(p.Attachments...)
+	}
+	return nil
+}
+
+func (s *apiServer) setFeedPostAttachments(ctx context.Context, posts []*types.FeedPost) error {
+	ps := make([]*types.Post, len(posts))
+	for i, p := range posts {
+		ps[i] = &p.Post
+	}
+	return s.setPostAttachments(ctx, ps...)
+}
+
+// deleteAttachmentBlobs removes the images of deleted attachments. Their
+// records are already gone, so failures only leave unreachable blobs
+// behind and are logged.
+func (s *apiServer) deleteAttachmentBlobs(ctx context.Context, attachments ...*types.Attachment) {
+	for _, a := range attachments {
+		for _, key := range []string{a.BlobKey, a.ThumbnailKey} {
+			if err := s.blobs.Delete(ctx, key); err != nil {
+				logging.FromContext(ctx).Warn("deleting attachment blob failed", "attachment_id", a.ID, "key", key, "error", err)
+			}
+		}
+	}
+}
+
+// readAttachments reads and processes the files of a multipart upload.
+func readAttachments(w http.ResponseWriter, r *http.Request) ([]*media.Image, error) {
+	maxFiles := int(configs.Envs.MaxAttachmentsPerPost)
+	maxBytes := configs.Envs.MaxAttachmentBytes
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxFiles)*maxBytes+multipartOverheadBytes)
+	defer r.Body.Close()
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, errs.Wrap(errs.KindBadRequest, "invalid_multipart", "expected a multipart/form-data body", err)
+	}
+
+	opts := media.Options{
+		MaxPixels:     int(configs.Envs.MaxAttachmentPixels),
+		ThumbnailSize: int(configs.Envs.ThumbnailSize),
+	}
+	var images []*media.Image
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, multipartError(err)
+		}
+		if part.FormName() != attachmentField {
+			return nil, errs.BadRequest("unknown_field", fmt.Sprintf("unknown field %q, expected %q", part.FormName(), attachmentField))
+		}
+		if len(images) == maxFiles {
+			return nil, errs.Validation("too_many_attachments", fmt.Sprintf("a post can have at most %d attachments", maxFiles))
+		}
+
+		data, err := io.ReadAll(io.LimitReader(part, maxBytes+1))
+		if err != nil {
+			return nil, multipartError(err)
+		}
+		if int64(len(data)) > maxBytes {
+			return nil, errs.New(errs.KindTooLarge, "attachment_too_large", fmt.Sprintf("attachments are limited to %d bytes", maxBytes))
+		}
+
+		img, err := media.Process(data, opts)
+		switch {
+		case errors.Is(err, media.ErrUnsupportedType):
+			return nil, errs.New(errs.KindUnsupportedMediaType, "unsupported_media_type", "attachments must be JPEG, PNG or GIF images")
+		case errors.Is(err, media.ErrTooManyPixels):
+			return nil, errs.New(errs.KindTooLarge, "too_many_pixels", fmt.Sprintf("images are limited to %d pixels", opts.MaxPixels))
+		case errors.Is(err, media.ErrInvalidImage):
+			return nil, errs.Validation("invalid_image", "the image could not be decoded")
+		case err != nil:
+			return nil, err
+		}
+		images = append(images, img)
+	}
+
+	if len(images) == 0 {
+		return nil, errs.Validation("no_attachments", fmt.Sprintf("no files in the %q field", attachmentField))
+	}
+	return images, nil
+}
+
+func multipartError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return errs.Wrap(errs.KindTooLarge, "body_too_large", fmt.Sprintf("request body exceeds %d bytes", maxBytesErr.Limit), err)
+	}
+	return errs.Wrap(errs.KindBadRequest, "invalid_multipart", "invalid multipart body", err)
+}
+
+// handleUploadAttachments attaches the images uploaded in the file fields
+// of a multipart request to a post of the current user.
+func (s *apiServer) handleUploadAttachments(w http.ResponseWriter, r *http.Request) error {
+	postID, err := getID(r)
+	if err != nil {
+		return errInvalidID
+	}
+
+	post, err := s.store.GetPostByID(r.Context(), postID)
+	if err != nil {
+		return err
+	}
+
+	currentUserID := GetUserIDFromContext(r.Context())
+	if post.UserID != currentUserID {
+		return errPermissionDenied
+	}
+
+	images, err := readAttachments(w, r)
+	if err != nil {
+		return err
+	}
+
+	var attachments []*types.Attachment
+	for _, img := range images {
+		key, err := newBlobKey(post.ID)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(img.Data)
+		attachments = append(attachments, &types.Attachment{
+			ContentType:          img.ContentType,
+			Size:                 int64(len(img.Data)),
+			Width:                img.Width,
+			Height:               img.Height,
+			SHA256:               hex.EncodeToString(sum[:]),
+			BlobKey:              key,
+			ThumbnailKey:         key + "-thumbnail",
+			ThumbnailContentType: img.ThumbnailContentType,
+		})
+	}
+
+	// Blobs are written first, so that recorded attachments always have
+	// them; those of a failed upload are removed again.
+	err = s.putAttachmentBlobs(r.Context(), images, attachments)
+	if err == nil {
+		err = s.store.CreateAttachments(r.Context(), post.ID, attachments, int(configs.Envs.MaxAttachmentsPerPost))
+	}
+	if err != nil {
+		s.deleteAttachmentBlobs(r.Context(), attachments...)
+		return err
+	}
+
+	setAttachmentURLs(attachments...)
+	return WriteJSON(w, http.StatusCreated, &attachmentsResponse{Attachments: attachments})
+}
+
+func (s *apiServer) putAttachmentBlobs(ctx context.Context, images []*media.Image, attachments []*types.Attachment) error {
+	for i, img := range images {
+		a := attachments[i]
+		if err := s.blobs.Put(ctx, a.BlobKey, img.Data, a.ContentType); err != nil {
+			return err
+		}
+		if err := s.blobs.Put(ctx, a.ThumbnailKey, img.Thumbnail, a.ThumbnailContentType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleDeleteAttachment removes an attachment from a post of the current
+// user.
+func (s *apiServer) handleDeleteAttachment(w http.ResponseWriter, r *http.Request) error {
+	postID, err := getIntVar(r, "postID")
+	if err != nil {
+		return errInvalidID
+	}
+	attachmentID, err := getIntVar(r, "attachmentID")
+	if err != nil {
+		return errInvalidID
+	}
+
+	attachment, err := s.store.GetAttachmentByID(r.Context(), attachmentID)
+	if err != nil {
+		return err
+	}
+	if attachment.PostID != postID {
+		return errAttachmentNotFound
+	}
+
+	post, err := s.store.GetPostByID(r.Context(), postID)
+	if err != nil {
+		return err
+	}
+	currentUserID := GetUserIDFromContext(r.Context())
+	if post.UserID != currentUserID {
+		return errPermissionDenied
+	}
+
+	if err := s.store.DeleteAttachment(r.Context(), attachment.ID); err != nil {
+		return err
+	}
+	s.deleteAttachmentBlobs(r.Context(), attachment)
+
+	return WriteJSON(w, http.StatusOK, map[string]string{"msg": "attachment deleted"})
+}
+
+func (s *apiServer) handleGetAttachment(w http.ResponseWriter, r *http.Request) error {
+	return s.serveAttachment(w, r, false)
+}
+
+func (s *apiServer) handleGetAttachmentThumbnail(w http.ResponseWriter, r *http.Request) error {
+	return s.serveAttachment(w, r, true)
+}
+
+// serveAttachment serves an attachment or its thumbnail with support for
+// range and conditional requests.
+func (s *apiServer) serveAttachment(w http.ResponseWriter, r *http.Request, thumbnail bool) error {
+	id, err := getID(r)
+	if err != nil {
+		return errInvalidID
+	}
+
+	a, err := s.store.GetAttachmentByID(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	key, contentType, etag := a.BlobKey, a.ContentType, `"`+a.SHA256+`"`
+	if thumbnail {
+		key, contentType, etag = a.ThumbnailKey, a.ThumbnailContentType, `"`+a.SHA256+`-thumbnail"`
+	}
+	f, err := s.blobs.Open(r.Context(), key)
+	if errors.Is(err, blob.ErrNotFound) {
+		return errAttachmentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("ETag", etag)
+	// Attachments never change, and only signed-in users may see them, so
+	// browsers but not shared caches keep them.
+	h.Set("Cache-Control", "private, max-age=31536000, immutable")
+	h.Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", a.CreatedAt, f)
+	return nil
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"gosocial/blob"
+	"gosocial/configs"
+	"gosocial/types"
+)
+
+// forEachStorageWithBlobs is like forEachStorage but also passes the
+// directory of the server's blob store.
+func forEachStorageWithBlobs(t *testing.T, test func(t *testing.T, c *testClient, blobDir string)) {
+	for name, newStorage := range testStorages {
+		t.Run(name, func(t *testing.T) {
+			storage := newStorage(t)
+			if err := storage.Init(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			s, err := NewAPIServer("", storage, blob.NewLocalStore(dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv := httptest.NewServer(s.routes())
+			t.Cleanup(srv.Close)
+			test(t, &testClient{t: t, srv: srv}, dir)
+		})
+	}
+}
+
+// countBlobs returns the number of files in a blob store directory.
+func countBlobs(t *testing.T, dir string) int {
+	t.Helper()
+	n := 0
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			n++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// testJPEG returns a w×h JPEG image with an EXIF segment holding marker.
+func testJPEG(t *testing.T, w, h int, marker string) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	payload := append([]byte("Exif\x00\x00MM\x00*\x00\x00\x00\x08\x00\x00"), marker...)
+	seg := []byte{0xFF, 0xE1}
+	seg = binary.BigEndian.AppendUint16(seg, uint16(len(payload)+2))
+	seg = append(seg, payload...)
+	return append(append(append([]byte(nil), data[:2]...), seg...), data[2:]...)
+}
+
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	img.Set(0, 0, color.NRGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// upload posts files as the file fields of a multipart body and decodes the
+// JSON response into out. It returns the status code.
+func (c *testClient) upload(path, token string, files [][]byte, out any) int {
+	c.t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for i, f := range files {
+		part, err := mw.CreateFormFile(attachmentField, fmt.Sprintf("file%d", i))
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		part.Write(f)
+	}
+	mw.Close()
+
+	req, err := http.NewRequest(http.MethodPost, c.srv.URL+path, &body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Authorization", token)
+	return c.send(req, out)
+}
+
+// expectUploadError uploads files and checks the error response.
+func (c *testClient) expectUploadError(want int, code, path, token string, files ...[]byte) {
+	c.t.Helper()
+	var apiErr apiError
+	if got := c.upload(path, token, files, &apiErr); got != want || apiErr.Code != code {
+		c.t.Fatalf("POST %s: got %d %q, want %d %q", path, got, apiErr.Code, want, code)
+	}
+}
+
+func (c *testClient) send(req *http.Request, out any) int {
+	c.t.Helper()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			c.t.Fatalf("%s %s: decoding response: %v", req.Method, req.URL.Path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// download fetches path with the given headers and returns the response with
+// its body read.
+func (c *testClient) download(method, path, token string, header map[string]string) (*http.Response, []byte) {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.srv.URL+path, nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Authorization", token)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return resp, data
+}
+
+func TestAttachmentUpload(t *testing.T) {
+	forEachStorageWithBlobs(t, func(t *testing.T, c *testClient, blobDir string) {
+		alice, _ := c.signup("alice")
+		c.createPost(alice.Token, "holiday")
+
+		photo := testJPEG(t, 640, 480, "GPS 52.5N 13.4E")
+		var resp attachmentsResponse
+		if got := c.upload("/posts/1/attachments", alice.Token, [][]byte{photo, testPNG(t, 10, 20)}, &resp); got != http.StatusCreated {
+			t.Fatalf("got status %d, want 201", got)
+		}
+		if len(resp.Attachments) != 2 {
+			t.Fatalf("got %d attachments, want 2", len(resp.Attachments))
+		}
+		jpg, pic := resp.Attachments[0], resp.Attachments[1]
+		if jpg.ContentType != "image/jpeg" || jpg.Width != 640 || jpg.Height != 480 || jpg.PostID != 1 ||
+			jpg.URL != fmt.Sprintf("/attachments/%d", jpg.ID) || jpg.ThumbnailURL != fmt.Sprintf("/attachments/%d/thumbnail", jpg.ID) {
+			t.Errorf("unexpected attachment %+v", jpg)
+		}
+		if pic.ContentType != "image/png" || pic.Width != 10 || pic.Height != 20 {
+			t.Errorf("unexpected attachment %+v", pic)
+		}
+		if n := countBlobs(t, blobDir); n != 4 {
+			t.Errorf("got %d blobs, want the images and their thumbnails", n)
+		}
+
+		var detail types.PostDetail
+		c.expect(http.StatusOK, http.MethodGet, "/posts/1", alice.Token, nil, &detail)
+		if len(detail.Attachments) != 2 || detail.Attachments[0].ID != jpg.ID || detail.Attachments[0].URL != jpg.URL {
+			t.Errorf("post has attachments %+v", detail.Attachments)
+		}
+		c.createPost(alice.Token, "no pictures")
+		var feed feedResponse
+		c.expect(http.StatusOK, http.MethodGet, "/feed", alice.Token, nil, &feed)
+		if len(feed.Posts) != 2 || feed.Posts[0].Attachments == nil || len(feed.Posts[0].Attachments) != 0 ||
+			len(feed.Posts[1].Attachments) != 2 {
+			t.Errorf("feed has attachments %v and %v", feed.Posts[0].Attachments, feed.Posts[1].Attachments)
+		}
+
+		// Other users can see the attachments too.
+		bob, _ := c.signup("bob")
+		res, data := c.download(http.MethodGet, jpg.URL, bob.Token, nil)
+		if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "image/jpeg" || int64(len(data)) != jpg.Size {
+			t.Fatalf("got %d %q with %d bytes", res.StatusCode, res.Header.Get("Content-Type"), len(data))
+		}
+		if bytes.Contains(data, []byte("GPS")) || bytes.Contains(data, []byte("Exif")) {
+			t.Error("the EXIF data was not stripped")
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+			t.Errorf("stored image does not decode: %v", err)
+		}
+		etag := res.Header.Get("ETag")
+		if etag == "" || res.Header.Get("Cache-Control") != "private, max-age=31536000, immutable" ||
+			res.Header.Get("Accept-Ranges") != "bytes" || res.Header.Get("Last-Modified") == "" {
+			t.Errorf("missing caching headers: %v", res.Header)
+		}
+
+		res, part := c.download(http.MethodGet, jpg.URL, bob.Token, map[string]string{"Range": "bytes=10-19"})
+		if res.StatusCode != http.StatusPartialContent || !bytes.Equal(part, data[10:20]) ||
+			res.Header.Get("Content-Range") != fmt.Sprintf("bytes 10-19/%d", len(data)) {
+			t.Errorf("got %d %q with %d bytes, want bytes 10-19", res.StatusCode, res.Header.Get("Content-Range"), len(part))
+		}
+		res, _ = c.download(http.MethodGet, jpg.URL, bob.Token, map[string]string{"Range": fmt.Sprintf("bytes=%d-", len(data))})
+		if res.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+			t.Errorf("range past the end: got %d, want 416", res.StatusCode)
+		}
+		res, _ = c.download(http.MethodGet, jpg.URL, bob.Token, map[string]string{"If-None-Match": etag})
+		if res.StatusCode != http.StatusNotModified {
+			t.Errorf("If-None-Match: got %d, want 304", res.StatusCode)
+		}
+		res, body := c.download(http.MethodHead, jpg.URL, bob.Token, nil)
+		if res.StatusCode != http.StatusOK || len(body) != 0 || res.ContentLength != jpg.Size {
+			t.Errorf("HEAD: got %d with length %d", res.StatusCode, res.ContentLength)
+		}
+
+		res, thumb := c.download(http.MethodGet, jpg.ThumbnailURL, bob.Token, nil)
+		if res.StatusCode != http.StatusOK || res.Header.Get("ETag") == etag {
+			t.Fatalf("thumbnail: got %d with ETag %s", res.StatusCode, res.Header.Get("ETag"))
+		}
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(thumb))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := int(configs.Envs.ThumbnailSize); cfg.Width != size || cfg.Height != size*3/4 {
+			t.Errorf("thumbnail is %dx%d", cfg.Width, cfg.Height)
+		}
+
+		c.expectError(http.StatusNotFound, "attachment_not_found", http.MethodGet, "/attachments/99", bob.Token, nil)
+		c.expectError(http.StatusUnauthorized, "invalid_token", http.MethodGet, jpg.URL, "", nil)
+	})
+}
+
+func TestAttachmentUploadRejects(t *testing.T) {
+	forEachStorageWithBlobs(t, func(t *testing.T, c *testClient, blobDir string) {
+		alice, _ := c.signup("alice")
+		bob, _ := c.signup("bob")
+		c.createPost(alice.Token, "first")
+		img := testPNG(t, 4, 4)
+
+		c.expectUploadError(http.StatusForbidden, "permission_denied", "/posts/1/attachments", bob.Token, img)
+		c.expectUploadError(http.StatusNotFound, "post_not_found", "/posts/9/attachments", alice.Token, img)
+		c.expectUploadError(http.StatusUnsupportedMediaType, "unsupported_media_type", "/posts/1/attachments", alice.Token,
+			[]byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`))
+		c.expectUploadError(http.StatusUnprocessableEntity, "invalid_image", "/posts/1/attachments", alice.Token, img[:len(img)/2])
+		c.expectUploadError(http.StatusUnprocessableEntity, "no_attachments", "/posts/1/attachments", alice.Token)
+		c.expectError(http.StatusBadRequest, "invalid_multipart", http.MethodPost, "/posts/1/attachments", alice.Token, map[string]string{"file": "x"})
+
+		max := int(configs.Envs.MaxAttachmentsPerPost)
+		files := make([][]byte, max+1)
+		for i := range files {
+			files[i] = img
+		}
+		c.expectUploadError(http.StatusUnprocessableEntity, "too_many_attachments", "/posts/1/attachments", alice.Token, files...)
+		// The limit counts the attachments the post already has.
+		if got := c.upload("/posts/1/attachments", alice.Token, files[:max-1], nil); got != http.StatusCreated {
+			t.Fatalf("got status %d, want 201", got)
+		}
+		c.expectUploadError(http.StatusUnprocessableEntity, "too_many_attachments", "/posts/1/attachments", alice.Token, img, img)
+
+		c.createPost(alice.Token, "second")
+		maxBytes, maxPixels := configs.Envs.MaxAttachmentBytes, configs.Envs.MaxAttachmentPixels
+		t.Cleanup(func() {
+			configs.Envs.MaxAttachmentBytes, configs.Envs.MaxAttachmentPixels = maxBytes, maxPixels
+		})
+		configs.Envs.MaxAttachmentPixels = 15
+		c.expectUploadError(http.StatusRequestEntityTooLarge, "too_many_pixels", "/posts/2/attachments", alice.Token, img)
+		configs.Envs.MaxAttachmentBytes = int64(len(img) - 1)
+		c.expectUploadError(http.StatusRequestEntityTooLarge, "attachment_too_large", "/posts/2/attachments", alice.Token, img)
+
+		// Nothing of the rejected uploads is kept.
+		if n := countBlobs(t, blobDir); n != 2*(max-1) {
+			t.Errorf("got %d blobs, want %d", n, 2*(max-1))
+		}
+	})
+}
+
+func TestAttachmentDelete(t *testing.T) {
+	forEachStorageWithBlobs(t, func(t *testing.T, c *testClient, blobDir string) {
+		alice, _ := c.signup("alice")
+		bob, _ := c.signup("bob")
+		c.createPost(alice.Token, "first")
+		c.createPost(alice.Token, "second")
+		img := testPNG(t, 4, 4)
+		var first, second attachmentsResponse
+		c.upload("/posts/1/attachments", alice.Token, [][]byte{img, img}, &first)
+		c.upload("/posts/2/attachments", alice.Token, [][]byte{img}, &second)
+		if n := countBlobs(t, blobDir); n != 6 {
+			t.Fatalf("got %d blobs, want 6", n)
+		}
+
+		a := first.Attachments[0]
+		path := fmt.Sprintf("/posts/1/attachments/%d", a.ID)
+		c.expectError(http.StatusForbidden, "permission_denied", http.MethodDelete, path, bob.Token, nil)
+		c.expectError(http.StatusNotFound, "attachment_not_found", http.MethodDelete,
+			fmt.Sprintf("/posts/2/attachments/%d", a.ID), alice.Token, nil)
+		c.expect(http.StatusOK, http.MethodDelete, path, alice.Token, nil, nil)
+		c.expectError(http.StatusNotFound, "attachment_not_found", http.MethodGet, a.URL, alice.Token, nil)
+		c.expectError(http.StatusNotFound, "attachment_not_found", http.MethodDelete, path, alice.Token, nil)
+		if n := countBlobs(t, blobDir); n != 4 {
+			t.Errorf("got %d blobs after deleting an attachment, want 4", n)
+		}
+
+		// Deleting a post deletes its attachments.
+		c.expect(http.StatusOK, http.MethodDelete, "/posts/1", alice.Token, nil, nil)
+		c.expectError(http.StatusNotFound, "attachment_not_found", http.MethodGet, first.Attachments[1].URL, alice.Token, nil)
+		if n := countBlobs(t, blobDir); n != 2 {
+			t.Errorf("got %d blobs after deleting a post, want 2", n)
+		}
+		c.expect(http.StatusOK, http.MethodGet, second.Attachments[0].URL, alice.Token, nil, nil)
+	})
+}
@@ -0,0 +1,41 @@
+// Package blob stores files by key, on the local filesystem or in an
+// S3-compatible object store.
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+// ErrNotFound is returned for keys that hold no blob.
+var ErrNotFound = errors.New("blob not found")
+
+// Store holds blobs under slash-separated keys.
+type Store interface {
+	// Put stores data under key, replacing any blob already there.
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Open returns the blob under key. Reads may be issued lazily, so the
+	// blob can be served in ranges without reading all of it.
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Delete removes the blob under key. Deleting a missing blob is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+}
+
+var errInvalidKey = errors.New("invalid blob key")
+
+// validKey reports whether key is a relative slash-separated path without
+// empty, "." or ".." elements, so that it cannot escape the store.
+func validKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, elem := range strings.Split(key, "/") {
+		if elem == "" || elem == "." || elem == ".." || strings.ContainsAny(elem, "\\\x00") {
+			return false
+		}
+	}
+	return true
+}
@@ -7,14 +7,68 @@ import (
 )
 
 type Config struct {
-	PublicHost             string
-	Port                   string
-	DBUser                 string
-	DBPassword             string
-	DBAddress              string
-	DBName                 string
-	JWTSecret              string
-	JWTExpirationInSeconds int64
+	PublicHost                      string
+	Port                            string
+	LogLevel                        string
+	LogFormat                       string
+	ReadTimeoutInSeconds            int64
+	ReadHeaderTimeoutInSeconds      int64
+	WriteTimeoutInSeconds           int64
+	IdleTimeoutInSeconds            int64
+	MaxHeaderBytes                  int64
+	ShutdownTimeoutInSeconds        int64
+	ReadinessTimeoutInSeconds       int64
+	DBDriver                        string
+	DBPath                          string
+	DBUser                          string
+	DBPassword                      string
+	DBAddress                       string
+	DBName                          string
+	DBMaxOpenConns                  int64
+	DBMaxIdleConns                  int64
+	DBConnMaxLifetimeInSeconds      int64
+	DBConnMaxIdleTimeInSeconds      int64
+	DBQueryTimeoutInSeconds         int64
+	JWTSecret                       string
+	JWTSigningKeyFile               string
+	JWTVerificationKeyFiles         string
+	JWTIssuer                       string
+	JWTAudience                     string
+	JWTLeewayInSeconds              int64
+	JWTExpirationInSeconds          int64
+	RefreshTokenExpirationInSeconds int64
+	DefaultPageSize                 int64
+	MaxPageSize                     int64
+	MaxRequestBodyBytes             int64
+	TrustedProxies                  string
+	RateLimitAuthPerMinute          int64
+	RateLimitAuthBurst              int64
+	RateLimitWritePerMinute         int64
+	RateLimitWriteBurst             int64
+	RateLimitReadPerMinute          int64
+	RateLimitReadBurst              int64
+	RateLimitIPPerMinute            int64
+	RateLimitIPBurst                int64
+	LoginMaxFailuresPerUser         int64
+	LoginMaxFailuresPerIP           int64
+	LoginFailureWindowInSeconds     int64
+	LoginLockoutInSeconds           int64
+	LoginDelayBaseInMillis          int64
+	LoginDelayMaxInMillis           int64
+	StreamHeartbeatInSeconds        int64
+	StreamBufferSize                int64
+	StreamHistorySize               int64
+	BlobStore                       string
+	BlobDir                         string
+	S3Endpoint                      string
+	S3Region                        string
+	S3Bucket                        string
+	S3AccessKeyID                   string
+	S3SecretAccessKey               string
+	MaxAttachmentBytes              int64
+	MaxAttachmentPixels             int64
+	MaxAttachmentsPerPost           int64
+	ThumbnailSize                   int64
 }
 
 var Envs = initConfig()
@@ -22,14 +76,68 @@ var Envs = initConfig()
 func initConfig() Config {
 
 	return Config{
-		PublicHost:             getEnv("PUBLIC_HOST", "http://localhost"),
-		Port:                   getEnv("PORT", "8080"),
-		DBUser:                 getEnv("DB_USER", "root"),
-		DBPassword:             getEnv("DB_PASSWORD", "1234"),
-		DBAddress:              fmt.Sprintf("%s:%s", getEnv("DB_HOST", "127.0.0.1"), getEnv("DB_PORT", "4000")),
-		DBName:                 getEnv("DB_NAME", "testdb"),
-		JWTSecret:              getEnv("JWT_SECRET", "jwtsecret"),
-		JWTExpirationInSeconds: getEnvAsInt("JWT_EXPIRATION_IN_SECONDS", 3600 * 24 * 7),
+		PublicHost:                      getEnv("PUBLIC_HOST", "http://localhost"),
+		Port:                            getEnv("PORT", "8080"),
+		LogLevel:                        getEnv("LOG_LEVEL", "info"),
+		LogFormat:                       getEnv("LOG_FORMAT", "json"),
+		ReadTimeoutInSeconds:            getEnvAsInt("READ_TIMEOUT_IN_SECONDS", 15),
+		ReadHeaderTimeoutInSeconds:      getEnvAsInt("READ_HEADER_TIMEOUT_IN_SECONDS", 5),
+		WriteTimeoutInSeconds:           getEnvAsInt("WRITE_TIMEOUT_IN_SECONDS", 30),
+		IdleTimeoutInSeconds:            getEnvAsInt("IDLE_TIMEOUT_IN_SECONDS", 120),
+		MaxHeaderBytes:                  getEnvAsInt("MAX_HEADER_BYTES", 1<<16),
+		ShutdownTimeoutInSeconds:        getEnvAsInt("SHUTDOWN_TIMEOUT_IN_SECONDS", 20),
+		ReadinessTimeoutInSeconds:       getEnvAsInt("READINESS_TIMEOUT_IN_SECONDS", 2),
+		DBDriver:                        getEnv("DB_DRIVER", "mysql"),
+		DBPath:                          getEnv("DB_PATH", "gosocial.db"),
+		DBUser:                          getEnv("DB_USER", "root"),
+		DBPassword:                      getEnv("DB_PASSWORD", "1234"),
+		DBAddress:                       fmt.Sprintf("%s:%s", getEnv("DB_HOST", "127.0.0.1"), getEnv("DB_PORT", "4000")),
+		DBName:                          getEnv("DB_NAME", "testdb"),
+		DBMaxOpenConns:                  getEnvAsInt("DB_MAX_OPEN_CONNS", 25),
+		DBMaxIdleConns:                  getEnvAsInt("DB_MAX_IDLE_CONNS", 25),
+		DBConnMaxLifetimeInSeconds:      getEnvAsInt("DB_CONN_MAX_LIFETIME_IN_SECONDS", 60*5),
+		DBConnMaxIdleTimeInSeconds:      getEnvAsInt("DB_CONN_MAX_IDLE_TIME_IN_SECONDS", 60),
+		DBQueryTimeoutInSeconds:         getEnvAsInt("DB_QUERY_TIMEOUT_IN_SECONDS", 5),
+		JWTSecret:                       getEnv("JWT_SECRET", "jwtsecret"),
+		JWTSigningKeyFile:               getEnv("JWT_SIGNING_KEY_FILE", ""),
+		JWTVerificationKeyFiles:         getEnv("JWT_VERIFICATION_KEY_FILES", ""),
+		JWTIssuer:                       getEnv("JWT_ISSUER", "gosocial"),
+		JWTAudience:                     getEnv("JWT_AUDIENCE", "gosocial"),
+		JWTLeewayInSeconds:              getEnvAsInt("JWT_LEEWAY_IN_SECONDS", 30),
+		JWTExpirationInSeconds:          getEnvAsInt("JWT_EXPIRATION_IN_SECONDS", 60*15),
+		RefreshTokenExpirationInSeconds: getEnvAsInt("REFRESH_TOKEN_EXPIRATION_IN_SECONDS", 3600*24*30),
+		DefaultPageSize:                 getEnvAsInt("DEFAULT_PAGE_SIZE", 20),
+		MaxPageSize:                     getEnvAsInt("MAX_PAGE_SIZE", 100),
+		MaxRequestBodyBytes:             getEnvAsInt("MAX_REQUEST_BODY_BYTES", 1<<20),
+		TrustedProxies:                  getEnv("TRUSTED_PROXIES", ""),
+		RateLimitAuthPerMinute:          getEnvAsInt("RATE_LIMIT_AUTH_PER_MINUTE", 20),
+		RateLimitAuthBurst:              getEnvAsInt("RATE_LIMIT_AUTH_BURST", 20),
+		RateLimitWritePerMinute:         getEnvAsInt("RATE_LIMIT_WRITE_PER_MINUTE", 60),
+		RateLimitWriteBurst:             getEnvAsInt("RATE_LIMIT_WRITE_BURST", 30),
+		RateLimitReadPerMinute:          getEnvAsInt("RATE_LIMIT_READ_PER_MINUTE", 600),
+		RateLimitReadBurst:              getEnvAsInt("RATE_LIMIT_READ_BURST", 120),
+		RateLimitIPPerMinute:            getEnvAsInt("RATE_LIMIT_IP_PER_MINUTE", 1200),
+		RateLimitIPBurst:                getEnvAsInt("RATE_LIMIT_IP_BURST", 240),
+		LoginMaxFailuresPerUser:         getEnvAsInt("LOGIN_MAX_FAILURES_PER_USER", 5),
+		LoginMaxFailuresPerIP:           getEnvAsInt("LOGIN_MAX_FAILURES_PER_IP", 50),
+		LoginFailureWindowInSeconds:     getEnvAsInt("LOGIN_FAILURE_WINDOW_IN_SECONDS", 60*15),
+		LoginLockoutInSeconds:           getEnvAsInt("LOGIN_LOCKOUT_IN_SECONDS", 60*15),
+		LoginDelayBaseInMillis:          getEnvAsInt("LOGIN_DELAY_BASE_IN_MILLIS", 250),
+		LoginDelayMaxInMillis:           getEnvAsInt("LOGIN_DELAY_MAX_IN_MILLIS", 4000),
+		StreamHeartbeatInSeconds:        getEnvAsInt("STREAM_HEARTBEAT_IN_SECONDS", 15),
+		StreamBufferSize:                getEnvAsInt("STREAM_BUFFER_SIZE", 64),
+		StreamHistorySize:               getEnvAsInt("STREAM_HISTORY_SIZE", 1024),
+		BlobStore:                       getEnv("BLOB_STORE", "local"),
+		BlobDir:                         getEnv("BLOB_DIR", "blobs"),
+		S3Endpoint:                      getEnv("S3_ENDPOINT", ""),
+		S3Region:                        getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:                        getEnv("S3_BUCKET", ""),
+		S3AccessKeyID:                   getEnv("S3_ACCESS_KEY_ID", ""),
+		S3SecretAccessKey:               getEnv("S3_SECRET_ACCESS_KEY", ""),
+		MaxAttachmentBytes:              getEnvAsInt("MAX_ATTACHMENT_BYTES", 10<<20),
+		MaxAttachmentPixels:             getEnvAsInt("MAX_ATTACHMENT_PIXELS", 40_000_000),
+		MaxAttachmentsPerPost:           getEnvAsInt("MAX_ATTACHMENTS_PER_POST", 4),
+		ThumbnailSize:                   getEnvAsInt("THUMBNAIL_SIZE", 320),
 	}
 }
 
@@ -53,4 +161,4 @@ func getEnvAsInt(key string, fallback int64) int64 {
 	}
 
 	return fallback
-}
\ No newline at end of file
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"gosocial/entities"
+	"gosocial/errs"
+	"gosocial/types"
+
+	"github.com/gorilla/mux"
+)
+
+// parseEntities returns the entities of content about to be written, with
+// the mentions of existing users linked to them and the others dropped.
+func (s *apiServer) parseEntities(ctx context.Context, content string) ([]types.Entity, error) {
+	ents := entities.Parse(content)
+	users, err := s.store.GetUsersByUsernames(ctx, entities.Usernames(ents))
+	if err != nil {
+		return nil, err
+	}
+	return entities.Link(ents, users), nil
+}
+
+// setPostEntities sets the entities of posts read from the store. Mentions
+// are linked to the users recorded when the post was written, so that a
+// user signing up later under a mentioned name does not take the mention.
+func (s *apiServer) setPostEntities(ctx context.Context, posts ...*types.Post) error {
+	ids := make([]int, len(posts))
+	for i, p := range posts {
+		ids[i] = p.ID
+	}
+	mentioned, err := s.store.GetPostMentions(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for _, p := range posts {
+		p.Entities = entities.Link(entities.Parse(p.Content), mentioned[p.ID])
+	}
+	return nil
+}
+
+func (s *apiServer) setFeedPostEntities(ctx context.Context, posts []*types.FeedPost) error {
+	ps := make([]*types.Post, len(posts))
+	for i, p := range posts {
+		ps[i] = &p.Post
+	}
+	return s.setPostEntities(ctx, ps...)
+}
+
+// setCommentEntities is setPostEntities for comments.
+func (s *apiServer) setCommentEntities(ctx context.Context, comments ...*types.PostComment) error {
+	ids := make([]int, len(comments))
+	for i, c := range comments {
+		ids[i] = c.ID
+	}
+	mentioned, err := s.store.GetCommentMentions(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for _, c := range comments {
+		c.Entities = entities.Link(entities.Parse(c.Content), mentioned[c.ID])
+	}
+	return nil
+}
+
+// handleGetTagPosts returns the posts tagged with the hashtag in the path,
+// given with or without its '#', newest first.
+func (s *apiServer) handleGetTagPosts(w http.ResponseWriter, r *http.Request) error {
+	tag, ok := entities.NormalizeTag(mux.Vars(r)["tag"])
+	if !ok {
+		return errs.BadRequest("invalid_tag", "invalid hashtag")
+	}
+
+	page, err := getPage(r)
+	if err != nil {
+		return err
+	}
+
+	posts, err := s.store.GetPostsByTag(r.Context(), tag, page.Cursor, page.Limit+1)
+	if err != nil {
+		return err
+	}
+
+	resp := newFeedResponse(posts, page.Limit)
+	if err := s.setFeedPostEntities(r.Context(), resp.Posts); err != nil {
+		return err
+	}
+	if err := s.setFeedPostAttachments(r.Context(), resp.Posts); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, resp)
+}
+
+// handleGetUserMentions returns the posts and comments mentioning the user,
+// most recent mention first.
+func (s *apiServer) handleGetUserMentions(w http.ResponseWriter, r *http.Request) error {
+	userID, err := getID(r)
+	if err != nil {
+		return errInvalidID
+	}
+
+	page, err := getPage(r)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	mentions, err := s.store.GetUserMentions(r.Context(), user.ID, page.Cursor, page.Limit+1)
+	if err != nil {
+		return err
+	}
+
+	resp := newMentionsResponse(mentions, page.Limit)
+	var posts []*types.Post
+	var comments []*types.PostComment
+	for _, m := range resp.Mentions {
+		posts = append(posts, &m.Post)
+		if m.Comment != nil {
+			comments = append(comments, m.Comment)
+		}
+	}
+	if err := s.setPostEntities(r.Context(), posts...); err != nil {
+		return err
+	}
+	if err := s.setPostAttachments(r.Context(), posts...); err != nil {
+		return err
+	}
+	if err := s.setCommentEntities(r.Context(), comments...); err != nil {
+		return err
+	}
+	return WriteJSON(w, http.StatusOK, resp)
+}
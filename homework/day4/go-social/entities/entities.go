@@ -0,0 +1,165 @@
+// Package entities finds the hashtags and @mentions in the content of posts
+// and comments.
+package entities
+
+import (
+	"strings"
+	"unicode"
+
+	"gosocial/types"
+)
+
+const (
+	maxTagLength = 100
+	// Mentions follow the username rules of signup: 3 to 50 ASCII letters,
+	// digits, '_' and '.'.
+	minUsernameLength = 3
+	maxUsernameLength = 50
+)
+
+// Parse returns the hashtags and mentions of text in order of appearance.
+// A '#' or '@' only starts an entity at the beginning of a word, so that
+// e-mail addresses and things like "C#" are left alone. Hashtags consist of
+// letters, digits, marks and '_' and need at least one non-digit. Offsets
+// count characters (Unicode code points). Mentions are not resolved.
+func Parse(text string) []types.Entity {
+	runes := []rune(text)
+	ents := []types.Entity{}
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if (r != '#' && r != '@') || (i > 0 && !startsEntity(runes[i-1])) {
+			continue
+		}
+
+		var end int
+		if r == '#' {
+			end = scan(runes, i+1, isTagRune)
+			tag := string(runes[i+1 : end])
+			if n := end - i - 1; n == 0 || n > maxTagLength || strings.IndexFunc(tag, isNotDigit) < 0 {
+				continue
+			}
+			ents = append(ents, types.Entity{
+				Type:  types.EntityHashtag,
+				Start: i,
+				End:   end,
+				Text:  string(runes[i:end]),
+				Tag:   strings.ToLower(tag),
+			})
+		} else {
+			end = scan(runes, i+1, isUsernameRune)
+			// A trailing dot ends the sentence rather than the username.
+			for end > i+1 && runes[end-1] == '.' {
+				end--
+			}
+			if n := end - i - 1; n < minUsernameLength || n > maxUsernameLength {
+				continue
+			}
+			ents = append(ents, types.Entity{
+				Type:     types.EntityMention,
+				Start:    i,
+				End:      end,
+				Text:     string(runes[i:end]),
+				Username: string(runes[i+1 : end]),
+			})
+		}
+		i = end - 1
+	}
+	return ents
+}
+
+// NormalizeTag returns the stored form of a hashtag given with or without
+// its '#', and false if it is not a valid hashtag.
+func NormalizeTag(tag string) (string, bool) {
+	ents := Parse("#" + strings.TrimPrefix(tag, "#"))
+	if len(ents) != 1 || ents[0].Text != "#"+strings.TrimPrefix(tag, "#") {
+		return "", false
+	}
+	return ents[0].Tag, true
+}
+
+// Tags returns the distinct tags of the hashtags among ents.
+func Tags(ents []types.Entity) []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, e := range ents {
+		if e.Type == types.EntityHashtag && !seen[e.Tag] {
+			seen[e.Tag] = true
+			tags = append(tags, e.Tag)
+		}
+	}
+	return tags
+}
+
+// Usernames returns the distinct usernames of the mentions among ents.
+func Usernames(ents []types.Entity) []string {
+	var usernames []string
+	seen := map[string]bool{}
+	for _, e := range ents {
+		if e.Type == types.EntityMention && !seen[e.Username] {
+			seen[e.Username] = true
+			usernames = append(usernames, e.Username)
+		}
+	}
+	return usernames
+}
+
+// Link sets the user ID of every mention of one of users and drops the
+// mentions of anyone else. Usernames are compared case-insensitively, as
+// store.GetUsersByUsernames does.
+func Link(ents []types.Entity, users []types.UserSummary) []types.Entity {
+	linked := make([]types.Entity, 0, len(ents))
+	for _, e := range ents {
+		if e.Type == types.EntityMention {
+			e.UserID = 0
+			for _, u := range users {
+				if strings.EqualFold(u.Username, e.Username) {
+					e.UserID = u.ID
+					break
+				}
+			}
+			if e.UserID == 0 {
+				continue
+			}
+		}
+		linked = append(linked, e)
+	}
+	return linked
+}
+
+// MentionedUserIDs returns the distinct user IDs of the linked mentions
+// among ents.
+func MentionedUserIDs(ents []types.Entity) []int {
+	var ids []int
+	seen := map[int]bool{}
+	for _, e := range ents {
+		if e.Type == types.EntityMention && e.UserID != 0 && !seen[e.UserID] {
+			seen[e.UserID] = true
+			ids = append(ids, e.UserID)
+		}
+	}
+	return ids
+}
+
+func scan(runes []rune, i int, ok func(rune) bool) int {
+	for i < len(runes) && ok(runes[i]) {
+		i++
+	}
+	return i
+}
+
+// startsEntity reports whether a '#' or '@' after r begins a word.
+func startsEntity(r rune) bool {
+	return !isTagRune(r) && r != '#' && r != '@' && r != '&'
+}
+
+func isTagRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.In(r, unicode.Mn, unicode.Mc) || r == '_'
+}
+
+func isUsernameRune(r rune) bool {
+	return r <= unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.')
+}
+
+func isNotDigit(r rune) bool {
+	return !unicode.IsDigit(r)
+}
@@ -0,0 +1,80 @@
+package entities
+
+import (
+	"reflect"
+	"testing"
+
+	"gosocial/types"
+)
+
+func hashtag(start, end int, text, tag string) types.Entity {
+	return types.Entity{Type: types.EntityHashtag, Start: start, End: end, Text: text, Tag: tag}
+}
+
+func mention(start, end int, text string) types.Entity {
+	return types.Entity{Type: types.EntityMention, Start: start, End: end, Text: text, Username: text[1:]}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text string
+		want []types.Entity
+	}{
+		{"hi @alice, see #GoLang!", []types.Entity{mention(3, 9, "@alice"), hashtag(15, 22, "#GoLang", "golang")}},
+		// Offsets count characters, not bytes.
+		{"Café #Zürich @bob.", []types.Entity{hashtag(5, 12, "#Zürich", "zürich"), mention(13, 17, "@bob")}},
+		{"#東京 #snake_case", []types.Entity{hashtag(0, 3, "#東京", "東京"), hashtag(4, 15, "#snake_case", "snake_case")}},
+		{"@first.last went", []types.Entity{mention(0, 11, "@first.last")}},
+		// Not at the start of a word.
+		{"mail me@example.com about C# or a#b", []types.Entity{}},
+		// Numbers, fragments and names too short or empty.
+		{"#123 &#39; @ab # @", []types.Entity{}},
+		{"##go @@bob", []types.Entity{}},
+		{"(#go)", []types.Entity{hashtag(1, 4, "#go", "go")}},
+	}
+	for _, tt := range tests {
+		if got := Parse(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+		ok   bool
+	}{
+		{"GoLang", "golang", true},
+		{"#golang", "golang", true},
+		{"Zürich", "zürich", true},
+		{"", "", false},
+		{"2024", "", false},
+		{"go lang", "", false},
+		{"go-lang", "", false},
+	}
+	for _, tt := range tests {
+		if got, ok := NormalizeTag(tt.tag); got != tt.want || ok != tt.ok {
+			t.Errorf("NormalizeTag(%q) = %q, %v, want %q, %v", tt.tag, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLink(t *testing.T) {
+	ents := Parse("@Alice and @ghost like #go, @alice too")
+	users := []types.UserSummary{{ID: 7, Username: "alice"}}
+
+	linked := Link(ents, users)
+	if len(linked) != 3 || linked[0].UserID != 7 || linked[1].Tag != "go" || linked[2].UserID != 7 {
+		t.Fatalf("got %+v, want both mentions of alice and the hashtag", linked)
+	}
+	if got := Usernames(ents); !reflect.DeepEqual(got, []string{"Alice", "ghost", "alice"}) {
+		t.Errorf("Usernames = %v", got)
+	}
+	if got := MentionedUserIDs(linked); !reflect.DeepEqual(got, []int{7}) {
+		t.Errorf("MentionedUserIDs = %v, want [7]", got)
+	}
+	if got := Tags(linked); !reflect.DeepEqual(got, []string{"go"}) {
+		t.Errorf("Tags = %v, want [go]", got)
+	}
+}
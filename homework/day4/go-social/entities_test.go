@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"gosocial/types"
+)
+
+func TestPostEntities(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		alice, aliceID := c.signup("alice")
+		bob, bobID := c.signup("bob")
+		c.createPost(alice.Token, "Grüße @bob from #Zürich, cc @nobody")
+
+		var detail types.PostDetail
+		c.expect(http.StatusOK, http.MethodGet, "/posts/1", bob.Token, nil, &detail)
+		want := []types.Entity{
+			{Type: types.EntityMention, Start: 6, End: 10, Text: "@bob", Username: "bob", UserID: bobID},
+			{Type: types.EntityHashtag, Start: 16, End: 23, Text: "#Zürich", Tag: "zürich"},
+		}
+		if fmt.Sprint(detail.Entities) != fmt.Sprint(want) {
+			t.Errorf("got entities %+v, want %+v", detail.Entities, want)
+		}
+
+		c.expect(http.StatusOK, http.MethodPost, "/posts/1/comment", bob.Token, map[string]string{"content": "thanks @alice #zürich"}, nil)
+		detail = types.PostDetail{}
+		c.expect(http.StatusOK, http.MethodGet, "/posts/1", alice.Token, nil, &detail)
+		if ents := detail.Comments[0].Entities; len(ents) != 2 || ents[0].UserID != aliceID || ents[1].Tag != "zürich" {
+			t.Errorf("got comment entities %+v, want a mention of alice and a hashtag", ents)
+		}
+
+		var feed feedResponse
+		c.expect(http.StatusOK, http.MethodGet, "/feed", alice.Token, nil, &feed)
+		if len(feed.Posts) != 1 || len(feed.Posts[0].Entities) != 2 {
+			t.Errorf("got feed %+v, want the post with its entities", feed.Posts)
+		}
+
+		// A user signing up under a mentioned name does not take the mention.
+		c.signup("nobody")
+		detail = types.PostDetail{}
+		c.expect(http.StatusOK, http.MethodGet, "/posts/1", alice.Token, nil, &detail)
+		if len(detail.Entities) != 2 {
+			t.Errorf("got entities %+v after signup of a mentioned name", detail.Entities)
+		}
+	})
+}
+
+func TestTagPosts(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		alice, _ := c.signup("alice")
+		c.createPost(alice.Token, "first #Go post")
+		c.createPost(alice.Token, "unrelated")
+		c.createPost(alice.Token, "second #go post #go")
+		c.createPost(alice.Token, "#golang is not #go's twin")
+
+		var resp feedResponse
+		c.expect(http.StatusOK, http.MethodGet, "/tags/GO/posts?limit=2", alice.Token, nil, &resp)
+		if len(resp.Posts) != 2 || resp.Posts[0].ID != 4 || resp.Posts[1].ID != 3 || resp.NextCursor == "" {
+			t.Fatalf("got %+v, want posts 4 and 3 and a cursor", resp)
+		}
+		next := resp.NextCursor
+		resp = feedResponse{}
+		c.expect(http.StatusOK, http.MethodGet, "/tags/"+url.PathEscape("#go")+"/posts?cursor="+next, alice.Token, nil, &resp)
+		if len(resp.Posts) != 1 || resp.Posts[0].ID != 1 || resp.NextCursor != "" {
+			t.Errorf("got %+v, want post 1 on the last page", resp)
+		}
+
+		// Editing a post replaces its tags.
+		c.expect(http.StatusOK, http.MethodPut, "/posts/1", alice.Token, map[string]string{"content": "now about #rust"}, nil)
+		resp = feedResponse{}
+		c.expect(http.StatusOK, http.MethodGet, "/tags/go/posts", alice.Token, nil, &resp)
+		if len(resp.Posts) != 2 {
+			t.Errorf("got %d posts tagged go after the edit, want 2", len(resp.Posts))
+		}
+		resp = feedResponse{}
+		c.expect(http.StatusOK, http.MethodGet, "/tags/rust/posts", alice.Token, nil, &resp)
+		if len(resp.Posts) != 1 || resp.Posts[0].Entities[0].Tag != "rust" {
+			t.Errorf("got %+v, want the edited post", resp.Posts)
+		}
+
+		c.expectError(http.StatusBadRequest, "invalid_tag", http.MethodGet, "/tags/2024/posts", alice.Token, nil)
+		c.expectError(http.StatusBadRequest, "invalid_tag", http.MethodGet, "/tags/a-b/posts", alice.Token, nil)
+	})
+}
+
+func TestUserMentions(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		alice, _ := c.signup("alice")
+		bob, bobID := c.signup("bob")
+		c.createPost(alice.Token, "hello @bob and @bob again")
+		c.createPost(alice.Token, "nothing here")
+		c.expect(http.StatusOK, http.MethodPost, "/posts/2/comment", alice.Token, map[string]string{"content": "@bob look"}, nil)
+
+		mentionsPath := fmt.Sprintf("/users/%d/mentions", bobID)
+		var resp mentionsResponse
+		c.expect(http.StatusOK, http.MethodGet, mentionsPath, bob.Token, nil, &resp)
+		if len(resp.Mentions) != 2 {
+			t.Fatalf("got %+v, want two mentions", resp.Mentions)
+		}
+		if m := resp.Mentions[0]; m.Post.ID != 2 || m.Comment == nil || m.Comment.Content != "@bob look" || m.Comment.Entities[0].UserID != bobID {
+			t.Errorf("got %+v, want the comment on post 2 first", m)
+		}
+		if m := resp.Mentions[1]; m.Post.ID != 1 || m.Comment != nil || len(m.Post.Entities) != 2 {
+			t.Errorf("got %+v, want post 1 mentioning bob twice", m)
+		}
+
+		resp = mentionsResponse{}
+		c.expect(http.StatusOK, http.MethodGet, mentionsPath+"?limit=1", bob.Token, nil, &resp)
+		if len(resp.Mentions) != 1 || resp.NextCursor == "" {
+			t.Errorf("got %+v, want one mention and a cursor", resp)
+		}
+
+		// Editing the mention away withdraws it; deleting the comment too.
+		c.expect(http.StatusOK, http.MethodPut, "/posts/1", alice.Token, map[string]string{"content": "hello everyone"}, nil)
+		c.expect(http.StatusOK, http.MethodDelete, "/posts/2/comments/1", alice.Token, nil, nil)
+		resp = mentionsResponse{}
+		c.expect(http.StatusOK, http.MethodGet, mentionsPath, bob.Token, nil, &resp)
+		if len(resp.Mentions) != 0 {
+			t.Errorf("got %+v, want no mentions left", resp.Mentions)
+		}
+
+		c.expectError(http.StatusNotFound, "user_not_found", http.MethodGet, "/users/999/mentions", bob.Token, nil)
+	})
+}
+
+func TestMentionIgnoresCase(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		alice, _ := c.signup("alice")
+		_, carolID := c.signup("Carol")
+		c.createPost(alice.Token, "hi @carol and @ALICE")
+
+		var detail types.PostDetail
+		c.expect(http.StatusOK, http.MethodGet, "/posts/1", alice.Token, nil, &detail)
+		if ents := detail.Entities; len(ents) != 2 || ents[0].UserID != carolID || ents[1].UserID != detail.UserID {
+			t.Fatalf("got entities %+v, want mentions of Carol and alice", ents)
+		}
+
+		var resp mentionsResponse
+		c.expect(http.StatusOK, http.MethodGet, fmt.Sprintf("/users/%d/mentions", carolID), alice.Token, nil, &resp)
+		if len(resp.Mentions) != 1 || resp.Mentions[0].Post.ID != 1 {
+			t.Errorf("got %+v, want the post mentioning Carol", resp.Mentions)
+		}
+	})
+}
@@ -0,0 +1,141 @@
+// Package errs defines the domain errors shared by the store and the HTTP
+// handlers. Each error carries a Kind, which decides the HTTP status, and a
+// stable machine-readable Code that clients can branch on.
+package errs
+
+import (
+	"errors"
+	"fmt"
+)
+
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindBadRequest
+	KindValidation
+	KindTooLarge
+	KindUnsupportedMediaType
+	KindUnauthorized
+	KindForbidden
+	KindNotFound
+	KindConflict
+	KindTooManyRequests
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindBadRequest:
+		return "bad_request"
+	case KindValidation:
+		return "validation"
+	case KindTooLarge:
+		return "too_large"
+	case KindUnsupportedMediaType:
+		return "unsupported_media_type"
+	case KindUnauthorized:
+		return "unauthorized"
+	case KindForbidden:
+		return "forbidden"
+	case KindNotFound:
+		return "not_found"
+	case KindConflict:
+		return "conflict"
+	case KindTooManyRequests:
+		return "too_many_requests"
+	default:
+		return "internal"
+	}
+}
+
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+	Fields  []FieldError
+	Err     error
+}
+
+// FieldError describes why a single request field failed validation.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func New(kind Kind, code, msg string) *Error {
+	return &Error{Kind: kind, Code: code, Message: msg}
+}
+
+func Wrap(kind Kind, code, msg string, err error) *Error {
+	return &Error{Kind: kind, Code: code, Message: msg, Err: err}
+}
+
+func BadRequest(code, msg string) *Error {
+	return New(KindBadRequest, code, msg)
+}
+
+func Validation(code, msg string) *Error {
+	return New(KindValidation, code, msg)
+}
+
+// InvalidFields reports every failing field of a request at once.
+func InvalidFields(fields []FieldError) *Error {
+	e := New(KindValidation, "validation_failed", "request validation failed")
+	e.Fields = fields
+	return e
+}
+
+func Unauthorized(code, msg string) *Error {
+	return New(KindUnauthorized, code, msg)
+}
+
+func Forbidden(code, msg string) *Error {
+	return New(KindForbidden, code, msg)
+}
+
+func NotFound(code, msg string) *Error {
+	return New(KindNotFound, code, msg)
+}
+
+func Conflict(code, msg string) *Error {
+	return New(KindConflict, code, msg)
+}
+
+func TooManyRequests(code, msg string) *Error {
+	return New(KindTooManyRequests, code, msg)
+}
+
+// Internal wraps an unexpected error. Its message is never shown to clients.
+func Internal(err error) *Error {
+	return Wrap(KindInternal, "internal", "internal server error", err)
+}
+
+// From returns the *Error in err's chain, or wraps err as Internal if there
+// is none.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return Internal(err)
+}
+
+func KindOf(err error) Kind {
+	return From(err).Kind
+}
+
+func Is(err error, kind Kind) bool {
+	return err != nil && KindOf(err) == kind
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"gosocial/configs"
+	"gosocial/logging"
+	"gosocial/types"
+)
+
+const (
+	healthStatusOK          = "ok"
+	healthStatusUnavailable = "unavailable"
+)
+
+// handleHealthz reports that the process is up. It checks no dependencies so
+// that a database outage does not get healthy instances restarted.
+func (s *apiServer) handleHealthz(w http.ResponseWriter, r *http.Request) error {
+	return WriteJSON(w, http.StatusOK, &types.HealthResponse{Status: healthStatusOK})
+}
+
+// handleReadyz reports whether the instance can serve traffic: the database
+// answers within the readiness timeout and its schema is in place.
+func (s *apiServer) handleReadyz(w http.ResponseWriter, r *http.Request) error {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*time.Duration(configs.Envs.ReadinessTimeoutInSeconds))
+	defer cancel()
+
+	resp := &types.HealthResponse{Status: healthStatusOK, Checks: map[string]types.HealthCheck{}}
+	for _, check := range []struct {
+		name string
+		run  func(context.Context) error
+	}{
+		{"database", s.store.Ping},
+		{"schema", s.store.CheckSchema},
+	} {
+		result := runHealthCheck(ctx, check.name, check.run)
+		if result.Status != healthStatusOK {
+			resp.Status = healthStatusUnavailable
+		}
+		resp.Checks[check.name] = result
+	}
+
+	status := http.StatusOK
+	if resp.Status != healthStatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	return WriteJSON(w, status, resp)
+}
+
+// runHealthCheck times a check. Failures are logged; the response only
+// says whether the check timed out, as the errors of database drivers name
+// hosts, ports and tables that an unauthenticated endpoint should not.
+func runHealthCheck(ctx context.Context, name string, check func(context.Context) error) types.HealthCheck {
+	start := time.Now()
+	err := check(ctx)
+	result := types.HealthCheck{
+		Status:    healthStatusOK,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = healthStatusUnavailable
+		result.Error = "failed"
+		if errors.Is(err, context.DeadlineExceeded) {
+			result.Error = "timed out"
+		}
+		logging.FromContext(ctx).Warn("health check failed", "check", name, "error", err)
+	}
+	return result
+}
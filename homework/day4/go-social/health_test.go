@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"gosocial/types"
+)
+
+func TestHealth(t *testing.T) {
+	storage := testStorages["sqlite"](t)
+	c := newTestClient(t, storage)
+
+	var health types.HealthResponse
+	c.expect(http.StatusOK, http.MethodGet, "/healthz", "", nil, &health)
+	if health.Status != healthStatusOK {
+		t.Errorf("got liveness status %q", health.Status)
+	}
+
+	var ready types.HealthResponse
+	c.expect(http.StatusOK, http.MethodGet, "/readyz", "", nil, &ready)
+	for _, name := range []string{"database", "schema"} {
+		if ready.Checks[name].Status != healthStatusOK {
+			t.Errorf("check %s: got %+v", name, ready.Checks[name])
+		}
+	}
+
+	// Losing the database fails readiness but not liveness.
+	if err := storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+	c.expect(http.StatusOK, http.MethodGet, "/healthz", "", nil, nil)
+
+	ready = types.HealthResponse{}
+	c.expect(http.StatusServiceUnavailable, http.MethodGet, "/readyz", "", nil, &ready)
+	if ready.Status != healthStatusUnavailable || ready.Checks["database"].Error != "failed" {
+		t.Errorf("unexpected readiness response: %+v", ready)
+	}
+}
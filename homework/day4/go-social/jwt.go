@@ -3,57 +3,81 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"gosocial/store"
 	"gosocial/configs"
+	"gosocial/errs"
+	"gosocial/logging"
+	"gosocial/store"
 )
 
 type contextKey string
 
 const UserKey contextKey = "userID"
+const SessionKey contextKey = "sessionID"
+
+// accessClaims are the claims of an access token. The user ID is carried in
+// the registered "sub" claim.
+type accessClaims struct {
+	SessionID string `json:"sid"`
+	jwt.RegisteredClaims
+}
 
-func WithJWTAuth(handlerFunc http.HandlerFunc, store store.UserStorage) http.HandlerFunc {
+func WithJWTAuth(handlerFunc http.HandlerFunc, store store.AuthStorage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context())
 		tokenString := GetTokenFromRequest(r)
 
-		token, err := validateJWT(tokenString)
+		claims, err := validateJWT(tokenString)
 		if err != nil {
-			log.Printf("failed to validate token: %v", err)
-			permissionDenied(w)
+			logger.Info("authentication failed", "reason", "invalid token", "error", err)
+			permissionDenied(w, r)
 			return
 		}
 
-		if !token.Valid {
-			log.Println("invalid token")
-			permissionDenied(w)
+		userID, err := strconv.Atoi(claims.Subject)
+		if err != nil {
+			logger.Info("authentication failed", "reason", "malformed subject", "error", err)
+			permissionDenied(w, r)
 			return
 		}
 
-		claims := token.Claims.(jwt.MapClaims)
-		str := claims["userID"].(string)
-
-		userID, err := strconv.Atoi(str)
+		// Only a missing session or user invalidates the token; any other
+		// error is the storage failing and must not log the client out.
+		session, err := store.GetSessionByID(r.Context(), claims.SessionID)
+		if errs.Is(err, errs.KindNotFound) {
+			logger.Info("authentication failed", "reason", "unknown session", "error", err)
+			permissionDenied(w, r)
+			return
+		}
 		if err != nil {
-			log.Printf("failed to convert userID to int: %v", err)
-			permissionDenied(w)
+			writeError(w, r, err)
+			return
+		}
+		if session.RevokedAt != nil || session.UserID != userID {
+			logger.Info("authentication failed", "reason", "session revoked", "session_id", session.ID)
+			permissionDenied(w, r)
 			return
 		}
 
-		u, err := store.GetUserByID(userID)
+		u, err := store.GetUserByID(r.Context(), userID)
+		if errs.Is(err, errs.KindNotFound) {
+			logger.Info("authentication failed", "reason", "unknown user", "error", err)
+			permissionDenied(w, r)
+			return
+		}
 		if err != nil {
-			log.Printf("failed to get user by id: %v", err)
-			permissionDenied(w)
+			writeError(w, r, err)
 			return
 		}
 
-		// Add the user to the context
-		ctx := r.Context()
+		// Add the user and session to the context
+		ctx := setRequestUser(r.Context(), u.ID)
 		ctx = context.WithValue(ctx, UserKey, u.ID)
+		ctx = context.WithValue(ctx, SessionKey, session.ID)
 		r = r.WithContext(ctx)
 
 		// Call the function if the token is valid
@@ -61,15 +85,26 @@ func WithJWTAuth(handlerFunc http.HandlerFunc, store store.UserStorage) http.Han
 	}
 }
 
-func CreateJWT(userID int) (string, error) {
+func CreateJWT(userID int, sessionID string) (string, error) {
 	expiration := time.Second * time.Duration(configs.Envs.JWTExpirationInSeconds)
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userID":    strconv.Itoa(int(userID)),
-		"expiresAt": time.Now().Add(expiration).Unix(),
+	now := time.Now()
+
+	key := jwtKeys.signing
+	token := jwt.NewWithClaims(key.Method, accessClaims{
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    configs.Envs.JWTIssuer,
+			Subject:   strconv.Itoa(userID),
+			Audience:  jwt.ClaimStrings{configs.Envs.JWTAudience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiration)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
 	})
 
-	tokenString, err := token.SignedString([]byte(configs.Envs.JWTSecret))
+	token.Header["kid"] = key.ID
+
+	tokenString, err := token.SignedString(key.Private)
 	if err != nil {
 		return "", err
 	}
@@ -77,18 +112,32 @@ func CreateJWT(userID int) (string, error) {
 	return tokenString, err
 }
 
-func validateJWT(tokenString string) (*jwt.Token, error) {
-	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
+// validateJWT verifies the signature of an access token with the key named by
+// its kid, and its registered claims. exp is required, iat and nbf must not
+// lie in the future, and iss and aud must match the configuration, all within
+// the configured leeway.
+func validateJWT(tokenString string) (*accessClaims, error) {
+	claims := new(accessClaims)
+	_, err := jwt.ParseWithClaims(tokenString, claims, jwtKeys.keyFunc,
+		jwt.WithValidMethods(jwtKeys.methods()),
+		jwt.WithIssuer(configs.Envs.JWTIssuer),
+		jwt.WithAudience(configs.Envs.JWTAudience),
+		jwt.WithLeeway(time.Second*time.Duration(configs.Envs.JWTLeewayInSeconds)),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, err
+	}
 
-		return []byte(configs.Envs.JWTSecret), nil
-	})
+	if claims.Subject == "" || claims.SessionID == "" {
+		return nil, fmt.Errorf("token is missing subject or session")
+	}
+	return claims, nil
 }
 
-func permissionDenied(w http.ResponseWriter) {
-	WriteJSON(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+func permissionDenied(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, errs.Unauthorized("invalid_token", "permission denied"))
 }
 
 func GetUserIDFromContext(ctx context.Context) int {
@@ -98,4 +147,9 @@ func GetUserIDFromContext(ctx context.Context) int {
 	}
 
 	return userID
-}
\ No newline at end of file
+}
+
+func GetSessionIDFromContext(ctx context.Context) string {
+	sessionID, _ := ctx.Value(SessionKey).(string)
+	return sessionID
+}
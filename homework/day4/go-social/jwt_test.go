@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gosocial/configs"
+	"gosocial/errs"
+	"gosocial/store"
+	"gosocial/types"
+)
+
+func signTestToken(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return signed
+}
+
+func testClaims(now time.Time) accessClaims {
+	return accessClaims{
+		SessionID: "session",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    configs.Envs.JWTIssuer,
+			Subject:   "1",
+			Audience:  jwt.ClaimStrings{configs.Envs.JWTAudience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+}
+
+func TestCreateJWTRoundTrip(t *testing.T) {
+	token, err := CreateJWT(42, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := validateJWT(token)
+	if err != nil {
+		t.Fatalf("validateJWT: %v", err)
+	}
+	if claims.Subject != "42" || claims.SessionID != "abc" {
+		t.Errorf("got sub=%q sid=%q, want sub=42 sid=abc", claims.Subject, claims.SessionID)
+	}
+}
+
+func TestValidateJWTRejects(t *testing.T) {
+	secret := []byte(configs.Envs.JWTSecret)
+	leeway := time.Second * time.Duration(configs.Envs.JWTLeewayInSeconds)
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		token func() string
+	}{
+		{"expired", func() string {
+			c := testClaims(now)
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(-leeway - time.Minute))
+			return signTestToken(t, jwt.SigningMethodHS256, hmacKeyID, secret, c)
+		}},
+		{"missing exp", func() string {
+			c := testClaims(now)
+			c.ExpiresAt = nil
+			return signTestToken(t, jwt.SigningMethodHS256, hmacKeyID, secret, c)
+		}},
+		{"not yet valid", func() string {
+			c := testClaims(now)
+			c.NotBefore = jwt.NewNumericDate(now.Add(leeway + time.Minute))
+			return signTestToken(t, jwt.SigningMethodHS256, hmacKeyID, secret, c)
+		}},
+		{"issued in the future", func() string {
+			c := testClaims(now)
+			c.IssuedAt = jwt.NewNumericDate(now.Add(leeway + time.Minute))
+			return signTestToken(t, jwt.SigningMethodHS256, hmacKeyID, secret, c)
+		}},
+		{"wrong audience", func() string {
+			c := testClaims(now)
+			c.Audience = jwt.ClaimStrings{"someone-else"}
+			return signTestToken(t, jwt.SigningMethodHS256, hmacKeyID, secret, c)
+		}},
+		{"wrong issuer", func() string {
+			c := testClaims(now)
+			c.Issuer = "someone-else"
+			return signTestToken(t, jwt.SigningMethodHS256, hmacKeyID, secret, c)
+		}},
+		{"missing subject", func() string {
+			c := testClaims(now)
+			c.Subject = ""
+			return signTestToken(t, jwt.SigningMethodHS256, hmacKeyID, secret, c)
+		}},
+		{"wrong secret", func() string {
+			return signTestToken(t, jwt.SigningMethodHS256, hmacKeyID, []byte("not-the-secret"), testClaims(now))
+		}},
+		{"alg none", func() string {
+			return signTestToken(t, jwt.SigningMethodNone, hmacKeyID, jwt.UnsafeAllowNoneSignatureType, testClaims(now))
+		}},
+		{"unknown kid", func() string {
+			return signTestToken(t, jwt.SigningMethodHS256, "unknown", secret, testClaims(now))
+		}},
+		{"algorithm does not match kid", func() string {
+			_, priv, _ := ed25519.GenerateKey(rand.Reader)
+			return signTestToken(t, jwt.SigningMethodEdDSA, hmacKeyID, priv, testClaims(now))
+		}},
+		{"malformed", func() string { return "not.a.token" }},
+		{"empty", func() string { return "" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := validateJWT(tt.token()); err == nil {
+				t.Error("expected token to be rejected")
+			}
+		})
+	}
+}
+
+func TestValidateJWTAllowsClockSkew(t *testing.T) {
+	leeway := time.Second * time.Duration(configs.Envs.JWTLeewayInSeconds)
+	now := time.Now()
+
+	c := testClaims(now)
+	c.IssuedAt = jwt.NewNumericDate(now.Add(leeway / 2))
+	c.NotBefore = jwt.NewNumericDate(now.Add(leeway / 2))
+	token := signTestToken(t, jwt.SigningMethodHS256, hmacKeyID, []byte(configs.Envs.JWTSecret), c)
+
+	if _, err := validateJWT(token); err != nil {
+		t.Errorf("expected token within leeway to be accepted: %v", err)
+	}
+}
+
+func TestWithJWTAuthRejectsMalformedSubject(t *testing.T) {
+	c := testClaims(time.Now())
+	c.Subject = "not-a-number"
+	token := signTestToken(t, jwt.SigningMethodHS256, hmacKeyID, []byte(configs.Envs.JWTSecret), c)
+
+	called := false
+	handler := WithJWTAuth(func(w http.ResponseWriter, r *http.Request) { called = true }, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+	req.Header.Set("Authorization", token)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if called {
+		t.Error("handler should not be called")
+	}
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestWithJWTAuthRejectsBadTokens(t *testing.T) {
+	for _, token := range []string{"", "garbage", "a.b.c"} {
+		handler := WithJWTAuth(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("handler should not be called")
+		}, nil)
+
+		req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("token %q: got status %d, want %d", token, rec.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func writeTestKey(t *testing.T, dir, name string, key any) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestKeyRotation(t *testing.T) {
+	defer func(ks *keySet) { jwtKeys = ks }(jwtKeys)
+
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPath := writeTestKey(t, dir, "old.pem", rsaKey)
+	newPath := writeTestKey(t, dir, "new.pem", edKey)
+
+	// Issue a token with the old RSA key.
+	if err := loadJWTKeys(configs.Config{JWTSigningKeyFile: oldPath}); err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := CreateJWT(1, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate to Ed25519 while still accepting the RSA key.
+	cfg := configs.Config{JWTSigningKeyFile: newPath, JWTVerificationKeyFiles: oldPath}
+	if err := loadJWTKeys(cfg); err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := CreateJWT(1, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, token := range map[string]string{"old": oldToken, "new": newToken} {
+		if _, err := validateJWT(token); err != nil {
+			t.Errorf("%s token rejected: %v", name, err)
+		}
+	}
+
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	rec := httptest.NewRecorder()
+	handleJWKS(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	if err := json.NewDecoder(rec.Body).Decode(&jwks); err != nil {
+		t.Fatal(err)
+	}
+	algs := map[string]bool{}
+	for _, k := range jwks.Keys {
+		algs[k.Alg] = true
+	}
+	if len(jwks.Keys) != 2 || !algs["RS256"] || !algs["EdDSA"] {
+		t.Errorf("unexpected JWKS: %+v", jwks.Keys)
+	}
+
+	// Once the old key is dropped its tokens are rejected.
+	if err := loadJWTKeys(configs.Config{JWTSigningKeyFile: newPath}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := validateJWT(oldToken); err == nil {
+		t.Error("token signed with retired key was accepted")
+	}
+}
+
+// stubAuthStorage finds session "session" of user 1 and that user, unless
+// given errors to return instead.
+type stubAuthStorage struct {
+	store.AuthStorage
+	sessionErr, userErr error
+}
+
+func (s stubAuthStorage) GetSessionByID(ctx context.Context, id string) (*types.Session, error) {
+	if s.sessionErr != nil {
+		return nil, s.sessionErr
+	}
+	return &types.Session{ID: id, UserID: 1}, nil
+}
+
+func (s stubAuthStorage) GetUserByID(ctx context.Context, id int) (*types.User, error) {
+	if s.userErr != nil {
+		return nil, s.userErr
+	}
+	return &types.User{ID: id}, nil
+}
+
+func TestWithJWTAuthStorageErrors(t *testing.T) {
+	token := signTestToken(t, jwt.SigningMethodHS256, hmacKeyID, []byte(configs.Envs.JWTSecret), testClaims(time.Now()))
+	down := errors.New("connection refused")
+
+	for name, tc := range map[string]struct {
+		storage stubAuthStorage
+		want    int
+		code    string
+	}{
+		"valid":             {stubAuthStorage{}, http.StatusOK, ""},
+		"unknown session":   {stubAuthStorage{sessionErr: errs.NotFound("session_not_found", "session not found")}, http.StatusUnauthorized, "invalid_token"},
+		"unknown user":      {stubAuthStorage{userErr: errs.NotFound("user_not_found", "user not found")}, http.StatusUnauthorized, "invalid_token"},
+		"session lookup":    {stubAuthStorage{sessionErr: down}, http.StatusInternalServerError, "internal"},
+		"user lookup":       {stubAuthStorage{userErr: down}, http.StatusInternalServerError, "internal"},
+		"session timed out": {stubAuthStorage{sessionErr: context.DeadlineExceeded}, http.StatusInternalServerError, "internal"},
+	} {
+		handler := WithJWTAuth(func(w http.ResponseWriter, r *http.Request) {}, tc.storage)
+
+		req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+
+		var apiErr apiError
+		json.NewDecoder(rec.Body).Decode(&apiErr)
+		if rec.Code != tc.want || apiErr.Code != tc.code {
+			t.Errorf("%s: got %d %q, want %d %q", name, rec.Code, apiErr.Code, tc.want, tc.code)
+		}
+	}
+}
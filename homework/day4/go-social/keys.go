@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gosocial/configs"
+)
+
+// hmacKeyID is the kid of the shared-secret key used when no asymmetric
+// signing key is configured. HMAC keys are never published in the JWKS.
+const hmacKeyID = "hmac"
+
+type jwtKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	// Private signs tokens; it is nil for verification-only keys.
+	Private any
+	// Public verifies tokens. For HMAC it is the shared secret.
+	Public any
+}
+
+// keySet holds the key new tokens are signed with and every key, current or
+// rotated out, that tokens are still accepted from, indexed by kid.
+type keySet struct {
+	signing *jwtKey
+	verify  map[string]*jwtKey
+}
+
+var jwtKeys = newHMACKeySet(configs.Envs.JWTSecret)
+
+func newHMACKeySet(secret string) *keySet {
+	key := &jwtKey{
+		ID:      hmacKeyID,
+		Method:  jwt.SigningMethodHS256,
+		Private: []byte(secret),
+		Public:  []byte(secret),
+	}
+	return &keySet{signing: key, verify: map[string]*jwtKey{key.ID: key}}
+}
+
+// loadJWTKeys replaces the default HMAC key set when an asymmetric signing
+// key is configured. JWT_SIGNING_KEY_FILE holds the current RSA or Ed25519
+// private key; JWT_VERIFICATION_KEY_FILES lists further PEM files, private or
+// public, whose tokens are still accepted while a rotation completes.
+func loadJWTKeys(cfg configs.Config) error {
+	if cfg.JWTSigningKeyFile == "" {
+		return nil
+	}
+
+	signing, err := loadPEMKey(cfg.JWTSigningKeyFile)
+	if err != nil {
+		return err
+	}
+	if signing.Private == nil {
+		return fmt.Errorf("%s: signing key must be a private key", cfg.JWTSigningKeyFile)
+	}
+
+	ks := &keySet{signing: signing, verify: map[string]*jwtKey{signing.ID: signing}}
+	for _, path := range strings.Split(cfg.JWTVerificationKeyFiles, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		key, err := loadPEMKey(path)
+		if err != nil {
+			return err
+		}
+		ks.verify[key.ID] = key
+	}
+
+	jwtKeys = ks
+	return nil
+}
+
+func loadPEMKey(path string) (*jwtKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM block found", path)
+	}
+
+	key, err := parseKey(block)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+func parseKey(block *pem.Block) (*jwtKey, error) {
+	var parsed any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := new(jwtKey)
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	key.ID = thumbprint(key.Public.(crypto.PublicKey))
+	return key, nil
+}
+
+// jwk is the JSON Web Key form of a public key (RFC 7517).
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+func toJWK(key *jwtKey) (*jwk, bool) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch pub := key.Public.(type) {
+	case *rsa.PublicKey:
+		return &jwk{
+			Kty: "RSA", Kid: key.ID, Use: "sig", Alg: key.Method.Alg(),
+			N: b64(pub.N.Bytes()), E: b64(big.NewInt(int64(pub.E)).Bytes()),
+		}, true
+	case ed25519.PublicKey:
+		return &jwk{
+			Kty: "OKP", Kid: key.ID, Use: "sig", Alg: key.Method.Alg(),
+			Crv: "Ed25519", X: b64(pub),
+		}, true
+	default:
+		return nil, false
+	}
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint of a public key, which
+// serves as its kid.
+func thumbprint(pub crypto.PublicKey) string {
+	var canonical string
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		canonical = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, b64(big.NewInt(int64(k.E)).Bytes()), b64(k.N.Bytes()))
+	case ed25519.PublicKey:
+		canonical = fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":"%s"}`, b64(k))
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return b64(sum[:])
+}
+
+func (ks *keySet) jwks() map[string][]*jwk {
+	keys := []*jwk{}
+	for _, key := range ks.verify {
+		if k, ok := toJWK(key); ok {
+			keys = append(keys, k)
+		}
+	}
+	return map[string][]*jwk{"keys": keys}
+}
+
+func (ks *keySet) methods() []string {
+	seen := map[string]bool{}
+	methods := []string{}
+	for _, key := range ks.verify {
+		if alg := key.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			methods = append(methods, alg)
+		}
+	}
+	return methods
+}
+
+// keyFunc picks the verification key named by the token's kid header and
+// checks that the token was signed with that key's algorithm.
+func (ks *keySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, fmt.Errorf("token has no kid")
+	}
+	key, ok := ks.verify[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.Public, nil
+}
+
+func handleJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(jwtKeys.jwks())
+}
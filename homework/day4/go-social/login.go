@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gosocial/configs"
+	"gosocial/errs"
+	"gosocial/logging"
+)
+
+var errLoginLocked = errs.TooManyRequests("login_locked", "too many failed logins, try again later")
+
+// Failed logins are counted under the username, whether or not such a user
+// exists, and under the client IP.
+func loginUserKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+func loginIPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// checkLoginLock returns errLoginLocked, after setting Retry-After, if
+// either key is locked out.
+func (s *apiServer) checkLoginLock(ctx context.Context, w http.ResponseWriter, keys ...string) error {
+	now := time.Now()
+	for _, key := range keys {
+		a, err := s.store.GetLoginAttempt(ctx, key)
+		if err != nil {
+			return err
+		}
+		if a.LockedUntil != nil && a.LockedUntil.After(now) {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(a.LockedUntil.Sub(now))))
+			return errLoginLocked
+		}
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed login for the username and the client
+// IP, locks out whichever reached its threshold, and then holds the response
+// back for a delay that doubles with each consecutive failure of the
+// username.
+func (s *apiServer) recordLoginFailure(ctx context.Context, userKey, ipKey string) error {
+	cfg := configs.Envs
+	now := time.Now()
+	window := time.Second * time.Duration(cfg.LoginFailureWindowInSeconds)
+
+	var userFailures int
+	for _, k := range []struct {
+		key       string
+		threshold int64
+	}{
+		{userKey, cfg.LoginMaxFailuresPerUser},
+		{ipKey, cfg.LoginMaxFailuresPerIP},
+	} {
+		a, err := s.store.RecordLoginFailure(ctx, k.key, now, window)
+		if err != nil {
+			return err
+		}
+		if k.key == userKey {
+			userFailures = a.Failures
+		}
+		if k.threshold > 0 && int64(a.Failures) >= k.threshold {
+			until := now.Add(time.Second * time.Duration(cfg.LoginLockoutInSeconds))
+			if err := s.store.LockLogin(ctx, k.key, until); err != nil {
+				return err
+			}
+			logging.FromContext(ctx).Warn("login locked out", "key", k.key, "failures", a.Failures, "until", until)
+		}
+	}
+
+	select {
+	case <-time.After(loginDelay(userFailures)):
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+func loginDelay(failures int) time.Duration {
+	base := time.Millisecond * time.Duration(configs.Envs.LoginDelayBaseInMillis)
+	max := time.Millisecond * time.Duration(configs.Envs.LoginDelayMaxInMillis)
+	if failures < 1 || base <= 0 {
+		return 0
+	}
+	delay := base
+	for i := 1; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	return min(delay, max)
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// compareDummyPassword spends as long as checking a real password, so that
+// unknown usernames cannot be told apart by response time.
+func compareDummyPassword(plain string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = hashPassword("not-a-real-password")
+	})
+	comparePasswords(dummyHash, plain)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"gosocial/configs"
+	"gosocial/store"
+)
+
+func withoutLoginDelay(t *testing.T) {
+	t.Helper()
+	base := configs.Envs.LoginDelayBaseInMillis
+	configs.Envs.LoginDelayBaseInMillis = 0
+	t.Cleanup(func() { configs.Envs.LoginDelayBaseInMillis = base })
+}
+
+func TestLoginLockout(t *testing.T) {
+	withoutLoginDelay(t)
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		c.signup("alice")
+		wrong := map[string]string{"username": "alice", "password": "wrong-password"}
+		right := map[string]string{"username": "alice", "password": "password123"}
+
+		// Unknown users and wrong passwords are indistinguishable.
+		unknown := c.expectError(http.StatusUnauthorized, "invalid_credentials", http.MethodPost, "/login", "",
+			map[string]string{"username": "nobody", "password": "password123"})
+		for i := int64(0); i < configs.Envs.LoginMaxFailuresPerUser; i++ {
+			got := c.expectError(http.StatusUnauthorized, "invalid_credentials", http.MethodPost, "/login", "", wrong)
+			if got.Error != unknown.Error {
+				t.Fatalf("wrong password message %q differs from unknown user message %q", got.Error, unknown.Error)
+			}
+		}
+
+		// Once locked out even the right password is refused.
+		c.expectError(http.StatusTooManyRequests, "login_locked", http.MethodPost, "/login", "", right)
+	})
+}
+
+func TestLoginSuccessResetsFailures(t *testing.T) {
+	withoutLoginDelay(t)
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		c.signup("alice")
+		wrong := map[string]string{"username": "alice", "password": "wrong-password"}
+		right := map[string]string{"username": "alice", "password": "password123"}
+
+		for round := 0; round < 2; round++ {
+			for i := int64(1); i < configs.Envs.LoginMaxFailuresPerUser; i++ {
+				c.expect(http.StatusUnauthorized, http.MethodPost, "/login", "", wrong, nil)
+			}
+			c.expect(http.StatusOK, http.MethodPost, "/login", "", right, nil)
+		}
+	})
+}
+
+func TestLoginSuccessResetsIPFailures(t *testing.T) {
+	withoutLoginDelay(t)
+	for name, newStorage := range testStorages {
+		t.Run(name, func(t *testing.T) {
+			storage := newStorage(t)
+			c := newTestClient(t, storage)
+			c.signup("alice")
+			c.signup("bob")
+			ipFailures := func() int {
+				a, err := storage.GetLoginAttempt(context.Background(), loginIPKey("127.0.0.1"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return a.Failures
+			}
+
+			c.expect(http.StatusUnauthorized, http.MethodPost, "/login", "", map[string]string{"username": "alice", "password": "wrong-password"}, nil)
+			if got := ipFailures(); got != 1 {
+				t.Fatalf("got %d failures for the IP, want 1", got)
+			}
+			c.expect(http.StatusOK, http.MethodPost, "/login", "", map[string]string{"username": "bob", "password": "password123"}, nil)
+			if got := ipFailures(); got != 0 {
+				t.Errorf("got %d failures for the IP after a successful login, want 0", got)
+			}
+		})
+	}
+}
+
+func TestLockoutSurvivesRestartAndUnlock(t *testing.T) {
+	withoutLoginDelay(t)
+	path := filepath.Join(t.TempDir(), "test.db")
+	open := func() store.Storage {
+		s, err := store.NewSQLiteStorage(path, store.PoolConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	}
+
+	c := newTestClient(t, open())
+	c.signup("alice")
+	wrong := map[string]string{"username": "alice", "password": "wrong-password"}
+	right := map[string]string{"username": "alice", "password": "password123"}
+	for i := int64(0); i < configs.Envs.LoginMaxFailuresPerUser; i++ {
+		c.expect(http.StatusUnauthorized, http.MethodPost, "/login", "", wrong, nil)
+	}
+
+	storage := open()
+	c = newTestClient(t, storage)
+	c.expectError(http.StatusTooManyRequests, "login_locked", http.MethodPost, "/login", "", right)
+
+	if err := storage.ClearLoginAttempt(context.Background(), loginUserKey("ALICE")); err != nil {
+		t.Fatal(err)
+	}
+	c.expect(http.StatusOK, http.MethodPost, "/login", "", right, nil)
+}
+
+func TestLoginDelay(t *testing.T) {
+	base, max := configs.Envs.LoginDelayBaseInMillis, configs.Envs.LoginDelayMaxInMillis
+	for failures, want := range map[int]int64{0: 0, 1: base, 2: base * 2, 3: base * 4, 100: max} {
+		if got := loginDelay(failures).Milliseconds(); got != want {
+			t.Errorf("%d failures: got %dms, want %dms", failures, got, want)
+		}
+	}
+}
@@ -1,37 +1,99 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/go-sql-driver/mysql"
-	"gosocial/store"
 	"gosocial/configs"
+	"gosocial/logging"
+	"gosocial/store"
 )
 
 func main() {
-	cfg := mysql.Config{
-		User:                 configs.Envs.DBUser,
-		Passwd:               configs.Envs.DBPassword,
-		Addr:                 configs.Envs.DBAddress,
-		DBName:               configs.Envs.DBName,
-		Net:                  "tcp",
-		AllowNativePasswords: true,
-		ParseTime:            true,
+	if len(os.Args) > 1 {
+		subcommands := map[string]func([]string) error{
+			"migrate": runMigrate,
+			"unlock":  runUnlock,
+		}
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
+	logger, err := logging.New(os.Stderr, configs.Envs.LogLevel, configs.Envs.LogFormat)
+	if err != nil {
+		log.Fatal(err)
 	}
-	store, err := store.NewMySQLStorage(cfg)
+	slog.SetDefault(logger)
 
+	if err := loadJWTKeys(configs.Envs); err != nil {
+		log.Fatal(err)
+	}
+
+	store, err := newStorage(configs.Envs)
 	if err != nil {
 		log.Fatal(err)
 	}
-	if err = store.Ping(); err != nil {
+	if err = store.Ping(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	if err = store.Init(context.Background()); err != nil {
 		log.Fatal(err)
 	}
-	if err = store.Init(); err != nil {
+	blobs, err := newBlobStore(configs.Envs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server, err := NewAPIServer(fmt.Sprintf(":%s", configs.Envs.Port), store, blobs)
+	if err != nil {
 		log.Fatal(err)
 	}
-	server := NewAPIServer(fmt.Sprintf(":%s", configs.Envs.Port), store)
-	if err := server.Run(); err != nil {
+	if err := server.Run(ctx); err != nil {
 		log.Fatal(err)
 	}
-}
\ No newline at end of file
+	log.Println("server stopped cleanly")
+}
+
+// newStorage opens the storage backend selected by DB_DRIVER.
+func newStorage(c configs.Config) (store.Storage, error) {
+	pool := store.PoolConfig{
+		MaxOpenConns:    int(c.DBMaxOpenConns),
+		MaxIdleConns:    int(c.DBMaxIdleConns),
+		ConnMaxLifetime: time.Second * time.Duration(c.DBConnMaxLifetimeInSeconds),
+		ConnMaxIdleTime: time.Second * time.Duration(c.DBConnMaxIdleTimeInSeconds),
+		QueryTimeout:    time.Second * time.Duration(c.DBQueryTimeoutInSeconds),
+	}
+
+	switch c.DBDriver {
+	case "mysql":
+		cfg := mysql.Config{
+			User:                 c.DBUser,
+			Passwd:               c.DBPassword,
+			Addr:                 c.DBAddress,
+			DBName:               c.DBName,
+			Net:                  "tcp",
+			AllowNativePasswords: true,
+			ParseTime:            true,
+		}
+		return store.NewMySQLStorage(cfg, pool)
+	case "sqlite":
+		return store.NewSQLiteStorage(c.DBPath, pool)
+	case "memory":
+		return store.NewMemoryStorage(), nil
+	default:
+		return nil, fmt.Errorf("unknown DB_DRIVER %q", c.DBDriver)
+	}
+}
@@ -0,0 +1,222 @@
+// Package media prepares uploaded images for storage: it checks their type
+// from their content, removes their metadata and makes thumbnails.
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+var (
+	// ErrUnsupportedType is returned for content that is not a JPEG, PNG or
+	// GIF image.
+	ErrUnsupportedType = errors.New("unsupported media type")
+	// ErrTooManyPixels is returned for images larger than allowed, checked
+	// before they are decoded.
+	ErrTooManyPixels = errors.New("image has too many pixels")
+	// ErrInvalidImage is returned for content that claims to be an image of
+	// a supported type but does not decode.
+	ErrInvalidImage = errors.New("invalid image")
+)
+
+// Content types of the supported images.
+const (
+	JPEG = "image/jpeg"
+	PNG  = "image/png"
+	GIF  = "image/gif"
+)
+
+// Options limit and shape the processing of an image.
+type Options struct {
+	// MaxPixels bounds width times height, so that small files cannot
+	// decode into huge images.
+	MaxPixels int
+	// ThumbnailSize bounds the width and height of thumbnails.
+	ThumbnailSize int
+}
+
+// Image is an uploaded image ready to be stored.
+type Image struct {
+	ContentType string
+	// Data is the image without its metadata.
+	Data          []byte
+	Width, Height int
+
+	Thumbnail            []byte
+	ThumbnailContentType string
+}
+
+// Process checks that data is a supported image and prepares it. The type
+// is sniffed from the content; whatever the client declared is ignored.
+//
+// Images lose the metadata segments, chunks and extensions that can carry
+// EXIF, XMP or text, such as the location a photo was taken at. A JPEG
+// whose EXIF orientation rotates or flips it is re-encoded upright instead,
+// since the orientation is lost with the EXIF data.
+func Process(data []byte, opts Options) (*Image, error) {
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case JPEG, PNG, GIF:
+	default:
+		return nil, ErrUnsupportedType
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, ErrInvalidImage
+	}
+	if cfg.Width*cfg.Height > opts.MaxPixels {
+		return nil, ErrTooManyPixels
+	}
+
+	decoded, err := decode(contentType, data)
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	img := toRGBA(decoded)
+
+	out := &Image{ContentType: contentType}
+	switch contentType {
+	case JPEG:
+		if o := jpegOrientation(data); o > 1 && o <= 8 {
+			img = orient(img, o)
+			if out.Data, err = encode(JPEG, img); err != nil {
+				return nil, err
+			}
+		} else if out.Data, err = stripJPEG(data); err != nil {
+			return nil, ErrInvalidImage
+		}
+	case PNG:
+		if out.Data, err = stripPNG(data); err != nil {
+			return nil, ErrInvalidImage
+		}
+	case GIF:
+		if out.Data, err = stripGIF(data); err != nil {
+			return nil, ErrInvalidImage
+		}
+	}
+	out.Width, out.Height = img.Bounds().Dx(), img.Bounds().Dy()
+
+	// Thumbnails of PNG and GIF images keep their transparency.
+	out.ThumbnailContentType = PNG
+	if contentType == JPEG {
+		out.ThumbnailContentType = JPEG
+	}
+	out.Thumbnail, err = encode(out.ThumbnailContentType, thumbnail(img, opts.ThumbnailSize))
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func decode(contentType string, data []byte) (image.Image, error) {
+	r := bytes.NewReader(data)
+	switch contentType {
+	case JPEG:
+		return jpeg.Decode(r)
+	case PNG:
+		return png.Decode(r)
+	default:
+		// The first frame of an animation.
+		return gif.Decode(r)
+	}
+}
+
+func encode(contentType string, img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if contentType == JPEG {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	return buf.Bytes(), err
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	return rgba
+}
+
+// orient turns img upright according to an EXIF orientation from 2 to 8.
+func orient(img *image.RGBA, orientation int) *image.RGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	out := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		for dx := 0; dx < dw; dx++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-dx, dy
+			case 3: // rotated 180°
+				sx, sy = w-1-dx, h-1-dy
+			case 4: // mirrored vertically
+				sx, sy = dx, h-1-dy
+			case 5: // transposed
+				sx, sy = dy, dx
+			case 6: // to be rotated 90° clockwise
+				sx, sy = dy, h-1-dx
+			case 7: // transversed
+				sx, sy = w-1-dy, h-1-dx
+			case 8: // to be rotated 90° counterclockwise
+				sx, sy = w-1-dy, dx
+			}
+			copy(out.Pix[out.PixOffset(dx, dy):][:4], img.Pix[img.PixOffset(sx, sy):][:4])
+		}
+	}
+	return out
+}
+
+// thumbnail scales img down to fit in a square of size, averaging the
+// pixels each thumbnail pixel covers. Smaller images are kept as they are.
+func thumbnail(img *image.RGBA, size int) *image.RGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	tw, th := size, size
+	if w > h {
+		th = max(1, h*size/w)
+	} else {
+		tw = max(1, w*size/h)
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for ty := 0; ty < th; ty++ {
+		y0, y1 := ty*h/th, (ty+1)*h/th
+		for tx := 0; tx < tw; tx++ {
+			x0, x1 := tx*w/tw, (tx+1)*w/tw
+			var sum [4]int
+			for y := y0; y < y1; y++ {
+				row := img.Pix[img.PixOffset(x0, y):img.PixOffset(x1, y)]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			n := (x1 - x0) * (y1 - y0)
+			px := out.Pix[out.PixOffset(tx, ty):][:4]
+			for i := range px {
+				px[i] = uint8(sum[i] / n)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,240 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+var opts = Options{MaxPixels: 1 << 20, ThumbnailSize: 32}
+
+// halves returns a w×h image, red on the left and blue on the right.
+func halves(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// exifSegment returns an APP1 segment holding a little-endian TIFF
+// structure with the given orientation.
+func exifSegment(orientation uint16) []byte {
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	tiff = binary.LittleEndian.AppendUint16(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 0x0112)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 3)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	seg := []byte{0xFF, markerAPP1}
+	seg = binary.BigEndian.AppendUint16(seg, uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// withSegments inserts segments after the SOI marker of a JPEG image.
+func withSegments(data []byte, segments ...[]byte) []byte {
+	out := append([]byte(nil), data[:2]...)
+	for _, s := range segments {
+		out = append(out, s...)
+	}
+	return append(out, data[2:]...)
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessJPEG(t *testing.T) {
+	plain := encodeJPEG(t, halves(64, 32))
+	comment := append([]byte{0xFF, markerCOM, 0, 7}, "hello"...)
+	data := withSegments(plain, exifSegment(1), comment)
+
+	img, err := Process(data, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.ContentType != JPEG || img.Width != 64 || img.Height != 32 {
+		t.Errorf("got %s %dx%d, want a 64x32 JPEG", img.ContentType, img.Width, img.Height)
+	}
+	// Without a rotation, only the metadata is cut out.
+	if !bytes.Equal(img.Data, plain) {
+		t.Error("the metadata segments were not stripped exactly")
+	}
+
+	thumb, err := jpeg.Decode(bytes.NewReader(img.Thumbnail))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := thumb.Bounds(); img.ThumbnailContentType != JPEG || b.Dx() != 32 || b.Dy() != 16 {
+		t.Errorf("got a %s thumbnail of %v, want a 32x16 JPEG", img.ThumbnailContentType, b)
+	}
+}
+
+func TestProcessJPEGOrientation(t *testing.T) {
+	data := withSegments(encodeJPEG(t, halves(64, 32)), exifSegment(6))
+
+	img, err := Process(data, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Width != 32 || img.Height != 64 || bytes.Contains(img.Data, []byte("Exif")) {
+		t.Fatalf("got %dx%d, want the image rotated to 32x64 without EXIF", img.Width, img.Height)
+	}
+
+	// Rotated clockwise, the left half is on top.
+	upright, err := jpeg.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, bottom := upright.At(16, 8), upright.At(16, 56)
+	if r, _, b, _ := top.RGBA(); r < 0xC000 || b > 0x4000 {
+		t.Errorf("top is %v, want red", top)
+	}
+	if r, _, b, _ := bottom.RGBA(); r > 0x4000 || b < 0xC000 {
+		t.Errorf("bottom is %v, want blue", bottom)
+	}
+}
+
+// pngChunk returns a PNG chunk.
+func pngChunk(typ string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func TestProcessPNG(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, halves(16, 8))
+	plain := buf.Bytes()
+	// Insert metadata after the header chunk.
+	ihdrEnd := len(pngSignature) + 12 + 13
+	data := append([]byte(nil), plain[:ihdrEnd]...)
+	data = append(data, pngChunk("tEXt", []byte("Author\x00someone"))...)
+	data = append(data, pngChunk("eXIf", []byte("MM\x00*"))...)
+	data = append(data, plain[ihdrEnd:]...)
+
+	img, err := Process(data, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(img.Data, plain) {
+		t.Error("the metadata chunks were not stripped exactly")
+	}
+	if img.Width != 16 || img.Height != 8 || img.ThumbnailContentType != PNG {
+		t.Errorf("got %dx%d with a %s thumbnail", img.Width, img.Height, img.ThumbnailContentType)
+	}
+}
+
+func TestProcessGIF(t *testing.T) {
+	var buf bytes.Buffer
+	gif.Encode(&buf, halves(100, 50), nil)
+
+	img, err := Process(buf.Bytes(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumb, err := png.Decode(bytes.NewReader(img.Thumbnail))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.ContentType != GIF || thumb.Bounds().Dx() != 32 || thumb.Bounds().Dy() != 16 {
+		t.Errorf("got %s with a thumbnail of %v", img.ContentType, thumb.Bounds())
+	}
+}
+
+// xmpGIFExtension returns a GIF application extension holding an XMP packet
+// as Adobe writes it: raw, followed by the "magic trailer" that makes any
+// reader skipping sub-blocks land on the terminator.
+func xmpGIFExtension(packet string) []byte {
+	ext := append([]byte{gifExtension, gifApplication, 11}, "XMP DataXMP"...)
+	ext = append(ext, packet...)
+	ext = append(ext, 1)
+	for b := 0xFF; b >= 0; b-- {
+		ext = append(ext, byte(b))
+	}
+	return append(ext, 0)
+}
+
+func TestProcessGIFMetadata(t *testing.T) {
+	frames := &gif.GIF{LoopCount: 3}
+	for _, c := range []color.Color{color.Black, color.White} {
+		frame := image.NewPaletted(image.Rect(0, 0, 8, 4), color.Palette{color.Black, color.White})
+		draw.Draw(frame, frame.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+		frames.Image = append(frames.Image, frame)
+		frames.Delay = append(frames.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, frames); err != nil {
+		t.Fatal(err)
+	}
+	plain := buf.Bytes()
+
+	// Insert metadata after the global color table and again before the
+	// trailer, and append data after the trailer.
+	header := 13 + gifColorTableSize(plain[10])
+	comment := append([]byte{gifExtension, gifComment, 6}, "secret\x00"...)
+	xmp := xmpGIFExtension(`<x:xmpmeta><exif:GPSLatitude>52.5N</exif:GPSLatitude></x:xmpmeta>`)
+	data := append([]byte(nil), plain[:header]...)
+	data = append(data, comment...)
+	data = append(data, xmp...)
+	data = append(data, plain[header:len(plain)-1]...)
+	data = append(data, comment...)
+	data = append(data, gifTrailer)
+	data = append(data, "GPS 52.5N"...)
+
+	img, err := Process(data, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(img.Data, plain) {
+		t.Error("the metadata extensions were not stripped exactly")
+	}
+	decoded, err := gif.DecodeAll(bytes.NewReader(img.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Image) != 2 || decoded.LoopCount != 3 {
+		t.Errorf("got %d frames looping %d times, want the animation kept", len(decoded.Image), decoded.LoopCount)
+	}
+}
+
+func TestProcessRejects(t *testing.T) {
+	jpg := encodeJPEG(t, halves(64, 32))
+	for name, tc := range map[string]struct {
+		data []byte
+		opts Options
+		want error
+	}{
+		"text":       {[]byte("not an image at all"), opts, ErrUnsupportedType},
+		"svg":        {[]byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), opts, ErrUnsupportedType},
+		"truncated":  {jpg[:len(jpg)/2], opts, ErrInvalidImage},
+		"too large":  {jpg, Options{MaxPixels: 64*32 - 1, ThumbnailSize: 32}, ErrTooManyPixels},
+		"bad header": {append([]byte{0xFF, 0xD8, 0xFF}, "garbage"...), opts, ErrInvalidImage},
+	} {
+		if _, err := Process(tc.data, tc.opts); !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", name, err, tc.want)
+		}
+	}
+}
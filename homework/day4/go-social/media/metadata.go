@@ -0,0 +1,270 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errMalformed = errors.New("malformed image")
+
+// JPEG markers.
+const (
+	markerSOI   = 0xD8
+	markerEOI   = 0xD9
+	markerSOS   = 0xDA
+	markerAPP0  = 0xE0
+	markerAPP1  = 0xE1
+	markerAPP2  = 0xE2
+	markerAPP14 = 0xEE
+	markerAPP15 = 0xEF
+	markerCOM   = 0xFE
+)
+
+// jpegSegments calls fn with the marker and payload of each segment before
+// the image data, stopping when fn returns false. It returns the offset of
+// the start-of-scan marker.
+func jpegSegments(data []byte, fn func(marker byte, payload []byte) bool) (int, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != markerSOI {
+		return 0, errMalformed
+	}
+	i := 2
+	for {
+		if i+1 >= len(data) || data[i] != 0xFF {
+			return 0, errMalformed
+		}
+		// Markers may be preceded by fill bytes.
+		for i+1 < len(data) && data[i+1] == 0xFF {
+			i++
+		}
+		if i+1 >= len(data) {
+			return 0, errMalformed
+		}
+		marker := data[i+1]
+		if marker == markerSOS {
+			return i, nil
+		}
+		if marker == markerEOI || marker == markerSOI || 0xD0 <= marker && marker <= 0xD7 || marker == 0x01 {
+			// Markers without a payload.
+			i += 2
+			continue
+		}
+		if i+4 > len(data) {
+			return 0, errMalformed
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if n < 2 || i+2+n > len(data) {
+			return 0, errMalformed
+		}
+		if !fn(marker, data[i+4:i+2+n]) {
+			return i, nil
+		}
+		i += 2 + n
+	}
+}
+
+// stripJPEG removes the comments and the application segments other than
+// the JFIF header (APP0), ICC profiles (APP2) and the Adobe color transform
+// (APP14), which decoders need.
+func stripJPEG(data []byte) ([]byte, error) {
+	out := []byte{0xFF, markerSOI}
+	sos, err := jpegSegments(data, func(marker byte, payload []byte) bool {
+		isApp := marker >= markerAPP0 && marker <= markerAPP15
+		if marker == markerCOM || isApp && marker != markerAPP0 && marker != markerAPP2 && marker != markerAPP14 {
+			return true
+		}
+		out = append(out, 0xFF, marker)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(payload)+2))
+		out = append(out, payload...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(out, data[sos:]...), nil
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG image, or 0 if it
+// has none.
+func jpegOrientation(data []byte) int {
+	orientation := 0
+	jpegSegments(data, func(marker byte, payload []byte) bool {
+		if marker != markerAPP1 || !bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			return true
+		}
+		orientation = exifOrientation(payload[6:])
+		return false
+	})
+	return orientation
+}
+
+// exifOrientation reads the orientation tag of the first IFD of a TIFF
+// structure.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + 12*i
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		const tagOrientation, typeShort = 0x0112, 3
+		if order.Uint16(tiff[entry:]) == tagOrientation && order.Uint16(tiff[entry+2:]) == typeShort {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngMetadataChunks are the ancillary chunks that carry EXIF data, text or
+// the modification time.
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+// stripPNG removes the metadata chunks of a PNG image.
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errMalformed
+	}
+	out := append([]byte(nil), pngSignature...)
+	for i := len(pngSignature); i < len(data); {
+		if i+8 > len(data) {
+			return nil, errMalformed
+		}
+		n := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + n
+		if n < 0 || end > len(data) || end < i {
+			return nil, errMalformed
+		}
+		if !pngMetadataChunks[string(data[i+4:i+8])] {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out, nil
+}
+
+// GIF block introducers and extension labels.
+const (
+	gifExtension   = 0x21
+	gifImage       = 0x2C
+	gifTrailer     = 0x3B
+	gifComment     = 0xFE
+	gifApplication = 0xFF
+)
+
+// gifAnimationApps identify the application extensions that control
+// animation looping, which are kept.
+var gifAnimationApps = map[string]bool{
+	"NETSCAPE2.0": true,
+	"ANIMEXTS1.0": true,
+}
+
+// gifColorTableSize returns the size of the color table announced by the
+// packed field of a screen or image descriptor.
+func gifColorTableSize(packed byte) int {
+	if packed&0x80 == 0 {
+		return 0
+	}
+	return 3 << (packed&0x07 + 1)
+}
+
+// gifSubBlocks returns the offset just past the sub-blocks starting at i,
+// which end with an empty one.
+func gifSubBlocks(data []byte, i int) (int, error) {
+	for {
+		if i >= len(data) {
+			return 0, errMalformed
+		}
+		n := int(data[i])
+		i += 1 + n
+		if n == 0 {
+			return i, nil
+		}
+	}
+}
+
+// stripGIF removes the comment extensions and the application extensions
+// other than those controlling animation, such as XMP data, as well as
+// anything after the trailer.
+func stripGIF(data []byte) ([]byte, error) {
+	const headerSize = 6 + 7
+	if len(data) < headerSize || !bytes.HasPrefix(data, []byte("GIF8")) {
+		return nil, errMalformed
+	}
+	i := headerSize + gifColorTableSize(data[10])
+	if i > len(data) {
+		return nil, errMalformed
+	}
+	out := append([]byte(nil), data[:i]...)
+	for {
+		if i >= len(data) {
+			return nil, errMalformed
+		}
+		start := i
+		switch data[i] {
+		case gifTrailer:
+			return append(out, gifTrailer), nil
+		case gifImage:
+			const descriptorSize = 10
+			if i+descriptorSize >= len(data) {
+				return nil, errMalformed
+			}
+			// The descriptor and color table are followed by the LZW
+			// minimum code size and the image data.
+			i += descriptorSize + gifColorTableSize(data[i+9]) + 1
+			end, err := gifSubBlocks(data, i)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, data[start:end]...)
+			i = end
+		case gifExtension:
+			if i+2 >= len(data) {
+				return nil, errMalformed
+			}
+			label := data[i+1]
+			end, err := gifSubBlocks(data, i+2)
+			if err != nil {
+				return nil, err
+			}
+			keep := label != gifComment
+			if label == gifApplication {
+				app := data[i+2:]
+				keep = len(app) >= 12 && app[0] == 11 && gifAnimationApps[string(app[1:12])]
+			}
+			if keep {
+				out = append(out, data[start:end]...)
+			}
+			i = end
+		default:
+			return nil, errMalformed
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gosocial/store"
+)
+
+const metricsNamespace = "gosocial"
+
+// metrics holds the collectors of one API server. Each server has its own
+// registry so that several can run in one process, as they do in tests.
+type metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	inFlight        prometheus.Gauge
+
+	signups      prometheus.Counter
+	logins       prometheus.Counter
+	failedLogins prometheus.Counter
+	posts        prometheus.Counter
+	likes        prometheus.Counter
+	comments     prometheus.Counter
+
+	streams prometheus.Gauge
+}
+
+// dbStatser is implemented by storage backends with a connection pool.
+type dbStatser interface {
+	Stats() sql.DBStats
+}
+
+func newMetrics(storage store.Storage) *metrics {
+	counter := func(name, help string) prometheus.Counter {
+		return prometheus.NewCounter(prometheus.CounterOpts{Namespace: metricsNamespace, Name: name, Help: help})
+	}
+
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by method, route template and status code.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by method and route template.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_in_flight",
+			Help:      "HTTP requests currently being served.",
+		}),
+		signups:      counter("signups_total", "Users signed up."),
+		logins:       counter("logins_total", "Successful logins."),
+		failedLogins: counter("failed_logins_total", "Logins rejected for bad credentials."),
+		posts:        counter("posts_created_total", "Posts created."),
+		likes:        counter("likes_total", "Posts liked."),
+		comments:     counter("comments_total", "Comments posted."),
+		streams: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "streams_open",
+			Help:      "Event streams currently connected.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.requestDuration, m.inFlight,
+		m.signups, m.logins, m.failedLogins, m.posts, m.likes, m.comments,
+		m.streams,
+	)
+	if db, ok := storage.(dbStatser); ok {
+		m.registry.MustRegister(newDBStatsCollector(db))
+	}
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// instrument records the count, latency and concurrency of requests,
+// labelled by the route template so that IDs do not create new series. It
+// runs inside withRequestLogging, which provides the requestInfo the route
+// is recorded in.
+func (m *metrics) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		info, _ := r.Context().Value(requestInfoKey).(*requestInfo)
+		route := info.routeLabel()
+		m.requests.WithLabelValues(r.Method, route, strconv.Itoa(rec.status)).Inc()
+		m.requestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// dbStatsCollector exposes the connection pool statistics of a SQL backend.
+type dbStatsCollector struct {
+	db dbStatser
+
+	maxOpen           *prometheus.Desc
+	open              *prometheus.Desc
+	inUse             *prometheus.Desc
+	idle              *prometheus.Desc
+	waitCount         *prometheus.Desc
+	waitDuration      *prometheus.Desc
+	maxIdleClosed     *prometheus.Desc
+	maxIdleTimeClosed *prometheus.Desc
+	maxLifetimeClosed *prometheus.Desc
+}
+
+func newDBStatsCollector(db dbStatser) *dbStatsCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "db", name), help, nil, nil)
+	}
+	return &dbStatsCollector{
+		db:                db,
+		maxOpen:           desc("max_open_connections", "Maximum number of open connections to the database."),
+		open:              desc("open_connections", "Established connections, both in use and idle."),
+		inUse:             desc("in_use_connections", "Connections currently in use."),
+		idle:              desc("idle_connections", "Idle connections."),
+		waitCount:         desc("wait_count_total", "Connections waited for."),
+		waitDuration:      desc("wait_duration_seconds_total", "Time blocked waiting for a new connection."),
+		maxIdleClosed:     desc("max_idle_closed_total", "Connections closed due to the idle connection limit."),
+		maxIdleTimeClosed: desc("max_idle_time_closed_total", "Connections closed due to the idle time limit."),
+		maxLifetimeClosed: desc("max_lifetime_closed_total", "Connections closed due to the connection lifetime limit."),
+	}
+}
+
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.db.Stats()
+	gauge := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v)
+	}
+	counter := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, v)
+	}
+
+	gauge(c.maxOpen, float64(stats.MaxOpenConnections))
+	gauge(c.open, float64(stats.OpenConnections))
+	gauge(c.inUse, float64(stats.InUse))
+	gauge(c.idle, float64(stats.Idle))
+	counter(c.waitCount, float64(stats.WaitCount))
+	counter(c.waitDuration, stats.WaitDuration.Seconds())
+	counter(c.maxIdleClosed, float64(stats.MaxIdleClosed))
+	counter(c.maxIdleTimeClosed, float64(stats.MaxIdleTimeClosed))
+	counter(c.maxLifetimeClosed, float64(stats.MaxLifetimeClosed))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	c := newTestClient(t, testStorages["sqlite"](t))
+	tokens, _ := c.signup("alice")
+	c.createPost(tokens.Token, "hello")
+	c.expect(http.StatusOK, http.MethodPost, "/posts/1/like", tokens.Token, nil, nil)
+	c.expect(http.StatusUnauthorized, http.MethodPost, "/login", "",
+		map[string]string{"username": "alice", "password": "wrong-password"}, nil)
+	c.expect(http.StatusNotFound, http.MethodGet, "/no/such/path", "", nil, nil)
+	c.expect(http.StatusMethodNotAllowed, http.MethodGet, "/signup", "", nil, nil)
+
+	resp, err := http.Get(c.srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`gosocial_http_requests_total{method="POST",route="/posts/{id}/like",status="200"} 1`,
+		`gosocial_http_request_duration_seconds_count{method="POST",route="/posts/{id}/like"} 1`,
+		`gosocial_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`gosocial_http_requests_total{method="GET",route="unmatched",status="405"} 1`,
+		`gosocial_http_requests_in_flight 1`,
+		`gosocial_signups_total 1`,
+		`gosocial_logins_total 1`,
+		`gosocial_failed_logins_total 1`,
+		`gosocial_posts_created_total 1`,
+		`gosocial_likes_total 1`,
+		`gosocial_comments_total 0`,
+		`gosocial_db_open_connections`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics output is missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gosocial/logging"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the request IDs accepted from clients and
+// proxies so that they cannot flood the logs.
+const maxRequestIDLength = 128
+
+const requestIDKey contextKey = "requestID"
+const requestInfoKey contextKey = "requestInfo"
+
+// unmatchedRoute labels requests that matched no route, such as 404s and
+// 405s, so that arbitrary paths do not create new log values or series.
+const unmatchedRoute = "unmatched"
+
+// requestInfo collects what inner handlers learn about a request, such as
+// the matched route and the authenticated user, for the access log and
+// metrics recorded once it completes.
+type requestInfo struct {
+	route  string
+	userID int
+}
+
+// withRequestID propagates the X-Request-ID of the incoming request, or
+// assigns a new one, and echoes it in the response.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// randRead fills request IDs; tests replace it to simulate failures.
+var randRead = rand.Read
+
+// requestIDSeq numbers the request IDs made without randomness.
+var requestIDSeq atomic.Uint64
+
+// newRequestID returns 16 random bytes in hex. Should the system's source of
+// randomness fail, it falls back to the time and a sequence number, which
+// keep IDs distinct rather than giving every request the same one.
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := randRead(b); err != nil {
+		slog.Warn("generating a random request ID failed", "error", err)
+		return fmt.Sprintf("%x-%x", time.Now().UnixNano(), requestIDSeq.Add(1))
+	}
+	return hex.EncodeToString(b)
+}
+
+func GetRequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// withRequestLogging puts a logger tagged with the request ID in the request
+// context and writes one structured line per request once it completes. It
+// wraps the router, so requests that match no route are logged too.
+func withRequestLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		logger := slog.Default().With("request_id", GetRequestIDFromContext(r.Context()))
+		info := new(requestInfo)
+
+		ctx := logging.NewContext(r.Context(), logger)
+		ctx = context.WithValue(ctx, requestInfoKey, info)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("route", info.routeLabel()),
+			slog.Int("status", rec.status),
+			slog.Duration("latency", time.Since(start)),
+			slog.Int64("bytes", rec.bytes),
+		}
+		if info.userID != 0 {
+			attrs = append(attrs, slog.Int("user_id", info.userID))
+		}
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logger.LogAttrs(ctx, level, "request", attrs...)
+	})
+}
+
+// withRoute records the path template of the matched route, which keeps IDs
+// out of the logs and metrics. The router runs it only for matched routes.
+func withRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info, ok := r.Context().Value(requestInfoKey).(*requestInfo); ok {
+			if tmpl, err := mux.CurrentRoute(r).GetPathTemplate(); err == nil {
+				info.route = tmpl
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// routeLabel returns the route recorded by withRoute, or unmatchedRoute.
+func (info *requestInfo) routeLabel() string {
+	if info == nil || info.route == "" {
+		return unmatchedRoute
+	}
+	return info.route
+}
+
+// setRequestUser records the authenticated user for the access log and
+// returns a context whose logger is tagged with the user ID.
+func setRequestUser(ctx context.Context, userID int) context.Context {
+	if info, ok := ctx.Value(requestInfoKey).(*requestInfo); ok {
+		info.userID = userID
+	}
+	return logging.NewContext(ctx, logging.FromContext(ctx).With("user_id", userID))
+}
+
+// statusRecorder captures the status code and body size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gosocial/blob"
+	"gosocial/store"
+)
+
+func TestRequestIDPropagation(t *testing.T) {
+	s, err := NewAPIServer("", store.NewMemoryStorage(), blob.NewLocalStore(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := s.routes()
+
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"propagated", "abc-123", true},
+		{"assigned", "", false},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), false},
+		{"unprintable", "abc\x01", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+			if tt.incoming != "" {
+				req.Header.Set(requestIDHeader, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			got := rec.Header().Get(requestIDHeader)
+			if tt.keep && got != tt.incoming {
+				t.Errorf("got request ID %q, want %q", got, tt.incoming)
+			}
+			if !tt.keep && (got == "" || got == tt.incoming) {
+				t.Errorf("expected a fresh request ID, got %q", got)
+			}
+		})
+	}
+}
+
+func TestNewRequestIDWithoutRandomness(t *testing.T) {
+	defer func(read func([]byte) (int, error)) { randRead = read }(randRead)
+	randRead = func([]byte) (int, error) { return 0, errors.New("no entropy") }
+
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		id := newRequestID()
+		if !validRequestID(id) || seen[id] {
+			t.Fatalf("got request ID %q, want a valid and distinct one", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestRequestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(l *slog.Logger) { slog.SetDefault(l) }(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	storage := store.NewMemoryStorage()
+	c := newTestClient(t, storage)
+	tokens, userID := c.signup("alice")
+	buf.Reset()
+
+	c.expect(http.StatusOK, http.MethodGet, "/users/1/posts", tokens.Token, nil, nil)
+
+	var entry struct {
+		Msg       string `json:"msg"`
+		RequestID string `json:"request_id"`
+		Method    string `json:"method"`
+		Route     string `json:"route"`
+		Status    int    `json:"status"`
+		UserID    int    `json:"user_id"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("decoding log line %q: %v", buf.String(), err)
+	}
+	if entry.Msg != "request" || entry.Method != http.MethodGet || entry.Route != "/users/{id}/posts" ||
+		entry.Status != http.StatusOK || entry.UserID != userID || entry.RequestID == "" {
+		t.Errorf("unexpected log entry: %+v", entry)
+	}
+}
+
+func TestRequestLoggingUnmatched(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(l *slog.Logger) { slog.SetDefault(l) }(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	s, err := NewAPIServer("", store.NewMemoryStorage(), blob.NewLocalStore(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := s.routes()
+
+	for path, want := range map[string]int{"/no/such/path": http.StatusNotFound, "/signup": http.StatusMethodNotAllowed} {
+		buf.Reset()
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+		var entry struct {
+			RequestID string `json:"request_id"`
+			Route     string `json:"route"`
+			Status    int    `json:"status"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("GET %s: decoding log line %q: %v", path, buf.String(), err)
+		}
+		if rec.Code != want || entry.Status != want || entry.Route != unmatchedRoute ||
+			entry.RequestID == "" || entry.RequestID != rec.Header().Get(requestIDHeader) {
+			t.Errorf("GET %s: got %d and log entry %+v, want %d", path, rec.Code, entry, want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"gosocial/configs"
+	"gosocial/store"
+)
+
+const migrateUsage = `usage: gosocial migrate <command> [flags]
+
+commands:
+  up     [-dry-run]             apply all pending migrations
+  down   [-dry-run] [-steps n]  roll back the last n migrations (default 1)
+  status                        list migrations and when they were applied
+  force  -version n [-pending]  record a dirty migration, repaired by hand, as
+                                applied (or pending)`
+
+// runMigrate implements the migrate subcommand against the storage backend
+// selected by DB_DRIVER.
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "print the statements instead of executing them")
+	steps := fs.Int("steps", 1, "number of migrations to roll back")
+	version := fs.Int("version", 0, "dirty migration to record")
+	pending := fs.Bool("pending", false, "record the dirty migration pending instead of applied")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	storage, err := newStorage(configs.Envs)
+	if err != nil {
+		return err
+	}
+	sqlStorage, ok := storage.(interface {
+		Migrator() (*store.Migrator, error)
+	})
+	if !ok {
+		return fmt.Errorf("DB_DRIVER %q has no schema to migrate", configs.Envs.DBDriver)
+	}
+	m, err := sqlStorage.Migrator()
+	if err != nil {
+		return err
+	}
+	m.Log = os.Stdout
+	m.DryRun = *dryRun
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		return m.Up(ctx)
+	case "down":
+		if *steps < 1 {
+			return fmt.Errorf("-steps must be at least 1")
+		}
+		return m.Down(ctx, *steps)
+	case "status":
+		return printMigrationStatus(ctx, m)
+	case "force":
+		if *version < 1 {
+			return fmt.Errorf("-version is required")
+		}
+		return m.Force(ctx, *version, !*pending)
+	default:
+		return fmt.Errorf("unknown migrate command %q\n%s", args[0], migrateUsage)
+	}
+}
+
+func printMigrationStatus(ctx context.Context, m *store.Migrator) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+	for _, s := range statuses {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = s.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		if s.Dirty {
+			applied += " (dirty)"
+		}
+		fmt.Fprintf(tw, "%04d\t%s\t%s\n", s.Version, s.Name, applied)
+	}
+	return tw.Flush()
+}
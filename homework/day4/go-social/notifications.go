@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"gosocial/errs"
+	"gosocial/logging"
+	"gosocial/types"
+)
+
+// maxNotificationReadIDs bounds the IDs of one mark-as-read request.
+const maxNotificationReadIDs = 100
+
+type notificationsReadResponse struct {
+	Marked      int `json:"marked"`
+	UnreadCount int `json:"unreadCount"`
+}
+
+// notifyLike records a like or unlike by actorID for the author of post.
+// The like itself is already stored, so a failure is logged rather than
+// returned: failing the request would make the client retry, which toggles
+// the like back.
+func (s *apiServer) notifyLike(ctx context.Context, post *types.Post, actorID int, liked bool) {
+	if post.UserID == actorID {
+		return
+	}
+	var err error
+	if liked {
+		err = s.store.NotifyLike(ctx, post.UserID, post.ID, actorID)
+	} else {
+		err = s.store.WithdrawLike(ctx, post.ID, actorID)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Warn("recording like notification failed", "post_id", post.ID, "error", err)
+	}
+}
+
+// notifyComment records a new comment for the author of post, logging
+// failures as notifyLike does.
+func (s *apiServer) notifyComment(ctx context.Context, post *types.Post, pc *types.PostComment) {
+	if post.UserID == pc.UserID {
+		return
+	}
+	if err := s.store.NotifyComment(ctx, post.UserID, pc); err != nil {
+		logging.FromContext(ctx).Warn("recording comment notification failed", "post_id", post.ID, "comment_id", pc.ID, "error", err)
+	}
+}
+
+// handleGetNotifications returns the notifications of the current user,
+// newest first, and how many of them are unread.
+func (s *apiServer) handleGetNotifications(w http.ResponseWriter, r *http.Request) error {
+	page, err := getPage(r)
+	if err != nil {
+		return err
+	}
+
+	userID := GetUserIDFromContext(r.Context())
+	notifications, err := s.store.GetNotifications(r.Context(), userID, page.Cursor, page.Limit+1)
+	if err != nil {
+		return err
+	}
+
+	unread, err := s.store.CountUnreadNotifications(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, newNotificationsResponse(notifications, unread, page.Limit))
+}
+
+// handleMarkNotificationsRead marks the notifications listed in the request
+// read, or all of them.
+func (s *apiServer) handleMarkNotificationsRead(w http.ResponseWriter, r *http.Request) error {
+	var req types.NotificationReadRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		return err
+	}
+
+	if req.All == (len(req.IDs) > 0) {
+		return errs.Validation("invalid_selection", "set either ids or all")
+	}
+	if len(req.IDs) > maxNotificationReadIDs {
+		return errs.Validation("too_many_ids", "too many notification IDs")
+	}
+
+	userID := GetUserIDFromContext(r.Context())
+	marked, err := s.store.MarkNotificationsRead(r.Context(), userID, req.IDs)
+	if err != nil {
+		return err
+	}
+
+	unread, err := s.store.CountUnreadNotifications(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, &notificationsReadResponse{Marked: marked, UnreadCount: unread})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"gosocial/types"
+)
+
+func TestNotifications(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		alice, _ := c.signup("alice")
+		bob, bobID := c.signup("bob")
+		carol, carolID := c.signup("carol")
+		c.createPost(alice.Token, "first")
+		c.createPost(alice.Token, "second")
+
+		// Own actions are not notified.
+		c.expect(http.StatusOK, http.MethodPost, "/posts/1/like", alice.Token, nil, nil)
+		c.expect(http.StatusOK, http.MethodPost, "/posts/1/comment", alice.Token, map[string]string{"content": "me"}, nil)
+
+		c.expect(http.StatusOK, http.MethodPost, "/posts/1/like", bob.Token, nil, nil)
+		c.expect(http.StatusOK, http.MethodPost, "/posts/1/like", carol.Token, nil, nil)
+		c.expect(http.StatusOK, http.MethodPost, "/posts/2/comment", bob.Token, map[string]string{"content": "hi"}, nil)
+
+		var resp notificationsResponse
+		c.expect(http.StatusOK, http.MethodGet, "/notifications", alice.Token, nil, &resp)
+		if resp.UnreadCount != 2 || len(resp.Notifications) != 2 {
+			t.Fatalf("got %+v, want a comment and a group of likes", resp)
+		}
+		comment, likes := resp.Notifications[0], resp.Notifications[1]
+		if comment.Type != types.NotificationComment || comment.PostID != 2 || comment.CommentID != 2 ||
+			comment.ActorCount != 1 || comment.Actors[0].ID != bobID || comment.Read {
+			t.Errorf("unexpected comment notification %+v", comment)
+		}
+		if likes.Type != types.NotificationLike || likes.PostID != 1 || likes.ActorCount != 2 ||
+			len(likes.Actors) != 2 || likes.Actors[0].ID != carolID {
+			t.Errorf("unexpected like notification %+v", likes)
+		}
+
+		resp = notificationsResponse{}
+		c.expect(http.StatusOK, http.MethodGet, "/notifications", bob.Token, nil, &resp)
+		if len(resp.Notifications) != 0 || resp.UnreadCount != 0 {
+			t.Errorf("bob got %+v, want nothing", resp)
+		}
+
+		resp = notificationsResponse{}
+		c.expect(http.StatusOK, http.MethodGet, "/notifications?limit=1", alice.Token, nil, &resp)
+		if len(resp.Notifications) != 1 || resp.NextCursor == "" || resp.UnreadCount != 2 {
+			t.Errorf("got %+v, want one notification and a cursor", resp)
+		}
+		next := resp.NextCursor
+		resp = notificationsResponse{}
+		c.expect(http.StatusOK, http.MethodGet, "/notifications?limit=1&cursor="+next, alice.Token, nil, &resp)
+		if len(resp.Notifications) != 1 || resp.Notifications[0].Type != types.NotificationLike || resp.NextCursor != "" {
+			t.Errorf("got %+v, want the likes on the last page", resp)
+		}
+	})
+}
+
+func TestNotificationLikeWithdrawal(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		alice, _ := c.signup("alice")
+		bob, _ := c.signup("bob")
+		carol, carolID := c.signup("carol")
+		c.createPost(alice.Token, "post")
+
+		list := func() []*types.Notification {
+			t.Helper()
+			var resp notificationsResponse
+			c.expect(http.StatusOK, http.MethodGet, "/notifications", alice.Token, nil, &resp)
+			return resp.Notifications
+		}
+
+		// An unlike withdraws the notification of a single like.
+		c.expect(http.StatusOK, http.MethodPost, "/posts/1/like", bob.Token, nil, nil)
+		c.expect(http.StatusOK, http.MethodPost, "/posts/1/like", bob.Token, nil, nil)
+		if got := list(); len(got) != 0 {
+			t.Fatalf("got %+v after like and unlike, want nothing", got)
+		}
+
+		// In a group, it only removes the user.
+		c.expect(http.StatusOK, http.MethodPost, "/posts/1/like", bob.Token, nil, nil)
+		c.expect(http.StatusOK, http.MethodPost, "/posts/1/like", carol.Token, nil, nil)
+		c.expect(http.StatusOK, http.MethodPost, "/posts/1/like", bob.Token, nil, nil)
+		if got := list(); len(got) != 1 || got[0].ActorCount != 1 || got[0].Actors[0].ID != carolID {
+			t.Fatalf("got %+v, want carol's like left", got)
+		}
+
+		// Likes after the group was read start a new one.
+		c.expect(http.StatusOK, http.MethodPost, "/notifications/read", alice.Token, map[string]bool{"all": true}, nil)
+		c.expect(http.StatusOK, http.MethodPost, "/posts/1/like", bob.Token, nil, nil)
+		got := list()
+		if len(got) != 2 || got[0].Read || got[0].ActorCount != 1 || !got[1].Read {
+			t.Errorf("got %+v, want a new unread group above the read one", got)
+		}
+
+		// Unlikes only withdraw from the unread group; the read one is history.
+		c.expect(http.StatusOK, http.MethodPost, "/posts/1/like", bob.Token, nil, nil)
+		c.expect(http.StatusOK, http.MethodPost, "/posts/1/like", carol.Token, nil, nil)
+		got = list()
+		if len(got) != 1 || !got[0].Read || got[0].ActorCount != 1 || got[0].Actors[0].ID != carolID {
+			t.Errorf("got %+v, want the read group left as it was", got)
+		}
+	})
+}
+
+func TestMarkNotificationsRead(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		alice, _ := c.signup("alice")
+		bob, _ := c.signup("bob")
+		c.createPost(alice.Token, "post")
+		c.createPost(bob.Token, "bob's post")
+		for _, content := range []string{"one", "two", "three"} {
+			c.expect(http.StatusOK, http.MethodPost, "/posts/1/comment", bob.Token, map[string]string{"content": content}, nil)
+		}
+		c.expect(http.StatusOK, http.MethodPost, "/posts/2/comment", alice.Token, map[string]string{"content": "hey"}, nil)
+
+		var read notificationsReadResponse
+		// Notification 4 is bob's and is left alone.
+		c.expect(http.StatusOK, http.MethodPost, "/notifications/read", alice.Token, map[string][]int{"ids": {1, 2, 4}}, &read)
+		if read.Marked != 2 || read.UnreadCount != 1 {
+			t.Errorf("got %+v, want 2 marked and 1 unread", read)
+		}
+		c.expect(http.StatusOK, http.MethodPost, "/notifications/read", alice.Token, map[string]bool{"all": true}, &read)
+		if read.Marked != 1 || read.UnreadCount != 0 {
+			t.Errorf("got %+v, want 1 marked and none unread", read)
+		}
+
+		var resp notificationsResponse
+		c.expect(http.StatusOK, http.MethodGet, "/notifications", bob.Token, nil, &resp)
+		if resp.UnreadCount != 1 || resp.Notifications[0].Read {
+			t.Errorf("bob got %+v, want his notification unread", resp)
+		}
+
+		// Deleting the comment deletes its notification.
+		c.expect(http.StatusOK, http.MethodDelete, "/posts/1/comments/3", bob.Token, nil, nil)
+		resp = notificationsResponse{}
+		c.expect(http.StatusOK, http.MethodGet, "/notifications", alice.Token, nil, &resp)
+		if len(resp.Notifications) != 2 {
+			t.Errorf("got %d notifications after the comment was deleted, want 2", len(resp.Notifications))
+		}
+
+		c.expectError(http.StatusUnprocessableEntity, "invalid_selection", http.MethodPost, "/notifications/read", alice.Token, map[string]any{})
+		c.expectError(http.StatusUnprocessableEntity, "invalid_selection", http.MethodPost, "/notifications/read", alice.Token, map[string]any{"ids": []int{1}, "all": true})
+		c.expectError(http.StatusUnprocessableEntity, "too_many_ids", http.MethodPost, "/notifications/read", alice.Token, map[string]any{"ids": make([]int, 101)})
+	})
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"gosocial/blob"
+	"gosocial/errs"
+	"gosocial/search"
+	"gosocial/store"
+	"gosocial/types"
+
+	"github.com/gorilla/mux"
+)
+
+type openAPIDoc struct {
+	OpenAPI string                                `json:"openapi"`
+	Paths   map[string]map[string]json.RawMessage `json:"paths"`
+}
+
+func loadOpenAPIDoc(t *testing.T) openAPIDoc {
+	t.Helper()
+	var doc openAPIDoc
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatalf("decoding openapi.json: %v", err)
+	}
+	return doc
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	s, err := NewAPIServer("", store.NewMemoryStorage(), blob.NewLocalStore(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := loadOpenAPIDoc(t)
+
+	registered := map[string]bool{}
+	err = s.router().Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		tmpl, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		for _, method := range methods {
+			method = strings.ToLower(method)
+			registered[method+" "+tmpl] = true
+			if _, ok := doc.Paths[tmpl][method]; !ok {
+				t.Errorf("route %s %s is missing from openapi.json", strings.ToUpper(method), tmpl)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, ops := range doc.Paths {
+		for method := range ops {
+			if !registered[method+" "+path] {
+				t.Errorf("openapi.json documents %s %s, which is not routed", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+func TestOpenAPIRefsResolve(t *testing.T) {
+	var doc map[string]any
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["openapi"] != "3.1.0" {
+		t.Errorf("got openapi version %v, want 3.1.0", doc["openapi"])
+	}
+
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok {
+				var target any = doc
+				for _, key := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+					m, _ := target.(map[string]any)
+					target = m[key]
+				}
+				if target == nil {
+					t.Errorf("unresolved $ref %s", ref)
+				}
+			}
+			for _, child := range v {
+				walk(child)
+			}
+		case []any:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(doc)
+}
+
+// schemaTypes maps the component schemas to the types they describe.
+var schemaTypes = map[string]any{
+	"Error":               apiError{},
+	"FieldError":          errs.FieldError{},
+	"UserSignupRequest":   types.UserSignupRequest{},
+	"UserLoginRequest":    types.UserLoginRequest{},
+	"UserUpdateRequest":   types.UserUpdateRequest{},
+	"TokenRefreshRequest": types.TokenRefreshRequest{},
+	"TokenResponse":       types.TokenResponse{},
+	"User":                types.User{},
+	"UserSummary":         types.UserSummary{},
+	"FollowListEntry":     types.FollowListEntry{},
+	"PostLiker":           types.PostLiker{},
+	"PostCreateRequest":   types.PostCreateRequest{},
+	"PostUpdateRequest":   types.PostUpdateRequest{},
+	"PostCommentRequest":  types.PostCommentRequest{},
+	"Post":                types.Post{},
+	"FeedPost":            types.FeedPost{},
+	"PostComment":         types.PostComment{},
+	"PostCommentEntry":    types.PostCommentEntry{},
+	"PostDetail":          types.PostDetail{},
+	"FeedResponse":        feedResponse{},
+	"FollowListResponse":  followListResponse{},
+	"PostLikersResponse":  postLikersResponse{},
+	"HealthCheck":         types.HealthCheck{},
+	"HealthResponse":      types.HealthResponse{},
+	"JWKS": struct {
+		Keys []jwk `json:"keys"`
+	}{},
+	"Range":                     search.Range{},
+	"Snippet":                   search.Snippet{},
+	"PostSearchResult":          postSearchResult{},
+	"UserSearchResult":          userSearchResult{},
+	"PostSearchResults":         postSearchResults{},
+	"UserSearchResults":         userSearchResults{},
+	"SearchResponse":            searchResponse{},
+	"Entity":                    types.Entity{},
+	"Mention":                   types.Mention{},
+	"MentionsResponse":          mentionsResponse{},
+	"Notification":              types.Notification{},
+	"NotificationsResponse":     notificationsResponse{},
+	"NotificationReadRequest":   types.NotificationReadRequest{},
+	"NotificationsReadResponse": notificationsReadResponse{},
+	"LikeEvent":                 likeEvent{},
+	"StreamMessage":             streamMessage{},
+	"Attachment":                types.Attachment{},
+	"AttachmentsResponse":       attachmentsResponse{},
+}
+
+// jsonFields returns the names under which encoding/json writes the fields
+// of t, including those of embedded structs.
+func jsonFields(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if tag == "-" {
+			continue
+		}
+		if f.Anonymous && tag == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			names = append(names, jsonFields(ft)...)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		names = append(names, tag)
+	}
+	return names
+}
+
+type openAPISchema struct {
+	Ref        string                     `json:"$ref"`
+	AllOf      []openAPISchema            `json:"allOf"`
+	Properties map[string]json.RawMessage `json:"properties"`
+	Required   []string                   `json:"required"`
+}
+
+// schemaProperties returns the properties of a schema, following allOf and
+// references to other component schemas.
+func schemaProperties(t *testing.T, schemas map[string]openAPISchema, s openAPISchema) (props, required []string) {
+	t.Helper()
+	if s.Ref != "" {
+		return schemaProperties(t, schemas, schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")])
+	}
+	for name := range s.Properties {
+		props = append(props, name)
+	}
+	required = append(required, s.Required...)
+	for _, sub := range s.AllOf {
+		p, r := schemaProperties(t, schemas, sub)
+		props, required = append(props, p...), append(required, r...)
+	}
+	return props, required
+}
+
+func TestOpenAPISchemasMatchTypes(t *testing.T) {
+	var doc struct {
+		Components struct {
+			Schemas map[string]openAPISchema `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatal(err)
+	}
+	schemas := doc.Components.Schemas
+
+	for name, v := range schemaTypes {
+		s, ok := schemas[name]
+		if !ok {
+			t.Errorf("schema %s is missing from openapi.json", name)
+			continue
+		}
+		props, required := schemaProperties(t, schemas, s)
+		fields := jsonFields(reflect.TypeOf(v))
+		slices.Sort(props)
+		slices.Sort(fields)
+		if !slices.Equal(props, fields) {
+			t.Errorf("schema %s has properties %v, but %T encodes %v", name, props, v, fields)
+		}
+		for _, r := range required {
+			if !slices.Contains(props, r) {
+				t.Errorf("schema %s requires %q, which is not one of its properties", name, r)
+			}
+		}
+	}
+
+	// Message is a plain map and AttachmentUpload a multipart form.
+	for name := range schemas {
+		if _, ok := schemaTypes[name]; !ok && name != "Message" && name != "AttachmentUpload" {
+			t.Errorf("schema %s is not checked against a type", name)
+		}
+	}
+}
+
+func TestOpenAPIServed(t *testing.T) {
+	s, err := NewAPIServer("", store.NewMemoryStorage(), blob.NewLocalStore(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := s.routes()
+
+	for path, contentType := range map[string]string{
+		"/openapi.json": "application/json",
+		"/docs":         "text/html; charset=utf-8",
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != contentType {
+			t.Errorf("GET %s: got %d %q, want 200 %q", path, rec.Code, rec.Header().Get("Content-Type"), contentType)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gosocial/configs"
+	"gosocial/errs"
+	"gosocial/types"
+)
+
+type page struct {
+	Limit  int
+	Cursor *types.Cursor
+}
+
+// getPage reads the "limit" and "cursor" query parameters of a list ordered
+// newest first.
+func getPage(r *http.Request) (*page, error) {
+	limit, err := getLimit(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &page{Limit: limit}
+
+	if cursorStr := r.URL.Query().Get("cursor"); cursorStr != "" {
+		cursor, err := decodeCursor(cursorStr)
+		if err != nil {
+			return nil, errs.BadRequest("invalid_cursor", "invalid cursor")
+		}
+		p.Cursor = cursor
+	}
+
+	return p, nil
+}
+
+// getLimit reads the "limit" query parameter, which falls back to the
+// configured default and is capped at the configured maximum.
+func getLimit(r *http.Request) (int, error) {
+	limitStr := r.URL.Query().Get("limit")
+	if limitStr == "" {
+		return int(configs.Envs.DefaultPageSize), nil
+	}
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit <= 0 {
+		return 0, errs.BadRequest("invalid_limit", "invalid limit")
+	}
+	return min(limit, int(configs.Envs.MaxPageSize)), nil
+}
+
+// offsetPage is a page of a ranked list, which has no key to resume from
+// and is paged by position instead.
+type offsetPage struct {
+	Limit  int
+	Offset int
+}
+
+// getOffsetPage reads the "limit" and "cursor" query parameters of a ranked
+// list.
+func getOffsetPage(r *http.Request) (*offsetPage, error) {
+	limit, err := getLimit(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &offsetPage{Limit: limit}
+
+	if cursorStr := r.URL.Query().Get("cursor"); cursorStr != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(cursorStr)
+		if err != nil {
+			return nil, errs.BadRequest("invalid_cursor", "invalid cursor")
+		}
+		offsetStr, ok := strings.CutPrefix(string(raw), "offset:")
+		offset, err := strconv.Atoi(offsetStr)
+		if !ok || err != nil || offset < 0 {
+			return nil, errs.BadRequest("invalid_cursor", "invalid cursor")
+		}
+		p.Offset = offset
+	}
+
+	return p, nil
+}
+
+// nextOffsetCursor returns the cursor of the page after p when a result
+// fetched with limit+1 rows holds n of them, or "" if p is the last page.
+func (p *offsetPage) nextOffsetCursor(n int) string {
+	if n <= p.Limit {
+		return ""
+	}
+	raw := fmt.Sprintf("offset:%d", p.Offset+p.Limit)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func encodeCursor(c types.Cursor) string {
+	raw := fmt.Sprintf("%d:%d", c.CreatedAt.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (*types.Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	tsStr, idStr, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	ts, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.Cursor{CreatedAt: time.Unix(0, ts).UTC(), ID: id}, nil
+}
+
+type feedResponse struct {
+	Posts      []*types.FeedPost `json:"posts"`
+	NextCursor string            `json:"nextCursor,omitempty"`
+}
+
+// newFeedResponse trims a result fetched with limit+1 rows down to limit and
+// sets the next cursor when more rows remain.
+func newFeedResponse(posts []*types.FeedPost, limit int) *feedResponse {
+	resp := &feedResponse{Posts: posts}
+	if len(posts) > limit {
+		resp.Posts = posts[:limit]
+		last := resp.Posts[limit-1]
+		resp.NextCursor = encodeCursor(types.Cursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	return resp
+}
+
+type followListResponse struct {
+	Users      []*types.FollowListEntry `json:"users"`
+	NextCursor string                   `json:"nextCursor,omitempty"`
+}
+
+func newFollowListResponse(entries []*types.FollowListEntry, limit int) *followListResponse {
+	resp := &followListResponse{Users: entries}
+	if len(entries) > limit {
+		resp.Users = entries[:limit]
+		last := resp.Users[limit-1]
+		resp.NextCursor = encodeCursor(types.Cursor{CreatedAt: last.FollowedAt, ID: last.FollowID})
+	}
+	return resp
+}
+
+type postLikersResponse struct {
+	Users      []*types.PostLiker `json:"users"`
+	NextCursor string             `json:"nextCursor,omitempty"`
+}
+
+func newPostLikersResponse(likers []*types.PostLiker, limit int) *postLikersResponse {
+	resp := &postLikersResponse{Users: likers}
+	if len(likers) > limit {
+		resp.Users = likers[:limit]
+		last := resp.Users[limit-1]
+		resp.NextCursor = encodeCursor(types.Cursor{CreatedAt: last.LikedAt, ID: last.LikeID})
+	}
+	return resp
+}
+
+type mentionsResponse struct {
+	Mentions   []*types.Mention `json:"mentions"`
+	NextCursor string           `json:"nextCursor,omitempty"`
+}
+
+func newMentionsResponse(mentions []*types.Mention, limit int) *mentionsResponse {
+	resp := &mentionsResponse{Mentions: mentions}
+	if len(mentions) > limit {
+		resp.Mentions = mentions[:limit]
+		last := resp.Mentions[limit-1]
+		resp.NextCursor = encodeCursor(types.Cursor{CreatedAt: last.MentionedAt, ID: last.ID})
+	}
+	return resp
+}
+
+type notificationsResponse struct {
+	Notifications []*types.Notification `json:"notifications"`
+	UnreadCount   int                   `json:"unreadCount"`
+	NextCursor    string                `json:"nextCursor,omitempty"`
+}
+
+func newNotificationsResponse(notifications []*types.Notification, unread, limit int) *notificationsResponse {
+	resp := &notificationsResponse{Notifications: notifications, UnreadCount: unread}
+	if len(notifications) > limit {
+		resp.Notifications = notifications[:limit]
+		last := resp.Notifications[limit-1]
+		resp.NextCursor = encodeCursor(types.Cursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	return resp
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gosocial/configs"
+	"gosocial/errs"
+	"gosocial/logging"
+	"gosocial/ratelimit"
+)
+
+var errRateLimited = errs.TooManyRequests("rate_limited", "too many requests")
+
+// rateLimits holds the policy of each route group.
+type rateLimits struct {
+	// auth covers signup, login and token refresh, keyed by client IP.
+	auth ratelimit.Policy
+	// write covers authenticated requests that change state.
+	write ratelimit.Policy
+	// read covers authenticated reads.
+	read ratelimit.Policy
+	// ip covers every authenticated route per client IP. It is checked
+	// before the token, so requests with bad tokens are limited too.
+	ip ratelimit.Policy
+}
+
+func newRateLimits(c configs.Config) rateLimits {
+	policy := func(name string, perMinute, burst int64) ratelimit.Policy {
+		return ratelimit.Policy{Name: name, Rate: float64(perMinute) / 60, Burst: int(burst)}
+	}
+	return rateLimits{
+		auth:  policy("auth", c.RateLimitAuthPerMinute, c.RateLimitAuthBurst),
+		write: policy("write", c.RateLimitWritePerMinute, c.RateLimitWriteBurst),
+		read:  policy("read", c.RateLimitReadPerMinute, c.RateLimitReadBurst),
+		ip:    policy("ip", c.RateLimitIPPerMinute, c.RateLimitIPBurst),
+	}
+}
+
+// rateLimit applies policy to h, counting requests per authenticated user
+// when h runs behind WithJWTAuth and per client IP otherwise.
+func (s *apiServer) rateLimit(policy ratelimit.Policy, h http.HandlerFunc) http.HandlerFunc {
+	if !policy.Enabled() {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := "ip:" + s.clientIP(r)
+		if userID := GetUserIDFromContext(r.Context()); userID != -1 {
+			key = "user:" + strconv.Itoa(userID)
+		}
+
+		res, err := s.limiter.Allow(r.Context(), key, policy)
+		if err != nil {
+			// Fail open: an unavailable limiter backend must not take the
+			// API down with it.
+			logging.FromContext(r.Context()).Error("rate limiter failed", "error", err)
+			h(w, r)
+			return
+		}
+
+		w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Burst, ceilSeconds(policy.Window())))
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.ResetAfter)))
+		if !res.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			writeError(w, r, errRateLimited)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// authenticated serves h to authenticated users, limiting requests per client
+// IP before checking the token and per user after.
+func (s *apiServer) authenticated(policy ratelimit.Policy, h apiFunc) http.HandlerFunc {
+	return s.rateLimit(s.limits.ip, WithJWTAuth(s.rateLimit(policy, makeHTTPHandlerFunc(h)), s.store))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// clientIP returns the address of the client that sent the request. The
+// X-Forwarded-For header is only believed when the request came through a
+// trusted proxy; it is read right to left, skipping further trusted proxies,
+// so that a client cannot choose its own address by sending the header.
+func (s *apiServer) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !s.isTrustedProxy(host) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		host = hop
+		if !s.isTrustedProxy(hop) {
+			break
+		}
+	}
+	return host
+}
+
+func (s *apiServer) isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range s.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses a comma-separated list of IP addresses and CIDR
+// ranges.
+func parseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
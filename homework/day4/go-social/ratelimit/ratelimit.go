@@ -0,0 +1,122 @@
+// Package ratelimit implements token-bucket rate limiting. The bucket state
+// lives behind the Limiter interface so that a backend shared between
+// replicas can replace the in-process one.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Policy allows Burst requests at once, refilled at Rate requests per second.
+type Policy struct {
+	Name  string
+	Rate  float64
+	Burst int
+}
+
+// Enabled reports whether the policy limits anything.
+func (p Policy) Enabled() bool {
+	return p.Rate > 0 && p.Burst > 0
+}
+
+// Window is the time an empty bucket takes to refill completely.
+func (p Policy) Window() time.Duration {
+	return time.Duration(float64(p.Burst) / p.Rate * float64(time.Second))
+}
+
+// Result describes the state of a bucket after a request was counted.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until the next request would be allowed. It
+	// is zero when Allowed is true.
+	RetryAfter time.Duration
+	// ResetAfter is how long until the bucket is full again.
+	ResetAfter time.Duration
+}
+
+type Limiter interface {
+	// Allow takes a token for key from the bucket described by policy.
+	Allow(ctx context.Context, key string, policy Policy) (Result, error)
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	policy  Policy
+}
+
+// refill adds the tokens earned since the last update.
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.policy.Burst), b.tokens+now.Sub(b.updated).Seconds()*b.policy.Rate)
+	b.updated = now
+}
+
+// MemoryLimiter keeps one bucket per key in process memory.
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// sweepInterval is how often buckets that have refilled completely, and so
+// are indistinguishable from new ones, are dropped.
+const sweepInterval = time.Minute
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+func (l *MemoryLimiter) Allow(ctx context.Context, key string, policy Policy) (Result, error) {
+	key = policy.Name + ":" + key
+	burst := float64(policy.Burst)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, updated: now}
+		l.buckets[key] = b
+	}
+	b.policy = policy
+	b.refill(now)
+
+	res := Result{Limit: policy.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / policy.Rate)
+	}
+	res.Remaining = int(b.tokens)
+	res.ResetAfter = seconds((burst - b.tokens) / policy.Rate)
+	return res, nil
+}
+
+// sweep drops the buckets that have refilled completely.
+func (l *MemoryLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.refill(now); b.tokens >= float64(b.policy.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
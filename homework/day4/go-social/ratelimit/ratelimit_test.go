@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewMemoryLimiter()
+	l.now = func() time.Time { return now }
+	policy := Policy{Name: "test", Rate: 1, Burst: 2}
+	ctx := context.Background()
+
+	allow := func(key string) Result {
+		t.Helper()
+		res, err := l.Allow(ctx, key, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	for i, want := range []int{1, 0} {
+		if res := allow("a"); !res.Allowed || res.Remaining != want {
+			t.Fatalf("request %d: got %+v, want allowed with %d remaining", i, res, want)
+		}
+	}
+
+	res := allow("a")
+	if res.Allowed || res.RetryAfter != time.Second || res.ResetAfter != 2*time.Second {
+		t.Fatalf("got %+v, want rejection retrying after 1s", res)
+	}
+
+	// Other keys have their own bucket.
+	if res := allow("b"); !res.Allowed {
+		t.Fatalf("key b: got %+v", res)
+	}
+
+	now = now.Add(time.Second)
+	if res := allow("a"); !res.Allowed {
+		t.Fatalf("after refill: got %+v", res)
+	}
+}
+
+func TestMemoryLimiterSweep(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewMemoryLimiter()
+	l.now = func() time.Time { return now }
+	policy := Policy{Name: "test", Rate: 1, Burst: 2}
+
+	l.Allow(context.Background(), "a", policy)
+	now = now.Add(sweepInterval + time.Second)
+	l.Allow(context.Background(), "b", policy)
+
+	if _, ok := l.buckets["test:a"]; ok {
+		t.Error("refilled bucket was not swept")
+	}
+	if _, ok := l.buckets["test:b"]; !ok {
+		t.Error("active bucket was swept")
+	}
+}
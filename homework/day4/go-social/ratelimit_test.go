@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gosocial/blob"
+	"gosocial/ratelimit"
+	"gosocial/store"
+)
+
+func TestRateLimit(t *testing.T) {
+	s, err := NewAPIServer("", store.NewMemoryStorage(), blob.NewLocalStore(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.limits.auth = ratelimit.Policy{Name: "auth", Rate: 1.0 / 60, Burst: 2}
+	router := s.routes()
+
+	login := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := login("192.0.2.1:1234"); rec.Code == http.StatusTooManyRequests {
+			t.Fatalf("request %d was rate limited", i)
+		}
+	}
+
+	rec := login("192.0.2.1:1234")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	for header, want := range map[string]string{
+		"Retry-After":         "60",
+		"RateLimit-Limit":     "2",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Policy":    "2;w=120",
+	} {
+		if got := rec.Header().Get(header); got != want {
+			t.Errorf("%s: got %q, want %q", header, got, want)
+		}
+	}
+
+	if rec := login("192.0.2.2:1234"); rec.Code == http.StatusTooManyRequests {
+		t.Error("another client was rate limited")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &apiServer{trustedProxies: proxies}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		want         string
+	}{
+		{"direct", "203.0.113.5:1234", "", "203.0.113.5"},
+		{"untrusted peer cannot spoof", "203.0.113.5:1234", "198.51.100.7", "203.0.113.5"},
+		{"trusted proxy", "10.0.0.1:1234", "198.51.100.7", "198.51.100.7"},
+		{"chain of trusted proxies", "10.0.0.1:1234", "198.51.100.7, 192.0.2.1, 10.0.0.2", "198.51.100.7"},
+		{"spoofed leftmost entry", "10.0.0.1:1234", "1.1.1.1, 198.51.100.7", "198.51.100.7"},
+		{"garbage header", "10.0.0.1:1234", "not-an-ip", "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			if got := s.clientIP(req); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitBadTokens(t *testing.T) {
+	s, err := NewAPIServer("", store.NewMemoryStorage(), blob.NewLocalStore(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.limits.ip = ratelimit.Policy{Name: "ip", Rate: 1.0 / 60, Burst: 3}
+	router := s.routes()
+
+	profile := func(remoteAddr, token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Authorization", token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for i := 0; i < 3; i++ {
+		if got := profile("192.0.2.1:1234", "forged"); got != http.StatusUnauthorized {
+			t.Fatalf("request %d: got status %d, want 401", i, got)
+		}
+	}
+	if got := profile("192.0.2.1:1234", "forged"); got != http.StatusTooManyRequests {
+		t.Errorf("got status %d, want the bad tokens to be rate limited", got)
+	}
+	if got := profile("192.0.2.2:1234", "forged"); got != http.StatusUnauthorized {
+		t.Errorf("another client got status %d, want 401", got)
+	}
+}
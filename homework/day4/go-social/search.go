@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"gosocial/errs"
+	"gosocial/search"
+	"gosocial/types"
+)
+
+const (
+	maxSearchQueryLength = 200
+	// snippetLength is the length in characters of the excerpt of a post
+	// or profile returned with each search result.
+	snippetLength = 160
+)
+
+const (
+	searchTypeAll   = "all"
+	searchTypePosts = "posts"
+	searchTypeUsers = "users"
+)
+
+type postSearchResult struct {
+	*types.PostSearchHit
+	Snippet search.Snippet `json:"snippet"`
+}
+
+type userSearchResult struct {
+	*types.UserSearchHit
+	UsernameHighlights []search.Range `json:"usernameHighlights"`
+	// Snippet is an excerpt of the user's profile.
+	Snippet search.Snippet `json:"snippet"`
+}
+
+type postSearchResults struct {
+	Results    []*postSearchResult `json:"results"`
+	NextCursor string              `json:"nextCursor,omitempty"`
+}
+
+type userSearchResults struct {
+	Results    []*userSearchResult `json:"results"`
+	NextCursor string              `json:"nextCursor,omitempty"`
+}
+
+// searchResponse holds the sections selected by the "type" parameter.
+type searchResponse struct {
+	Posts *postSearchResults `json:"posts,omitempty"`
+	Users *userSearchResults `json:"users,omitempty"`
+}
+
+// handleSearch returns the posts and users best matching the "q" parameter.
+// With type=all, the default, it returns the first page of both; the cursor
+// of either section pages through it with type=posts or type=users.
+func (s *apiServer) handleSearch(w http.ResponseWriter, r *http.Request) error {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		return errs.BadRequest("invalid_query", "missing search query")
+	}
+	if utf8.RuneCountInString(query) > maxSearchQueryLength {
+		return errs.BadRequest("invalid_query", "search query is too long")
+	}
+
+	searchType := r.URL.Query().Get("type")
+	if searchType == "" {
+		searchType = searchTypeAll
+	}
+	if searchType != searchTypeAll && searchType != searchTypePosts && searchType != searchTypeUsers {
+		return errs.BadRequest("invalid_type", "type must be all, posts or users")
+	}
+
+	page, err := getOffsetPage(r)
+	if err != nil {
+		return err
+	}
+	if searchType == searchTypeAll && r.URL.Query().Get("cursor") != "" {
+		return errs.BadRequest("invalid_cursor", "cursor requires type posts or users")
+	}
+
+	terms := search.Terms(query)
+	resp := &searchResponse{}
+
+	if searchType != searchTypeUsers {
+		hits, err := s.store.SearchPosts(r.Context(), terms, page.Offset, page.Limit+1)
+		if err != nil {
+			return err
+		}
+		resp.Posts = &postSearchResults{Results: []*postSearchResult{}, NextCursor: page.nextOffsetCursor(len(hits))}
+		for _, h := range hits[:min(len(hits), page.Limit)] {
+			resp.Posts.Results = append(resp.Posts.Results, &postSearchResult{
+				PostSearchHit: h,
+				Snippet:       search.MakeSnippet(h.Content, terms, snippetLength),
+			})
+		}
+		posts := make([]*types.Post, len(resp.Posts.Results))
+		for i, res := range resp.Posts.Results {
+			posts[i] = &res.Post
+		}
+		if err := s.setPostEntities(r.Context(), posts...); err != nil {
+			return err
+		}
+		if err := s.setPostAttachments(r.Context(), posts...); err != nil {
+			return err
+		}
+	}
+
+	if searchType != searchTypePosts {
+		hits, err := s.store.SearchUsers(r.Context(), terms, page.Offset, page.Limit+1)
+		if err != nil {
+			return err
+		}
+		resp.Users = &userSearchResults{Results: []*userSearchResult{}, NextCursor: page.nextOffsetCursor(len(hits))}
+		for _, h := range hits[:min(len(hits), page.Limit)] {
+			resp.Users.Results = append(resp.Users.Results, &userSearchResult{
+				UserSearchHit:      h,
+				UsernameHighlights: search.MakeSnippet(h.Username, terms, snippetLength).Highlights,
+				Snippet:            search.MakeSnippet(h.UserProfile, terms, snippetLength),
+			})
+		}
+	}
+
+	return WriteJSON(w, http.StatusOK, resp)
+}
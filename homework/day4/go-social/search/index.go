@@ -0,0 +1,153 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// BM25 parameters: k1 limits how much repeating a term raises the score, b
+// how much long documents are penalized.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Hit is a document matching a search.
+type Hit struct {
+	ID    int
+	Score float64
+}
+
+// Index is a thread-safe inverted index of documents identified by an int.
+// Searches match documents containing any of the query terms and rank them
+// with BM25.
+type Index struct {
+	mu sync.RWMutex
+
+	// postings maps a term to the documents containing it and how often.
+	postings    map[string]map[int]int
+	docs        map[int]document
+	totalLength int
+}
+
+type document struct {
+	// length is the number of terms in the document, counting repeats.
+	length int
+	terms  []string
+}
+
+func NewIndex() *Index {
+	return &Index{
+		postings: map[string]map[int]int{},
+		docs:     map[int]document{},
+	}
+}
+
+// Put indexes the texts of a document, replacing what was indexed for it
+// before.
+func (ix *Index) Put(id int, texts ...string) {
+	freqs := map[string]int{}
+	length := 0
+	for _, text := range texts {
+		for _, t := range Tokenize(text) {
+			freqs[t.Term]++
+			length++
+		}
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.delete(id)
+	doc := document{length: length, terms: make([]string, 0, len(freqs))}
+	for term, n := range freqs {
+		docs, ok := ix.postings[term]
+		if !ok {
+			docs = map[int]int{}
+			ix.postings[term] = docs
+		}
+		docs[id] = n
+		doc.terms = append(doc.terms, term)
+	}
+	ix.docs[id] = doc
+	ix.totalLength += length
+}
+
+// Delete removes a document from the index.
+func (ix *Index) Delete(id int) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.delete(id)
+}
+
+// delete removes a document. Callers hold mu.
+func (ix *Index) delete(id int) {
+	doc, ok := ix.docs[id]
+	if !ok {
+		return
+	}
+	for _, term := range doc.terms {
+		docs := ix.postings[term]
+		delete(docs, id)
+		if len(docs) == 0 {
+			delete(ix.postings, term)
+		}
+	}
+	delete(ix.docs, id)
+	ix.totalLength -= doc.length
+}
+
+// Len returns the number of documents in the index.
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	return len(ix.docs)
+}
+
+// Search returns up to limit documents matching any of terms, best match
+// first and newest (highest ID) first among equal scores, skipping the
+// first offset matches.
+func (ix *Index) Search(terms []string, offset, limit int) []Hit {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	n := float64(len(ix.docs))
+	if n == 0 {
+		return nil
+	}
+	avgLength := float64(ix.totalLength) / n
+
+	scores := map[int]float64{}
+	for _, term := range terms {
+		docs := ix.postings[term]
+		if len(docs) == 0 {
+			continue
+		}
+		df := float64(len(docs))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for id, tf := range docs {
+			f := float64(tf)
+			norm := 1 - bm25B + bm25B*float64(ix.docs[id].length)/avgLength
+			scores[id] += idf * f * (bm25K1 + 1) / (f + bm25K1*norm)
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, Hit{ID: id, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID > hits[j].ID
+	})
+
+	if offset >= len(hits) {
+		return nil
+	}
+	return hits[offset:min(offset+limit, len(hits))]
+}
@@ -0,0 +1,134 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []Token
+	}{
+		{"Hello, World!", []Token{{"hello", 0, 5}, {"world", 7, 12}}},
+		{"Straße über_Äpfel 42", []Token{{"straße", 0, 6}, {"über", 7, 11}, {"äpfel", 12, 17}, {"42", 18, 20}}},
+		{"ΚΑΛΗΜΕΡΑ κόσμε", []Token{{"καλημερα", 0, 8}, {"κόσμε", 9, 14}}},
+		// A combining accent stays part of its word.
+		{"café ok", []Token{{"café", 0, 5}, {"ok", 6, 8}}},
+		{"東京タワー", []Token{{"東", 0, 1}, {"京", 1, 2}, {"タ", 2, 3}, {"ワ", 3, 4}, {"ー", 4, 5}}},
+		{"#go @alice", []Token{{"go", 1, 3}, {"alice", 5, 10}}},
+		{"  ...  ", nil},
+	}
+	for _, tt := range tests {
+		if got := Tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tokenize(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTerms(t *testing.T) {
+	got := Terms("Go go GOPHERS, go!")
+	if want := []string{"go", "gophers"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestIndexSearch(t *testing.T) {
+	ix := NewIndex()
+	ix.Put(1, "the quick brown fox")
+	ix.Put(2, "a fox, another fox and a fox again")
+	ix.Put(3, "lazy dogs sleep all day long in the sun")
+	ix.Put(4, "nothing to see here")
+
+	ids := func(hits []Hit) []int {
+		var ids []int
+		for _, h := range hits {
+			ids = append(ids, h.ID)
+		}
+		return ids
+	}
+
+	// More occurrences rank higher.
+	if got := ids(ix.Search([]string{"fox"}, 0, 10)); !reflect.DeepEqual(got, []int{2, 1}) {
+		t.Errorf("got %v, want [2 1]", got)
+	}
+	if got := ids(ix.Search([]string{"fox"}, 1, 1)); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("second page: got %v, want [1]", got)
+	}
+	// Any query term matches, and rare terms weigh more than common ones.
+	if got := ids(ix.Search([]string{"fox", "dogs"}, 0, 10)); !reflect.DeepEqual(got, []int{2, 3, 1}) {
+		t.Errorf("got %v, want [2 3 1]", got)
+	}
+	if got := ix.Search([]string{"fox"}, 5, 10); len(got) != 0 {
+		t.Errorf("past the end: got %v", got)
+	}
+	if got := ix.Search([]string{"cat"}, 0, 10); len(got) != 0 {
+		t.Errorf("no match: got %v", got)
+	}
+
+	// Replacing and deleting documents updates the postings.
+	ix.Put(2, "no longer about that animal")
+	ix.Delete(1)
+	if got := ix.Search([]string{"fox"}, 0, 10); len(got) != 0 {
+		t.Errorf("after update: got %v", got)
+	}
+	if got := ids(ix.Search([]string{"animal"}, 0, 10)); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("after update: got %v, want [2]", got)
+	}
+	if ix.Len() != 3 {
+		t.Errorf("got %d documents, want 3", ix.Len())
+	}
+}
+
+func TestMakeSnippet(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		terms     []string
+		maxLength int
+		want      Snippet
+	}{
+		{
+			name:      "short text",
+			text:      "Gophers love Go",
+			terms:     []string{"go"},
+			maxLength: 100,
+			want:      Snippet{Text: "Gophers love Go", Highlights: []Range{{13, 15}}},
+		},
+		{
+			name:      "window around match",
+			text:      "one two three four five six seven eight nine ten needle eleven twelve thirteen",
+			terms:     []string{"needle"},
+			maxLength: 20,
+			want:      Snippet{Text: "…ten needle eleven…", Highlights: []Range{{5, 11}}},
+		},
+		{
+			name:      "densest window",
+			text:      "cat at the start, then a long stretch of words, then cat and cat together",
+			terms:     []string{"cat"},
+			maxLength: 24,
+			want:      Snippet{Text: "…cat and cat together", Highlights: []Range{{1, 4}, {9, 12}}},
+		},
+		{
+			name:      "offsets count characters",
+			text:      "ünïcödé wörds ärë fün",
+			terms:     []string{"fün"},
+			maxLength: 100,
+			want:      Snippet{Text: "ünïcödé wörds ärë fün", Highlights: []Range{{18, 21}}},
+		},
+		{
+			name:      "no match",
+			text:      "alpha beta gamma delta",
+			terms:     []string{"omega"},
+			maxLength: 12,
+			want:      Snippet{Text: "alpha beta…", Highlights: []Range{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MakeSnippet(tt.text, tt.terms, tt.maxLength); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package search
+
+import "unicode"
+
+// ellipsis marks where a snippet was cut out of a longer text.
+const ellipsis = "…"
+
+// Range is a span of a text in characters (Unicode code points), End
+// exclusive.
+type Range struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Snippet is an excerpt of a text with the terms that matched a search
+// highlighted.
+type Snippet struct {
+	Text       string  `json:"text"`
+	Highlights []Range `json:"highlights"`
+}
+
+// MakeSnippet cuts an excerpt of at most maxLength characters, plus
+// ellipses, out of text. The excerpt is the window holding the most
+// occurrences of terms, starting a little before the first of them, and is
+// cut at word boundaries.
+func MakeSnippet(text string, terms []string, maxLength int) Snippet {
+	want := map[string]bool{}
+	for _, term := range terms {
+		want[term] = true
+	}
+	tokens := Tokenize(text)
+	var matches []Token
+	for _, t := range tokens {
+		if want[t.Term] {
+			matches = append(matches, t)
+		}
+	}
+
+	runes := []rune(text)
+	start, end := 0, len(runes)
+	if len(runes) > maxLength {
+		start, end = snippetWindow(tokens, matches, len(runes), maxLength)
+	}
+	for start < end && unicode.IsSpace(runes[start]) {
+		start++
+	}
+	for end > start && unicode.IsSpace(runes[end-1]) {
+		end--
+	}
+
+	s := Snippet{Highlights: []Range{}}
+	offset := -start
+	if start > 0 {
+		s.Text = ellipsis
+		offset++
+	}
+	s.Text += string(runes[start:end])
+	if end < len(runes) {
+		s.Text += ellipsis
+	}
+	for _, m := range matches {
+		if m.Start >= start && m.End <= end {
+			s.Highlights = append(s.Highlights, Range{Start: m.Start + offset, End: m.End + offset})
+		}
+	}
+	return s
+}
+
+// snippetWindow chooses the span of at most maxLength characters of a text
+// of textLength characters to show.
+func snippetWindow(tokens, matches []Token, textLength, maxLength int) (start, end int) {
+	// Find the match that starts the window covering the most matches.
+	if len(matches) > 0 {
+		best, bestCount := 0, 0
+		for i, m := range matches {
+			count := 0
+			for _, other := range matches[i:] {
+				if other.End-m.Start > maxLength {
+					break
+				}
+				count++
+			}
+			if count > bestCount {
+				best, bestCount = i, count
+			}
+		}
+		// Show some context before the first match.
+		start = max(0, matches[best].Start-maxLength/5)
+	}
+	end = min(textLength, start+maxLength)
+	start = max(0, end-maxLength)
+
+	// Do not cut words in half, unless a single word fills the window.
+	wordStart, wordEnd := start, end
+	for _, t := range tokens {
+		if t.Start < start && t.End > start {
+			wordStart = t.End
+		}
+		if t.Start < end && t.End > end {
+			wordEnd = t.Start
+		}
+	}
+	if wordStart >= wordEnd {
+		return start, end
+	}
+	return wordStart, wordEnd
+}
@@ -0,0 +1,72 @@
+// Package search provides the text processing shared by every search
+// backend (tokenization and snippets) and an in-process inverted index for
+// backends without a full-text engine of their own.
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Token is a term found in a text. Start and End count characters (Unicode
+// code points), End exclusive.
+type Token struct {
+	Term  string
+	Start int
+	End   int
+}
+
+// Tokenize splits text into lowercased terms. Words are runs of letters and
+// digits, with combining marks kept inside the word they follow. Scripts
+// written without spaces between words (Han, Hiragana, Katakana) yield one
+// term per character, so that any character of a CJK text can be searched.
+func Tokenize(text string) []Token {
+	var tokens []Token
+	var term strings.Builder
+	start, pos := -1, 0
+
+	flush := func() {
+		if start >= 0 {
+			tokens = append(tokens, Token{Term: term.String(), Start: start, End: pos})
+			term.Reset()
+			start = -1
+		}
+	}
+
+	for _, r := range text {
+		switch {
+		case isIdeographic(r):
+			flush()
+			tokens = append(tokens, Token{Term: string(unicode.ToLower(r)), Start: pos, End: pos + 1})
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			if start < 0 {
+				start = pos
+			}
+			term.WriteRune(unicode.ToLower(r))
+		case start >= 0 && unicode.In(r, unicode.Mn, unicode.Mc):
+			term.WriteRune(r)
+		default:
+			flush()
+		}
+		pos++
+	}
+	flush()
+	return tokens
+}
+
+// Terms returns the distinct terms of a query in the order they appear.
+func Terms(query string) []string {
+	var terms []string
+	seen := map[string]bool{}
+	for _, t := range Tokenize(query) {
+		if !seen[t.Term] {
+			seen[t.Term] = true
+			terms = append(terms, t.Term)
+		}
+	}
+	return terms
+}
+
+func isIdeographic(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana)
+}
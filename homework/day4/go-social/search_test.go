@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gosocial/search"
+	"gosocial/store"
+)
+
+func searchPath(params ...string) string {
+	v := url.Values{}
+	for i := 0; i+1 < len(params); i += 2 {
+		v.Set(params[i], params[i+1])
+	}
+	return "/search?" + v.Encode()
+}
+
+func TestSearch(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		alice, _ := c.signup("alice")
+		bob, bobID := c.signup("bob")
+		c.expect(http.StatusOK, http.MethodPut, "/profile", bob.Token, map[string]string{"userProfile": "I collect Gophers"}, nil)
+
+		c.createPost(alice.Token, "Gophers are great")
+		c.createPost(alice.Token, "I like cats")
+		c.createPost(alice.Token, "Go gophers, gophers everywhere!")
+		c.createPost(bob.Token, "Ein Café in ZÜRICH")
+
+		var resp searchResponse
+		c.expect(http.StatusOK, http.MethodGet, searchPath("q", "GOPHERS"), alice.Token, nil, &resp)
+		if resp.Posts == nil || len(resp.Posts.Results) != 2 || resp.Posts.NextCursor != "" {
+			t.Fatalf("got posts %+v, want 2 results", resp.Posts)
+		}
+		best := resp.Posts.Results[0]
+		if best.Content != "Go gophers, gophers everywhere!" || best.Score <= resp.Posts.Results[1].Score {
+			t.Errorf("posts are not ranked by relevance: %+v", resp.Posts.Results)
+		}
+		want := []search.Range{{Start: 3, End: 10}, {Start: 12, End: 19}}
+		if best.Snippet.Text != best.Content || !reflect.DeepEqual(best.Snippet.Highlights, want) {
+			t.Errorf("got snippet %+v, want the whole post highlighted at %v", best.Snippet, want)
+		}
+		if resp.Users == nil || len(resp.Users.Results) != 1 || resp.Users.Results[0].ID != bobID ||
+			len(resp.Users.Results[0].Snippet.Highlights) != 1 {
+			t.Errorf("got users %+v, want bob with a highlighted profile", resp.Users)
+		}
+
+		// Matching is case-insensitive beyond ASCII.
+		resp = searchResponse{}
+		c.expect(http.StatusOK, http.MethodGet, searchPath("q", "zürich", "type", "posts"), alice.Token, nil, &resp)
+		if resp.Users != nil || len(resp.Posts.Results) != 1 || resp.Posts.Results[0].Snippet.Highlights[0] != (search.Range{Start: 12, End: 18}) {
+			t.Errorf("got %+v, want one post highlighted at ZÜRICH", resp.Posts)
+		}
+
+		resp = searchResponse{}
+		c.expect(http.StatusOK, http.MethodGet, searchPath("q", "alice", "type", "users"), alice.Token, nil, &resp)
+		if resp.Posts != nil || len(resp.Users.Results) != 1 || len(resp.Users.Results[0].UsernameHighlights) != 1 {
+			t.Errorf("got %+v, want alice with her username highlighted", resp.Users)
+		}
+
+		resp = searchResponse{}
+		c.expect(http.StatusOK, http.MethodGet, searchPath("q", "?!"), alice.Token, nil, &resp)
+		if len(resp.Posts.Results) != 0 || len(resp.Users.Results) != 0 {
+			t.Errorf("a query without words matched %+v", resp)
+		}
+
+		c.expectError(http.StatusBadRequest, "invalid_query", http.MethodGet, searchPath("q", " "), alice.Token, nil)
+		c.expectError(http.StatusBadRequest, "invalid_type", http.MethodGet, searchPath("q", "go", "type", "tags"), alice.Token, nil)
+		c.expectError(http.StatusBadRequest, "invalid_cursor", http.MethodGet, searchPath("q", "go", "cursor", "b2Zmc2V0OjE"), alice.Token, nil)
+		c.expectError(http.StatusBadRequest, "invalid_cursor", http.MethodGet, searchPath("q", "go", "type", "posts", "cursor", "bogus"), alice.Token, nil)
+	})
+}
+
+func TestSearchPagination(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		alice, _ := c.signup("alice")
+		for _, content := range []string{"tea", "tea tea", "tea tea tea"} {
+			c.createPost(alice.Token, content)
+		}
+
+		var got []string
+		cursor := ""
+		for i := 0; i < 3; i++ {
+			var resp searchResponse
+			c.expect(http.StatusOK, http.MethodGet, searchPath("q", "tea", "type", "posts", "limit", "2", "cursor", cursor), alice.Token, nil, &resp)
+			for _, r := range resp.Posts.Results {
+				got = append(got, r.Content)
+			}
+			cursor = resp.Posts.NextCursor
+			if cursor == "" {
+				break
+			}
+		}
+		if len(got) != 3 || got[0] != "tea tea tea" || got[2] != "tea" || cursor != "" {
+			t.Errorf("got %q over the pages, want all three posts best first", got)
+		}
+	})
+}
+
+func TestSearchFollowsWrites(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, c *testClient) {
+		alice, _ := c.signup("alice")
+		c.createPost(alice.Token, "original words")
+
+		count := func(q string) int {
+			var resp searchResponse
+			c.expect(http.StatusOK, http.MethodGet, searchPath("q", q, "type", "posts"), alice.Token, nil, &resp)
+			return len(resp.Posts.Results)
+		}
+
+		c.expect(http.StatusOK, http.MethodPut, "/posts/1", alice.Token, map[string]string{"content": "edited text"}, nil)
+		if count("original") != 0 || count("edited") != 1 {
+			t.Error("search does not reflect the edited post")
+		}
+		c.expect(http.StatusOK, http.MethodDelete, "/posts/1", alice.Token, nil, nil)
+		if count("edited") != 0 {
+			t.Error("search still finds the deleted post")
+		}
+	})
+}
+
+func TestSearchIndexLoadedOnInit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	open := func() store.Storage {
+		s, err := store.NewSQLiteStorage(path, store.PoolConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	}
+
+	c := newTestClient(t, open())
+	alice, _ := c.signup("alice")
+	c.createPost(alice.Token, "persisted before restart")
+
+	c = newTestClient(t, open())
+	var resp searchResponse
+	c.expect(http.StatusOK, http.MethodGet, searchPath("q", "restart"), alice.Token, nil, &resp)
+	if len(resp.Posts.Results) != 1 || len(resp.Users.Results) != 0 {
+		t.Errorf("got %+v after restart, want the post", resp)
+	}
+}
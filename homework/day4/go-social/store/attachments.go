@@ -0,0 +1,166 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"gosocial/errs"
+	"gosocial/types"
+)
+
+// AttachmentStorage keeps the metadata of the images attached to posts. The
+// images themselves are in a blob store; deleting a post deletes its
+// attachments but not their blobs.
+type AttachmentStorage interface {
+	// CreateAttachments records attachments of the post postID and sets
+	// their IDs and creation times. It fails with too_many_attachments if
+	// the post would end up with more than max attachments.
+	CreateAttachments(ctx context.Context, postID int, attachments []*types.Attachment, max int) error
+	GetAttachmentByID(ctx context.Context, id int) (*types.Attachment, error)
+	// GetPostAttachments returns the attachments of each of the posts,
+	// oldest first.
+	GetPostAttachments(ctx context.Context, postIDs []int) (map[int][]*types.Attachment, error)
+	DeleteAttachment(ctx context.Context, id int) error
+}
+
+var errAttachmentNotFound = errs.NotFound("attachment_not_found", "attachment not found")
+
+func errTooManyAttachments(max int) error {
+	return errs.Validation("too_many_attachments", fmt.Sprintf("a post can have at most %d attachments", max))
+}
+
+const attachmentColumns = `
+	SELECT id, postID, blobKey, contentType, size, width, height, sha256,
+		thumbnailKey, thumbnailContentType, createdAt
+	FROM attachments`
+
+func scanAttachment(rows *sql.Rows) (*types.Attachment, error) {
+	a := new(types.Attachment)
+	err := rows.Scan(&a.ID, &a.PostID, &a.BlobKey, &a.ContentType, &a.Size, &a.Width, &a.Height, &a.SHA256,
+		&a.ThumbnailKey, &a.ThumbnailContentType, &a.CreatedAt)
+	return a, err
+}
+
+func (store *sqlStorage) CreateAttachments(ctx context.Context, postID int, attachments []*types.Attachment, max int) error {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the post so that concurrent uploads to it count one after the
+	// other.
+	var id int
+	err = tx.QueryRowContext(ctx, "SELECT id FROM posts WHERE id = ?"+store.dialect.forUpdate(), postID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errReferenceNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var count int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM attachments WHERE postID = ?", postID).Scan(&count); err != nil {
+		return err
+	}
+	if count+len(attachments) > max {
+		return errTooManyAttachments(max)
+	}
+
+	now := time.Now().UTC()
+	q := `
+	INSERT INTO attachments (postID, blobKey, contentType, size, width, height, sha256,
+		thumbnailKey, thumbnailContentType, createdAt)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	for _, a := range attachments {
+		res, err := tx.ExecContext(ctx, q, postID, a.BlobKey, a.ContentType, a.Size, a.Width, a.Height, a.SHA256,
+			a.ThumbnailKey, a.ThumbnailContentType, store.dialect.timeArg(now))
+		if store.dialect.isForeignKeyViolation(err) {
+			return errReferenceNotFound
+		}
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		a.ID = int(id)
+		a.PostID = postID
+		a.CreatedAt = now
+	}
+	return tx.Commit()
+}
+
+func (store *sqlStorage) GetAttachmentByID(ctx context.Context, id int) (*types.Attachment, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, attachmentColumns+" WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var a *types.Attachment
+	for rows.Next() {
+		if a, err = scanAttachment(rows); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if a == nil {
+		return nil, errAttachmentNotFound
+	}
+	return a, nil
+}
+
+func (store *sqlStorage) GetPostAttachments(ctx context.Context, postIDs []int) (map[int][]*types.Attachment, error) {
+	attachments := map[int][]*types.Attachment{}
+	if len(postIDs) == 0 {
+		return attachments, nil
+	}
+
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := attachmentColumns + " WHERE postID IN (" + placeholders(len(postIDs)) + ") ORDER BY id"
+	args := make([]any, len(postIDs))
+	for i, id := range postIDs {
+		args[i] = id
+	}
+	rows, err := store.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments[a.PostID] = append(attachments[a.PostID], a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+func (store *sqlStorage) DeleteAttachment(ctx context.Context, id int) error {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	_, err := store.db.ExecContext(ctx, "DELETE FROM attachments WHERE id = ?", id)
+	return err
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"gosocial/errs"
+	"gosocial/types"
+)
+
+// createTestPost creates a user and a post of theirs.
+func createTestPost(t *testing.T, s Storage, username string) *types.Post {
+	t.Helper()
+	ctx := context.Background()
+	u := &types.User{Username: username, Password: "x"}
+	if err := s.CreateUser(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	p := &types.Post{UserID: u.ID, Content: "post"}
+	if err := s.CreatePost(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestCreateAttachmentsConcurrently(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, s Storage) {
+		post := createTestPost(t, s, "alice")
+		const max, uploads = 3, 8
+
+		var wg sync.WaitGroup
+		results := make(chan error, uploads)
+		for i := 0; i < uploads; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				a := &types.Attachment{ContentType: "image/png", BlobKey: fmt.Sprintf("k%d", i), ThumbnailKey: fmt.Sprintf("k%d-thumbnail", i)}
+				results <- s.CreateAttachments(context.Background(), post.ID, []*types.Attachment{a}, max)
+			}()
+		}
+		wg.Wait()
+		close(results)
+
+		created := 0
+		for err := range results {
+			var e *errs.Error
+			switch {
+			case err == nil:
+				created++
+			case errors.As(err, &e) && e.Code == "too_many_attachments":
+			default:
+				t.Errorf("unexpected error %v", err)
+			}
+		}
+		attachments, err := s.GetPostAttachments(context.Background(), []int{post.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created != max || len(attachments[post.ID]) != max {
+			t.Errorf("created %d attachments, post has %d, want %d", created, len(attachments[post.ID]), max)
+		}
+	})
+}
+
+func TestCreateAttachmentsMissingPost(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, s Storage) {
+		a := &types.Attachment{ContentType: "image/png", BlobKey: "k", ThumbnailKey: "k-thumbnail"}
+		err := s.CreateAttachments(context.Background(), 42, []*types.Attachment{a}, 4)
+		var e *errs.Error
+		if !errors.As(err, &e) || e.Kind != errs.KindNotFound {
+			t.Errorf("got %v, want a not found error", err)
+		}
+	})
+}
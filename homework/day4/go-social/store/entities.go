@@ -0,0 +1,276 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+
+	"gosocial/entities"
+	"gosocial/types"
+)
+
+// EntityStorage looks up the hashtags and mentions recorded for posts and
+// comments. CreatePost, UpdatePost and CommentPost record the tags of the
+// entities they are given and the mentions that carry a user ID.
+type EntityStorage interface {
+	// GetUsersByUsernames returns the users among usernames that exist,
+	// comparing usernames case-insensitively.
+	GetUsersByUsernames(ctx context.Context, usernames []string) ([]types.UserSummary, error)
+	// GetPostMentions returns the users mentioned by each of the posts,
+	// not counting their comments.
+	GetPostMentions(ctx context.Context, postIDs []int) (map[int][]types.UserSummary, error)
+	// GetCommentMentions returns the users mentioned by each of the
+	// comments.
+	GetCommentMentions(ctx context.Context, commentIDs []int) (map[int][]types.UserSummary, error)
+	// GetPostsByTag returns up to limit posts tagged with tag, newest first,
+	// starting after the given cursor.
+	GetPostsByTag(ctx context.Context, tag string, cursor *types.Cursor, limit int) ([]*types.FeedPost, error)
+	// GetUserMentions returns up to limit posts and comments mentioning the
+	// user, most recent mention first, starting after the given cursor.
+	GetUserMentions(ctx context.Context, userID int, cursor *types.Cursor, limit int) ([]*types.Mention, error)
+}
+
+func (store *sqlStorage) GetUsersByUsernames(ctx context.Context, usernames []string) ([]types.UserSummary, error) {
+	users := []types.UserSummary{}
+	if len(usernames) == 0 {
+		return users, nil
+	}
+
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := "SELECT id, username, userProfile FROM users WHERE username" + store.dialect.caseInsensitive() +
+		" IN (" + placeholders(len(usernames)) + ")"
+	args := make([]any, len(usernames))
+	for i, username := range usernames {
+		args[i] = username
+	}
+	rows, err := store.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u types.UserSummary
+		var profile sql.NullString
+		if err := rows.Scan(&u.ID, &u.Username, &profile); err != nil {
+			return nil, err
+		}
+		u.UserProfile = profile.String
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (store *sqlStorage) GetPostMentions(ctx context.Context, postIDs []int) (map[int][]types.UserSummary, error) {
+	q := `
+	SELECT m.postID, u.id, u.username, u.userProfile
+	FROM mentions m JOIN users u ON u.id = m.userID
+	WHERE m.commentID IS NULL AND m.postID IN (%s)`
+	return store.queryMentionedUsers(ctx, q, postIDs)
+}
+
+func (store *sqlStorage) GetCommentMentions(ctx context.Context, commentIDs []int) (map[int][]types.UserSummary, error) {
+	q := `
+	SELECT m.commentID, u.id, u.username, u.userProfile
+	FROM mentions m JOIN users u ON u.id = m.userID
+	WHERE m.commentID IN (%s)`
+	return store.queryMentionedUsers(ctx, q, commentIDs)
+}
+
+// queryMentionedUsers runs a query selecting the ID of the mentioning post
+// or comment and the mentioned user. The query has a %s verb where the
+// placeholders of ids go.
+func (store *sqlStorage) queryMentionedUsers(ctx context.Context, q string, ids []int) (map[int][]types.UserSummary, error) {
+	mentioned := map[int][]types.UserSummary{}
+	if len(ids) == 0 {
+		return mentioned, nil
+	}
+
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := store.db.QueryContext(ctx, fmt.Sprintf(q, placeholders(len(ids))), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var u types.UserSummary
+		var profile sql.NullString
+		if err := rows.Scan(&id, &u.ID, &u.Username, &profile); err != nil {
+			return nil, err
+		}
+		u.UserProfile = profile.String
+		mentioned[id] = append(mentioned[id], u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return mentioned, nil
+}
+
+func (store *sqlStorage) GetPostsByTag(ctx context.Context, tag string, cursor *types.Cursor, limit int) ([]*types.FeedPost, error) {
+	q := feedPostColumns + `
+	JOIN post_hashtags ph ON ph.postID = p.id
+	JOIN hashtags h ON h.id = ph.hashtagID
+	WHERE h.tag = ?`
+	args := []any{tag}
+	q, args = store.appendCursorFilter(q, args, "p.createdAt", "p.id", cursor)
+	q += " ORDER BY p.createdAt DESC, p.id DESC LIMIT ?"
+	args = append(args, limit)
+
+	return store.queryFeedPosts(ctx, q, args...)
+}
+
+func (store *sqlStorage) GetUserMentions(ctx context.Context, userID int, cursor *types.Cursor, limit int) ([]*types.Mention, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := `
+	SELECT m.id, m.createdAt, p.id, p.userID, p.content, p.createdAt,
+		c.id, c.userID, c.content, c.timestamp
+	FROM mentions m
+	JOIN posts p ON p.id = m.postID
+	LEFT JOIN comments c ON c.id = m.commentID
+	WHERE m.userID = ?`
+	args := []any{userID}
+	q, args = store.appendCursorFilter(q, args, "m.createdAt", "m.id", cursor)
+	q += " ORDER BY m.createdAt DESC, m.id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := store.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mentions := []*types.Mention{}
+	for rows.Next() {
+		m := new(types.Mention)
+		var commentID, commentUserID sql.NullInt64
+		var commentContent sql.NullString
+		var commentTimestamp sql.NullTime
+		if err := rows.Scan(
+			&m.ID, &m.MentionedAt, &m.Post.ID, &m.Post.UserID, &m.Post.Content, &m.Post.CreatedAt,
+			&commentID, &commentUserID, &commentContent, &commentTimestamp,
+		); err != nil {
+			return nil, err
+		}
+		if commentID.Valid {
+			m.Comment = &types.PostComment{
+				ID:        int(commentID.Int64),
+				PostID:    m.Post.ID,
+				UserID:    int(commentUserID.Int64),
+				Content:   commentContent.String,
+				Timestamp: commentTimestamp.Time,
+			}
+		}
+		mentions = append(mentions, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return mentions, nil
+}
+
+// hashtagIDs returns the IDs of tags, creating the missing ones. It runs
+// outside the transaction of the write that needs them: under repeatable
+// read, a transaction would not see a tag another one created concurrently.
+func (store *sqlStorage) hashtagIDs(ctx context.Context, tags []string) (map[string]int, error) {
+	ids := map[string]int{}
+	for _, tag := range tags {
+		q := "SELECT id FROM hashtags WHERE tag = ?"
+		var id int
+		err := store.db.QueryRowContext(ctx, q, tag).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			var res sql.Result
+			res, err = store.db.ExecContext(ctx, "INSERT INTO hashtags (tag) VALUES (?)", tag)
+			if store.dialect.isDuplicateEntry(err) {
+				err = store.db.QueryRowContext(ctx, q, tag).Scan(&id)
+			} else if err == nil {
+				var lastID int64
+				lastID, err = res.LastInsertId()
+				id = int(lastID)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		ids[tag] = id
+	}
+	return ids, nil
+}
+
+// writeEntities records the hashtags and mentions of a post, or of one of
+// its comments if commentID is not zero, replacing what was recorded
+// before. Mentions that remain are kept, so that they keep their time.
+func (store *sqlStorage) writeEntities(ctx context.Context, tx *sql.Tx, tagIDs map[string]int, postID, commentID int, ents []types.Entity) error {
+	tagTable, key, id := "post_hashtags", "postID", postID
+	mentionFilter, mentionArgs := "postID = ? AND commentID IS NULL", []any{postID}
+	var commentArg any
+	if commentID != 0 {
+		tagTable, key, id = "comment_hashtags", "commentID", commentID
+		mentionFilter, mentionArgs = "commentID = ?", []any{commentID}
+		commentArg = commentID
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s = ?", tagTable, key), id); err != nil {
+		return err
+	}
+	for _, tag := range entities.Tags(ents) {
+		q := fmt.Sprintf("INSERT INTO %s (%s, hashtagID) VALUES (?, ?)", tagTable, key)
+		if _, err := tx.ExecContext(ctx, q, id, tagIDs[tag]); err != nil {
+			return err
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT userID FROM mentions WHERE "+mentionFilter, mentionArgs...)
+	if err != nil {
+		return err
+	}
+	var existing []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return err
+		}
+		existing = append(existing, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	mentioned := entities.MentionedUserIDs(ents)
+	for _, userID := range existing {
+		if !slices.Contains(mentioned, userID) {
+			q := "DELETE FROM mentions WHERE " + mentionFilter + " AND userID = ?"
+			if _, err := tx.ExecContext(ctx, q, append(mentionArgs, userID)...); err != nil {
+				return err
+			}
+		}
+	}
+	for _, userID := range mentioned {
+		if !slices.Contains(existing, userID) {
+			q := "INSERT INTO mentions (userID, postID, commentID) VALUES (?, ?, ?)"
+			if _, err := tx.ExecContext(ctx, q, userID, postID, commentArg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
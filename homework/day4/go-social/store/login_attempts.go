@@ -0,0 +1,107 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"gosocial/types"
+)
+
+// LoginAttemptStorage persists failed login counters and lockouts so that
+// they survive restarts and are shared between replicas.
+type LoginAttemptStorage interface {
+	// GetLoginAttempt returns the counter of key, which has no failures if
+	// none were recorded.
+	GetLoginAttempt(ctx context.Context, key string) (*types.LoginAttempt, error)
+	// RecordLoginFailure atomically counts a failure at now. Failures older
+	// than window are forgotten, so the count restarts at one.
+	RecordLoginFailure(ctx context.Context, key string, now time.Time, window time.Duration) (*types.LoginAttempt, error)
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	// ClearLoginAttempt forgets the failures of key and lifts its lockout.
+	ClearLoginAttempt(ctx context.Context, key string) error
+}
+
+func (store *sqlStorage) GetLoginAttempt(ctx context.Context, key string) (*types.LoginAttempt, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := "SELECT * FROM login_attempts WHERE attemptKey = ?"
+	rows, err := store.db.QueryContext(ctx, q, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	a := &types.LoginAttempt{Key: key}
+	for rows.Next() {
+		if err := scanRowToLoginAttempt(rows, a); err != nil {
+			return nil, err
+		}
+	}
+	return a, rows.Err()
+}
+
+func (store *sqlStorage) RecordLoginFailure(ctx context.Context, key string, now time.Time, window time.Duration) (*types.LoginAttempt, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	update := `
+	UPDATE login_attempts
+	SET failures = CASE WHEN lastFailureAt < ? THEN 1 ELSE failures + 1 END, lastFailureAt = ?
+	WHERE attemptKey = ?`
+	args := []any{store.dialect.timeArg(now.Add(-window)), store.dialect.timeArg(now), key}
+
+	res, err := store.db.ExecContext(ctx, update, args...)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		q := "INSERT INTO login_attempts (attemptKey, failures, lastFailureAt) VALUES (?, 1, ?)"
+		_, err := store.db.ExecContext(ctx, q, key, store.dialect.timeArg(now))
+		if store.dialect.isDuplicateEntry(err) {
+			// A concurrent failure created the row first.
+			_, err = store.db.ExecContext(ctx, update, args...)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return store.GetLoginAttempt(ctx, key)
+}
+
+func (store *sqlStorage) LockLogin(ctx context.Context, key string, until time.Time) error {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := "UPDATE login_attempts SET lockedUntil = ? WHERE attemptKey = ?"
+	_, err := store.db.ExecContext(ctx, q, store.dialect.timeArg(until), key)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (store *sqlStorage) ClearLoginAttempt(ctx context.Context, key string) error {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := "DELETE FROM login_attempts WHERE attemptKey = ?"
+	_, err := store.db.ExecContext(ctx, q, key)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func scanRowToLoginAttempt(rows *sql.Rows, a *types.LoginAttempt) error {
+	return rows.Scan(
+		&a.Key,
+		&a.Failures,
+		&a.LastFailureAt,
+		&a.LockedUntil,
+	)
+}
@@ -0,0 +1,1051 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gosocial/entities"
+	"gosocial/errs"
+	"gosocial/search"
+	"gosocial/types"
+)
+
+// MemoryStorage is a thread-safe in-memory Storage. It enforces the same
+// constraints as the MySQL schema (usernames unique ignoring case, unique
+// follows, references to existing rows, cascading post deletes) and returns
+// lists in the same order, so handlers behave identically on either backend.
+type MemoryStorage struct {
+	mu sync.RWMutex
+
+	users         map[int]*types.User
+	posts         map[int]*types.Post
+	likes         map[int]*types.PostLike
+	comments      map[int]*types.PostComment
+	follows       map[int]*types.Follow
+	sessions      map[string]*types.Session
+	refreshTokens map[int]*types.RefreshToken
+	loginAttempts map[string]*types.LoginAttempt
+
+	postIndex *search.Index
+	userIndex *search.Index
+
+	postTags    map[int][]string
+	commentTags map[int][]string
+	mentions    map[int]*mentionRow
+
+	notifications map[int]*notificationRow
+
+	attachments map[int]*types.Attachment
+
+	lastID map[string]int
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		users:         map[int]*types.User{},
+		posts:         map[int]*types.Post{},
+		likes:         map[int]*types.PostLike{},
+		comments:      map[int]*types.PostComment{},
+		follows:       map[int]*types.Follow{},
+		sessions:      map[string]*types.Session{},
+		refreshTokens: map[int]*types.RefreshToken{},
+		loginAttempts: map[string]*types.LoginAttempt{},
+		postIndex:     search.NewIndex(),
+		userIndex:     search.NewIndex(),
+		postTags:      map[int][]string{},
+		commentTags:   map[int][]string{},
+		mentions:      map[int]*mentionRow{},
+		notifications: map[int]*notificationRow{},
+		attachments:   map[int]*types.Attachment{},
+		lastID:        map[string]int{},
+	}
+}
+
+func (store *MemoryStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (store *MemoryStorage) Init(ctx context.Context) error {
+	return nil
+}
+
+func (store *MemoryStorage) CheckSchema(ctx context.Context) error {
+	return nil
+}
+
+func (store *MemoryStorage) Close() error {
+	return nil
+}
+
+// nextID returns the next auto-increment ID of a table. Callers hold mu.
+func (store *MemoryStorage) nextID(table string) int {
+	store.lastID[table]++
+	return store.lastID[table]
+}
+
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+func (store *MemoryStorage) GetUserByID(ctx context.Context, id int) (*types.User, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	u, ok := store.users[id]
+	if !ok {
+		return nil, errUserNotFound
+	}
+	cp := *u
+	return &cp, nil
+}
+
+func (store *MemoryStorage) GetUserByUsername(ctx context.Context, username string) (*types.User, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	for _, u := range store.users {
+		if strings.EqualFold(u.Username, username) {
+			cp := *u
+			return &cp, nil
+		}
+	}
+	return nil, errUserNotFound
+}
+
+func (store *MemoryStorage) CreateUser(ctx context.Context, u *types.User) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for _, existing := range store.users {
+		if strings.EqualFold(existing.Username, u.Username) {
+			return errs.Conflict("username_taken", "username "+u.Username+" already exists")
+		}
+	}
+
+	cp := &types.User{
+		ID:          store.nextID("users"),
+		Username:    u.Username,
+		Password:    u.Password,
+		UserProfile: u.UserProfile,
+		CreatedAt:   now(),
+	}
+	store.users[cp.ID] = cp
+	store.userIndex.Put(cp.ID, cp.Username, cp.UserProfile)
+	u.ID = cp.ID
+	return nil
+}
+
+// UpdateUser updates the password and profile of the user, leaving empty
+// fields unchanged.
+func (store *MemoryStorage) UpdateUser(ctx context.Context, u *types.User) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	existing, ok := store.users[u.ID]
+	if !ok {
+		return nil
+	}
+	if u.Password != "" {
+		existing.Password = u.Password
+	}
+	if u.UserProfile != "" {
+		existing.UserProfile = u.UserProfile
+		store.userIndex.Put(existing.ID, existing.Username, existing.UserProfile)
+	}
+	return nil
+}
+
+func (store *MemoryStorage) CreatePost(ctx context.Context, p *types.Post) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.users[p.UserID]; !ok {
+		return errUserNotFound
+	}
+
+	cp := &types.Post{ID: store.nextID("posts"), UserID: p.UserID, Content: p.Content, CreatedAt: now()}
+	store.posts[cp.ID] = cp
+	store.postIndex.Put(cp.ID, cp.Content)
+	store.writeEntities(cp.ID, 0, p.Entities)
+	p.ID = cp.ID
+	return nil
+}
+
+func (store *MemoryStorage) GetPostByID(ctx context.Context, id int) (*types.Post, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	p, ok := store.posts[id]
+	if !ok {
+		return nil, errs.NotFound("post_not_found", "post not found")
+	}
+	cp := *p
+	return &cp, nil
+}
+
+func (store *MemoryStorage) UpdatePost(ctx context.Context, p *types.Post) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if existing, ok := store.posts[p.ID]; ok {
+		existing.Content = p.Content
+		store.postIndex.Put(existing.ID, existing.Content)
+		store.writeEntities(existing.ID, 0, p.Entities)
+	}
+	return nil
+}
+
+// DeletePost removes a post together with its likes, comments, hashtags,
+// mentions, notifications and attachments.
+func (store *MemoryStorage) DeletePost(ctx context.Context, id int) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for likeID, l := range store.likes {
+		if l.PostID == id {
+			delete(store.likes, likeID)
+		}
+	}
+	for commentID, c := range store.comments {
+		if c.PostID == id {
+			delete(store.comments, commentID)
+			delete(store.commentTags, commentID)
+		}
+	}
+	for mentionID, m := range store.mentions {
+		if m.PostID == id {
+			delete(store.mentions, mentionID)
+		}
+	}
+	for notificationID, n := range store.notifications {
+		if n.PostID == id {
+			delete(store.notifications, notificationID)
+		}
+	}
+	for attachmentID, a := range store.attachments {
+		if a.PostID == id {
+			delete(store.attachments, attachmentID)
+		}
+	}
+	delete(store.posts, id)
+	delete(store.postTags, id)
+	store.postIndex.Delete(id)
+	return nil
+}
+
+func (store *MemoryStorage) GetFeed(ctx context.Context, userID int, cursor *types.Cursor, limit int) ([]*types.FeedPost, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	authors := map[int]bool{userID: true}
+	for _, f := range store.follows {
+		if f.FollowerID == userID {
+			authors[f.FolloweeID] = true
+		}
+	}
+	return store.feedPosts(func(p *types.Post) bool { return authors[p.UserID] }, cursor, limit), nil
+}
+
+func (store *MemoryStorage) GetPostsByUserID(ctx context.Context, userID int, cursor *types.Cursor, limit int) ([]*types.FeedPost, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	return store.feedPosts(func(p *types.Post) bool { return p.UserID == userID }, cursor, limit), nil
+}
+
+// feedPosts returns up to limit matching posts with their counters, newest
+// first, starting after the cursor. Callers hold mu.
+func (store *MemoryStorage) feedPosts(match func(*types.Post) bool, cursor *types.Cursor, limit int) []*types.FeedPost {
+	posts := []*types.FeedPost{}
+	for _, p := range store.posts {
+		if !match(p) || !beforeCursor(p.CreatedAt, p.ID, cursor) {
+			continue
+		}
+		posts = append(posts, store.feedPost(p))
+	}
+	return newestFirst(posts, func(fp *types.FeedPost) (time.Time, int) { return fp.CreatedAt, fp.ID }, limit)
+}
+
+// feedPost counts the likes and comments of a post. Callers hold mu.
+func (store *MemoryStorage) feedPost(p *types.Post) *types.FeedPost {
+	fp := &types.FeedPost{Post: *p}
+	for _, l := range store.likes {
+		if l.PostID == p.ID {
+			fp.LikeCount++
+		}
+	}
+	for _, c := range store.comments {
+		if c.PostID == p.ID {
+			fp.CommentCount++
+		}
+	}
+	return fp
+}
+
+func (store *MemoryStorage) GetPostLikeByUserID(ctx context.Context, postID, userID int) (*types.PostLike, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	for _, l := range store.likes {
+		if l.PostID == postID && l.UserID == userID {
+			cp := *l
+			return &cp, nil
+		}
+	}
+	return new(types.PostLike), nil
+}
+
+func (store *MemoryStorage) GetPostLikeCount(ctx context.Context, postID int) (int, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	count := 0
+	for _, l := range store.likes {
+		if l.PostID == postID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (store *MemoryStorage) GetPostLikers(ctx context.Context, postID int, cursor *types.Cursor, limit int) ([]*types.PostLiker, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	likers := []*types.PostLiker{}
+	for _, l := range store.likes {
+		if l.PostID != postID || !beforeCursor(l.Timestamp, l.ID, cursor) {
+			continue
+		}
+		u := store.users[l.UserID]
+		likers = append(likers, &types.PostLiker{
+			UserSummary: summarize(u),
+			LikeID:      l.ID,
+			LikedAt:     l.Timestamp,
+		})
+	}
+	return newestFirst(likers, func(l *types.PostLiker) (time.Time, int) { return l.LikedAt, l.LikeID }, limit), nil
+}
+
+func (store *MemoryStorage) LikePost(ctx context.Context, postID, userID int) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if !store.exists(postID, userID) {
+		return errReferenceNotFound
+	}
+
+	l := &types.PostLike{ID: store.nextID("likes"), PostID: postID, UserID: userID, Timestamp: now()}
+	store.likes[l.ID] = l
+	return nil
+}
+
+func (store *MemoryStorage) UnlikePost(ctx context.Context, postID, userID int) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for id, l := range store.likes {
+		if l.PostID == postID && l.UserID == userID {
+			delete(store.likes, id)
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStorage) CommentPost(ctx context.Context, pc *types.PostComment) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if !store.exists(pc.PostID, pc.UserID) {
+		return errReferenceNotFound
+	}
+
+	cp := &types.PostComment{
+		ID:        store.nextID("comments"),
+		PostID:    pc.PostID,
+		UserID:    pc.UserID,
+		Content:   pc.Content,
+		Timestamp: now(),
+	}
+	store.comments[cp.ID] = cp
+	store.writeEntities(cp.PostID, cp.ID, pc.Entities)
+	pc.ID = cp.ID
+	return nil
+}
+
+func (store *MemoryStorage) GetCommentByID(ctx context.Context, id int) (*types.PostComment, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	c, ok := store.comments[id]
+	if !ok {
+		return nil, errs.NotFound("comment_not_found", "comment not found")
+	}
+	cp := *c
+	return &cp, nil
+}
+
+func (store *MemoryStorage) GetPostComments(ctx context.Context, postID int, cursor *types.Cursor, limit int) ([]*types.PostCommentEntry, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	comments := []*types.PostCommentEntry{}
+	for _, c := range store.comments {
+		if c.PostID != postID || !beforeCursor(c.Timestamp, c.ID, cursor) {
+			continue
+		}
+		comments = append(comments, &types.PostCommentEntry{
+			PostComment: *c,
+			Username:    store.users[c.UserID].Username,
+		})
+	}
+	return newestFirst(comments, func(c *types.PostCommentEntry) (time.Time, int) { return c.Timestamp, c.ID }, limit), nil
+}
+
+func (store *MemoryStorage) DeleteComment(ctx context.Context, id int) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.comments, id)
+	delete(store.commentTags, id)
+	for mentionID, m := range store.mentions {
+		if m.CommentID == id {
+			delete(store.mentions, mentionID)
+		}
+	}
+	for notificationID, n := range store.notifications {
+		if n.CommentID == id {
+			delete(store.notifications, notificationID)
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStorage) GetFollow(ctx context.Context, followerID, followeeID int) (*types.Follow, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	for _, f := range store.follows {
+		if f.FollowerID == followerID && f.FolloweeID == followeeID {
+			cp := *f
+			return &cp, nil
+		}
+	}
+	return new(types.Follow), nil
+}
+
+func (store *MemoryStorage) FollowUser(ctx context.Context, followerID, followeeID int) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	_, followerOK := store.users[followerID]
+	_, followeeOK := store.users[followeeID]
+	if !followerOK || !followeeOK {
+		return errUserNotFound
+	}
+	for _, f := range store.follows {
+		if f.FollowerID == followerID && f.FolloweeID == followeeID {
+			return errs.Conflict("already_following", "already following user")
+		}
+	}
+
+	f := &types.Follow{ID: store.nextID("follows"), FollowerID: followerID, FolloweeID: followeeID, CreatedAt: now()}
+	store.follows[f.ID] = f
+	return nil
+}
+
+func (store *MemoryStorage) UnfollowUser(ctx context.Context, followerID, followeeID int) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for id, f := range store.follows {
+		if f.FollowerID == followerID && f.FolloweeID == followeeID {
+			delete(store.follows, id)
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStorage) GetFollowCounts(ctx context.Context, userID int) (followers int, following int, err error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	for _, f := range store.follows {
+		if f.FolloweeID == userID {
+			followers++
+		}
+		if f.FollowerID == userID {
+			following++
+		}
+	}
+	return followers, following, nil
+}
+
+func (store *MemoryStorage) GetFollowers(ctx context.Context, userID int, cursor *types.Cursor, limit int) ([]*types.FollowListEntry, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	return store.followList(
+		func(f *types.Follow) bool { return f.FolloweeID == userID },
+		func(f *types.Follow) int { return f.FollowerID },
+		cursor, limit,
+	), nil
+}
+
+func (store *MemoryStorage) GetFollowing(ctx context.Context, userID int, cursor *types.Cursor, limit int) ([]*types.FollowListEntry, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	return store.followList(
+		func(f *types.Follow) bool { return f.FollowerID == userID },
+		func(f *types.Follow) int { return f.FolloweeID },
+		cursor, limit,
+	), nil
+}
+
+// followList lists the users on the other side of the matching follows.
+// Callers hold mu.
+func (store *MemoryStorage) followList(match func(*types.Follow) bool, other func(*types.Follow) int, cursor *types.Cursor, limit int) []*types.FollowListEntry {
+	entries := []*types.FollowListEntry{}
+	for _, f := range store.follows {
+		if !match(f) || !beforeCursor(f.CreatedAt, f.ID, cursor) {
+			continue
+		}
+		entries = append(entries, &types.FollowListEntry{
+			UserSummary: summarize(store.users[other(f)]),
+			FollowID:    f.ID,
+			FollowedAt:  f.CreatedAt,
+		})
+	}
+	return newestFirst(entries, func(e *types.FollowListEntry) (time.Time, int) { return e.FollowedAt, e.FollowID }, limit)
+}
+
+func (store *MemoryStorage) CreateSession(ctx context.Context, userID int) (*types.Session, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.users[userID]; !ok {
+		return nil, errUserNotFound
+	}
+	sess := &types.Session{ID: id, UserID: userID, CreatedAt: now()}
+	store.sessions[id] = sess
+	cp := *sess
+	return &cp, nil
+}
+
+func (store *MemoryStorage) GetSessionByID(ctx context.Context, id string) (*types.Session, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	sess, ok := store.sessions[id]
+	if !ok {
+		return nil, errs.NotFound("session_not_found", "session not found")
+	}
+	cp := *sess
+	return &cp, nil
+}
+
+func (store *MemoryStorage) RevokeSession(ctx context.Context, id string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if sess, ok := store.sessions[id]; ok && sess.RevokedAt == nil {
+		t := now()
+		sess.RevokedAt = &t
+	}
+	return nil
+}
+
+func (store *MemoryStorage) RevokeUserSessions(ctx context.Context, userID int) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	t := now()
+	for _, sess := range store.sessions {
+		if sess.UserID == userID && sess.RevokedAt == nil {
+			sess.RevokedAt = &t
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStorage) CreateRefreshToken(ctx context.Context, rt *types.RefreshToken) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.sessions[rt.SessionID]; !ok {
+		return errs.NotFound("session_not_found", "session not found")
+	}
+	for _, existing := range store.refreshTokens {
+		if existing.TokenHash == rt.TokenHash {
+			return errs.Conflict("duplicate_refresh_token", "refresh token already exists")
+		}
+	}
+
+	cp := *rt
+	cp.ID = store.nextID("refresh_tokens")
+	cp.CreatedAt = now()
+	store.refreshTokens[cp.ID] = &cp
+	rt.ID = cp.ID
+	return nil
+}
+
+func (store *MemoryStorage) GetRefreshTokenByHash(ctx context.Context, hash string) (*types.RefreshToken, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	for _, rt := range store.refreshTokens {
+		if rt.TokenHash == hash {
+			cp := *rt
+			return &cp, nil
+		}
+	}
+	return nil, errs.NotFound("refresh_token_not_found", "refresh token not found")
+}
+
+func (store *MemoryStorage) MarkRefreshTokenUsed(ctx context.Context, id int) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	rt, ok := store.refreshTokens[id]
+	if !ok || rt.UsedAt != nil {
+		return false, nil
+	}
+	t := now()
+	rt.UsedAt = &t
+	return true, nil
+}
+
+func (store *MemoryStorage) GetLoginAttempt(ctx context.Context, key string) (*types.LoginAttempt, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	if a, ok := store.loginAttempts[key]; ok {
+		cp := *a
+		return &cp, nil
+	}
+	return &types.LoginAttempt{Key: key}, nil
+}
+
+func (store *MemoryStorage) RecordLoginFailure(ctx context.Context, key string, now time.Time, window time.Duration) (*types.LoginAttempt, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	a, ok := store.loginAttempts[key]
+	if !ok {
+		a = &types.LoginAttempt{Key: key}
+		store.loginAttempts[key] = a
+	}
+	if a.LastFailureAt.Before(now.Add(-window)) {
+		a.Failures = 0
+	}
+	a.Failures++
+	a.LastFailureAt = now
+
+	cp := *a
+	return &cp, nil
+}
+
+func (store *MemoryStorage) LockLogin(ctx context.Context, key string, until time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if a, ok := store.loginAttempts[key]; ok {
+		a.LockedUntil = &until
+	}
+	return nil
+}
+
+func (store *MemoryStorage) ClearLoginAttempt(ctx context.Context, key string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.loginAttempts, key)
+	return nil
+}
+
+func (store *MemoryStorage) SearchPosts(ctx context.Context, terms []string, offset, limit int) ([]*types.PostSearchHit, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	hits := []*types.PostSearchHit{}
+	for _, h := range store.postIndex.Search(terms, offset, limit) {
+		if p, ok := store.posts[h.ID]; ok {
+			hits = append(hits, &types.PostSearchHit{FeedPost: *store.feedPost(p), Score: h.Score})
+		}
+	}
+	return hits, nil
+}
+
+func (store *MemoryStorage) SearchUsers(ctx context.Context, terms []string, offset, limit int) ([]*types.UserSearchHit, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	hits := []*types.UserSearchHit{}
+	for _, h := range store.userIndex.Search(terms, offset, limit) {
+		if u, ok := store.users[h.ID]; ok {
+			hits = append(hits, &types.UserSearchHit{UserSummary: summarize(u), Score: h.Score})
+		}
+	}
+	return hits, nil
+}
+
+// mentionRow is a row of the mentions table. CommentID is zero for mentions
+// in the post itself.
+type mentionRow struct {
+	ID        int
+	UserID    int
+	PostID    int
+	CommentID int
+	CreatedAt time.Time
+}
+
+// writeEntities records the hashtags and mentions of a post, or of one of
+// its comments if commentID is not zero, as sqlStorage.writeEntities does.
+// Callers hold mu.
+func (store *MemoryStorage) writeEntities(postID, commentID int, ents []types.Entity) {
+	if commentID == 0 {
+		store.postTags[postID] = entities.Tags(ents)
+	} else {
+		store.commentTags[commentID] = entities.Tags(ents)
+	}
+
+	mentioned := entities.MentionedUserIDs(ents)
+	var existing []int
+	for id, m := range store.mentions {
+		if m.PostID != postID || m.CommentID != commentID {
+			continue
+		}
+		if slices.Contains(mentioned, m.UserID) {
+			existing = append(existing, m.UserID)
+		} else {
+			delete(store.mentions, id)
+		}
+	}
+	for _, userID := range mentioned {
+		if _, ok := store.users[userID]; ok && !slices.Contains(existing, userID) {
+			m := &mentionRow{ID: store.nextID("mentions"), UserID: userID, PostID: postID, CommentID: commentID, CreatedAt: now()}
+			store.mentions[m.ID] = m
+		}
+	}
+}
+
+func (store *MemoryStorage) GetUsersByUsernames(ctx context.Context, usernames []string) ([]types.UserSummary, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	users := []types.UserSummary{}
+	for _, u := range store.users {
+		if slices.ContainsFunc(usernames, func(username string) bool { return strings.EqualFold(username, u.Username) }) {
+			users = append(users, summarize(u))
+		}
+	}
+	return users, nil
+}
+
+func (store *MemoryStorage) GetPostMentions(ctx context.Context, postIDs []int) (map[int][]types.UserSummary, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	mentioned := map[int][]types.UserSummary{}
+	for _, m := range store.mentions {
+		if m.CommentID == 0 && slices.Contains(postIDs, m.PostID) {
+			mentioned[m.PostID] = append(mentioned[m.PostID], summarize(store.users[m.UserID]))
+		}
+	}
+	return mentioned, nil
+}
+
+func (store *MemoryStorage) GetCommentMentions(ctx context.Context, commentIDs []int) (map[int][]types.UserSummary, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	mentioned := map[int][]types.UserSummary{}
+	for _, m := range store.mentions {
+		if m.CommentID != 0 && slices.Contains(commentIDs, m.CommentID) {
+			mentioned[m.CommentID] = append(mentioned[m.CommentID], summarize(store.users[m.UserID]))
+		}
+	}
+	return mentioned, nil
+}
+
+func (store *MemoryStorage) GetPostsByTag(ctx context.Context, tag string, cursor *types.Cursor, limit int) ([]*types.FeedPost, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	return store.feedPosts(func(p *types.Post) bool { return slices.Contains(store.postTags[p.ID], tag) }, cursor, limit), nil
+}
+
+func (store *MemoryStorage) GetUserMentions(ctx context.Context, userID int, cursor *types.Cursor, limit int) ([]*types.Mention, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	mentions := []*types.Mention{}
+	for _, m := range store.mentions {
+		if m.UserID != userID || !beforeCursor(m.CreatedAt, m.ID, cursor) {
+			continue
+		}
+		mention := &types.Mention{ID: m.ID, Post: *store.posts[m.PostID], MentionedAt: m.CreatedAt}
+		if m.CommentID != 0 {
+			c := *store.comments[m.CommentID]
+			mention.Comment = &c
+		}
+		mentions = append(mentions, mention)
+	}
+	return newestFirst(mentions, func(m *types.Mention) (time.Time, int) { return m.MentionedAt, m.ID }, limit), nil
+}
+
+// notificationRow is a notification with all of its actors, newest last.
+type notificationRow struct {
+	ID        int
+	UserID    int
+	Type      string
+	PostID    int
+	CommentID int
+	Actors    []notificationActor
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	ReadAt    *time.Time
+}
+
+type notificationActor struct {
+	UserID    int
+	CreatedAt time.Time
+}
+
+func (store *MemoryStorage) NotifyLike(ctx context.Context, userID, postID, actorID int) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.users[userID]; !ok || !store.exists(postID, actorID) {
+		return errReferenceNotFound
+	}
+
+	t := now()
+	for _, n := range store.notifications {
+		if n.UserID == userID && n.PostID == postID && n.Type == types.NotificationLike && n.ReadAt == nil {
+			n.Actors = append(n.Actors, notificationActor{UserID: actorID, CreatedAt: t})
+			n.UpdatedAt = t
+			return nil
+		}
+	}
+	n := &notificationRow{
+		ID:        store.nextID("notifications"),
+		UserID:    userID,
+		Type:      types.NotificationLike,
+		PostID:    postID,
+		Actors:    []notificationActor{{UserID: actorID, CreatedAt: t}},
+		CreatedAt: t,
+		UpdatedAt: t,
+	}
+	store.notifications[n.ID] = n
+	return nil
+}
+
+func (store *MemoryStorage) WithdrawLike(ctx context.Context, postID, actorID int) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for id, n := range store.notifications {
+		if n.PostID != postID || n.Type != types.NotificationLike || n.ReadAt != nil {
+			continue
+		}
+		n.Actors = slices.DeleteFunc(n.Actors, func(a notificationActor) bool { return a.UserID == actorID })
+		if len(n.Actors) == 0 {
+			delete(store.notifications, id)
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStorage) NotifyComment(ctx context.Context, userID int, pc *types.PostComment) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.users[userID]; !ok {
+		return errReferenceNotFound
+	}
+	if _, ok := store.comments[pc.ID]; !ok {
+		return errReferenceNotFound
+	}
+
+	t := now()
+	n := &notificationRow{
+		ID:        store.nextID("notifications"),
+		UserID:    userID,
+		Type:      types.NotificationComment,
+		PostID:    pc.PostID,
+		CommentID: pc.ID,
+		Actors:    []notificationActor{{UserID: pc.UserID, CreatedAt: t}},
+		CreatedAt: t,
+		UpdatedAt: t,
+	}
+	store.notifications[n.ID] = n
+	return nil
+}
+
+func (store *MemoryStorage) GetNotifications(ctx context.Context, userID int, cursor *types.Cursor, limit int) ([]*types.Notification, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	notifications := []*types.Notification{}
+	for _, n := range store.notifications {
+		if n.UserID != userID || !beforeCursor(n.CreatedAt, n.ID, cursor) {
+			continue
+		}
+		notification := &types.Notification{
+			ID:         n.ID,
+			Type:       n.Type,
+			PostID:     n.PostID,
+			CommentID:  n.CommentID,
+			Actors:     []types.UserSummary{},
+			ActorCount: len(n.Actors),
+			Read:       n.ReadAt != nil,
+			CreatedAt:  n.CreatedAt,
+			UpdatedAt:  n.UpdatedAt,
+		}
+		for i := len(n.Actors) - 1; i >= 0 && len(notification.Actors) < maxNotificationActors; i-- {
+			notification.Actors = append(notification.Actors, summarize(store.users[n.Actors[i].UserID]))
+		}
+		notifications = append(notifications, notification)
+	}
+	return newestFirst(notifications, func(n *types.Notification) (time.Time, int) { return n.CreatedAt, n.ID }, limit), nil
+}
+
+func (store *MemoryStorage) CountUnreadNotifications(ctx context.Context, userID int) (int, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	count := 0
+	for _, n := range store.notifications {
+		if n.UserID == userID && n.ReadAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (store *MemoryStorage) MarkNotificationsRead(ctx context.Context, userID int, ids []int) (int, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	t := now()
+	marked := 0
+	for _, n := range store.notifications {
+		if n.UserID == userID && n.ReadAt == nil && (len(ids) == 0 || slices.Contains(ids, n.ID)) {
+			n.ReadAt = &t
+			marked++
+		}
+	}
+	return marked, nil
+}
+
+// exists reports whether both the post and the user exist. Callers hold mu.
+func (store *MemoryStorage) exists(postID, userID int) bool {
+	_, postOK := store.posts[postID]
+	_, userOK := store.users[userID]
+	return postOK && userOK
+}
+
+func summarize(u *types.User) types.UserSummary {
+	return types.UserSummary{ID: u.ID, Username: u.Username, UserProfile: u.UserProfile}
+}
+
+// beforeCursor reports whether a row keyed by (ts, id) comes after the cursor
+// in newest-first order.
+func beforeCursor(ts time.Time, id int, cursor *types.Cursor) bool {
+	if cursor == nil {
+		return true
+	}
+	return ts.Before(cursor.CreatedAt) || (ts.Equal(cursor.CreatedAt) && id < cursor.ID)
+}
+
+// newestFirst sorts items by (timestamp, id) descending and keeps at most
+// limit of them.
+func newestFirst[T any](items []T, key func(T) (time.Time, int), limit int) []T {
+	slices.SortFunc(items, func(a, b T) int {
+		ta, ia := key(a)
+		tb, ib := key(b)
+		if c := tb.Compare(ta); c != 0 {
+			return c
+		}
+		return ib - ia
+	})
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return items
+}
+
+func (store *MemoryStorage) CreateAttachments(ctx context.Context, postID int, attachments []*types.Attachment, max int) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.posts[postID]; !ok {
+		return errReferenceNotFound
+	}
+	count := 0
+	for _, a := range store.attachments {
+		if a.PostID == postID {
+			count++
+		}
+	}
+	if count+len(attachments) > max {
+		return errTooManyAttachments(max)
+	}
+
+	t := now()
+	for _, a := range attachments {
+		a.ID = store.nextID("attachments")
+		a.PostID = postID
+		a.CreatedAt = t
+		cp := *a
+		store.attachments[a.ID] = &cp
+	}
+	return nil
+}
+
+func (store *MemoryStorage) GetAttachmentByID(ctx context.Context, id int) (*types.Attachment, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	a, ok := store.attachments[id]
+	if !ok {
+		return nil, errAttachmentNotFound
+	}
+	cp := *a
+	return &cp, nil
+}
+
+func (store *MemoryStorage) GetPostAttachments(ctx context.Context, postIDs []int) (map[int][]*types.Attachment, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	attachments := map[int][]*types.Attachment{}
+	for _, a := range store.attachments {
+		if slices.Contains(postIDs, a.PostID) {
+			cp := *a
+			attachments[a.PostID] = append(attachments[a.PostID], &cp)
+		}
+	}
+	for _, list := range attachments {
+		slices.SortFunc(list, func(a, b *types.Attachment) int { return a.ID - b.ID })
+	}
+	return attachments, nil
+}
+
+func (store *MemoryStorage) DeleteAttachment(ctx context.Context, id int) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	delete(store.attachments, id)
+	return nil
+}
@@ -0,0 +1,458 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+const (
+	migrationLockName             = "gosocial_schema_migrations"
+	migrationLockTimeoutInSeconds = 60
+)
+
+var errMigrationLocked = errors.New("timed out waiting for another process to finish migrating")
+
+// migrationFileName matches files such as 0001_init.up.sql.
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// A version is recorded dirty before its statements run and clean once
+// they all succeeded. MySQL commits DDL statements one by one, so a version
+// left dirty may be half applied and needs looking at before migrating on.
+const createMigrationsTableQuery = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER NOT NULL PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	appliedAt TIMESTAMP NOT NULL,
+	dirty BOOLEAN NOT NULL DEFAULT FALSE
+)`
+
+// addDirtyColumnQuery upgrades schema_migrations tables created before
+// versions could be dirty.
+const addDirtyColumnQuery = "ALTER TABLE schema_migrations ADD COLUMN dirty BOOLEAN NOT NULL DEFAULT FALSE"
+
+// Migration is one versioned schema change read from the embedded
+// migrations directory of a dialect.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+type MigrationStatus struct {
+	Migration
+	// AppliedAt is nil while the migration is pending.
+	AppliedAt *time.Time
+	// Dirty reports that applying or rolling back the migration failed
+	// partway. See Migrator.Force.
+	Dirty bool
+}
+
+// appliedMigration is a row of schema_migrations.
+type appliedMigration struct {
+	at    time.Time
+	dirty bool
+}
+
+// Migrator applies and rolls back the embedded migrations of a SQL storage
+// backend, recording applied versions in the schema_migrations table.
+type Migrator struct {
+	db         *sql.DB
+	dialect    dialect
+	migrations []Migration
+	// Log receives a line for every migration applied or rolled back and,
+	// in dry-run mode, the statements that would have been executed.
+	Log io.Writer
+	// DryRun prints pending changes instead of executing them.
+	DryRun bool
+}
+
+// Migrator returns a migrator for the schema of store.
+func (store *sqlStorage) Migrator() (*Migrator, error) {
+	migrations, err := loadMigrations(store.dialect.name())
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		db:         store.db,
+		dialect:    store.dialect,
+		migrations: migrations,
+		Log:        log.Writer(),
+	}, nil
+}
+
+// Init brings the schema up to date and prepares search.
+func (store *sqlStorage) Init(ctx context.Context) error {
+	m, err := store.Migrator()
+	if err != nil {
+		return err
+	}
+	if err := m.Up(ctx); err != nil {
+		return err
+	}
+	return store.searcher.load(ctx, store)
+}
+
+func loadMigrations(dir string) ([]Migration, error) {
+	dir = path.Join("migrations", dir)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("%s: unexpected file %s", dir, entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		body, err := fs.ReadFile(migrationFiles, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("%s: version %d is used by both %s and %s", dir, version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("%s: migration %s needs both an up and a down file", dir, m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// splitStatements splits a migration file into statements, dropping
+// comment lines. Statements end with a semicolon at the end of a line.
+func splitStatements(body string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			if stmt := strings.TrimSpace(current.String()); stmt != ";" {
+				statements = append(statements, stmt)
+			}
+			current.Reset()
+		}
+	}
+	if stmt := strings.TrimSpace(current.String()); stmt != "" {
+		statements = append(statements, stmt)
+	}
+	return statements
+}
+
+// Status lists every known migration with the time it was applied.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i].Migration = migration
+		if a, ok := applied[migration.Version]; ok {
+			statuses[i].AppliedAt = &a.at
+			statuses[i].Dirty = a.dirty
+		}
+	}
+	return statuses, nil
+}
+
+// Up applies every pending migration in version order.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.run(ctx, func(conn *sql.Conn, applied map[int]appliedMigration) error {
+		if err := checkDirty(applied); err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := m.apply(ctx, conn, migration, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Down rolls back the last steps applied migrations, newest first.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.run(ctx, func(conn *sql.Conn, applied map[int]appliedMigration) error {
+		if err := checkDirty(applied); err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if err := m.apply(ctx, conn, migration, false); err != nil {
+				return err
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+// Force resolves the dirty version left by a migration that failed partway,
+// once the schema has been repaired by hand: it records the version applied,
+// or pending if applied is false.
+func (m *Migrator) Force(ctx context.Context, version int, applied bool) error {
+	return m.run(ctx, func(conn *sql.Conn, migrations map[int]appliedMigration) error {
+		if !migrations[version].dirty {
+			return fmt.Errorf("migration %d is not dirty", version)
+		}
+		if m.DryRun {
+			return nil
+		}
+		var err error
+		state := "applied"
+		if applied {
+			_, err = conn.ExecContext(ctx, "UPDATE schema_migrations SET dirty = ? WHERE version = ?", false, version)
+		} else {
+			state = "pending"
+			_, err = conn.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", version)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(m.Log, "migration %04d: recorded %s\n", version, state)
+		return nil
+	})
+}
+
+// run calls fn with the applied versions while holding the migration lock.
+// Dry runs take no lock and never create the schema_migrations table.
+//
+// It refuses to work on a database that has tables but no record of the
+// migrations that made them, as it cannot tell which of them the schema
+// matches. Up and Down also refuse to go past a dirty version.
+func (m *Migrator) run(ctx context.Context, fn func(*sql.Conn, map[int]appliedMigration) error) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if !m.DryRun {
+		unlock, err := m.dialect.lockMigrations(ctx, conn)
+		if err != nil {
+			return fmt.Errorf("acquiring migration lock: %w", err)
+		}
+		defer func() {
+			if unlockErr := unlock(err != nil); err == nil {
+				err = unlockErr
+			}
+		}()
+	}
+
+	if err := m.checkUnversioned(ctx, conn); err != nil {
+		return err
+	}
+	if !m.DryRun {
+		if _, err := conn.ExecContext(ctx, createMigrationsTableQuery); err != nil {
+			return err
+		}
+		if ok, err := m.exists(ctx, conn, m.dialect.columnExistsQuery(), "schema_migrations", "dirty"); err != nil {
+			return err
+		} else if !ok {
+			if _, err := conn.ExecContext(ctx, addDirtyColumnQuery); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Read the applied versions only once the lock is held, so that a
+	// replica that waited for it sees the work of the one that held it.
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+	for version := range applied {
+		if !m.known(version) {
+			return fmt.Errorf("database has migration %d applied, which this binary does not know", version)
+		}
+	}
+	return fn(conn, applied)
+}
+
+// checkUnversioned returns an error if the database has tables of the
+// schema but no schema_migrations table. Such databases predate versioned
+// migrations and may lack constraints that the first one creates, such as
+// the cascading deletes, which its CREATE TABLE statements would not add.
+func (m *Migrator) checkUnversioned(ctx context.Context, conn *sql.Conn) error {
+	versioned, err := m.exists(ctx, conn, m.dialect.tableExistsQuery(), "schema_migrations")
+	if err != nil || versioned {
+		return err
+	}
+	for _, table := range schemaTables {
+		found, err := m.exists(ctx, conn, m.dialect.tableExistsQuery(), table)
+		if err != nil {
+			return err
+		}
+		if found {
+			return fmt.Errorf("database has a %s table but no schema_migrations table; "+
+				"it predates versioned migrations and has to be recreated or brought to the schema of %s by hand, "+
+				"with that version recorded in schema_migrations", table, m.migrations[0])
+		}
+	}
+	return nil
+}
+
+// exists reports whether query, one of the dialect's existence checks,
+// selects a row.
+func (m *Migrator) exists(ctx context.Context, conn *sql.Conn, query string, args ...any) (bool, error) {
+	var found int
+	err := conn.QueryRowContext(ctx, query, args...).Scan(&found)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (m *Migrator) known(version int) bool {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int]appliedMigration, error) {
+	applied := map[int]appliedMigration{}
+
+	if ok, err := m.exists(ctx, conn, m.dialect.tableExistsQuery(), "schema_migrations"); !ok || err != nil {
+		return applied, err
+	}
+	// Dry runs leave tables from before the dirty column as they are.
+	q := "SELECT version, appliedAt, FALSE FROM schema_migrations"
+	if ok, err := m.exists(ctx, conn, m.dialect.columnExistsQuery(), "schema_migrations", "dirty"); err != nil {
+		return nil, err
+	} else if ok {
+		q = "SELECT version, appliedAt, dirty FROM schema_migrations"
+	}
+
+	rows, err := conn.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int
+		var a appliedMigration
+		if err := rows.Scan(&version, &a.at, &a.dirty); err != nil {
+			return nil, err
+		}
+		applied[version] = a
+	}
+	return applied, rows.Err()
+}
+
+// checkDirty returns an error if a version is dirty.
+func checkDirty(applied map[int]appliedMigration) error {
+	for version, a := range applied {
+		if a.dirty {
+			return fmt.Errorf("migration %d failed partway and is dirty; repair the schema by hand, "+
+				"then record it applied or pending with migrate force", version)
+		}
+	}
+	return nil
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration, up bool) error {
+	direction, body := "up", migration.Up
+	if !up {
+		direction, body = "down", migration.Down
+	}
+
+	if m.DryRun {
+		fmt.Fprintf(m.Log, "-- %s (%s)\n", migration, direction)
+		for _, stmt := range splitStatements(body) {
+			fmt.Fprintf(m.Log, "%s\n\n", stmt)
+		}
+		return nil
+	}
+
+	// Mark the version dirty first, so that a failure partway is recorded
+	// where the statements cannot be rolled back.
+	var err error
+	if up {
+		_, err = conn.ExecContext(ctx,
+			"INSERT INTO schema_migrations (version, name, appliedAt, dirty) VALUES (?, ?, ?, ?)",
+			migration.Version, migration.Name, m.dialect.timeArg(time.Now()), true,
+		)
+	} else {
+		_, err = conn.ExecContext(ctx, "UPDATE schema_migrations SET dirty = ? WHERE version = ?", true, migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range splitStatements(body) {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migration %s (%s): %w", migration, direction, err)
+		}
+	}
+
+	if up {
+		_, err = conn.ExecContext(ctx, "UPDATE schema_migrations SET dirty = ? WHERE version = ?", false, migration.Version)
+	} else {
+		_, err = conn.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(m.Log, "migration %s: %s\n", migration, direction)
+	return nil
+}
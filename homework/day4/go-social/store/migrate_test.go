@@ -0,0 +1,269 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testMigrator(t *testing.T, s *SQLiteStorage) *Migrator {
+	t.Helper()
+	m, err := s.Migrator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Log = io.Discard
+	return m
+}
+
+// appliedVersions returns the versions recorded in schema_migrations.
+func appliedVersions(t *testing.T, m *Migrator) []int {
+	t.Helper()
+	statuses, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []int
+	for _, s := range statuses {
+		if s.AppliedAt != nil {
+			versions = append(versions, s.Version)
+		}
+	}
+	return versions
+}
+
+func tableExists(t *testing.T, s *SQLiteStorage, table string) bool {
+	t.Helper()
+	var exists int
+	err := s.db.QueryRow(s.dialect.tableExistsQuery(), table).Scan(&exists)
+	return err == nil
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	ctx := context.Background()
+	s := openTestSQLite(t, filepath.Join(t.TempDir(), "test.db"))
+	m := testMigrator(t, s)
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	all := appliedVersions(t, m)
+	if len(all) != len(m.migrations) {
+		t.Fatalf("applied %v, want all %d migrations", all, len(m.migrations))
+	}
+	if err := s.CheckSchema(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rolling back the attachments migration and those after it restores
+	// the version before it.
+	i := slices.IndexFunc(m.migrations, func(mig Migration) bool { return mig.Name == "attachments" })
+	steps, previous := len(m.migrations)-i, m.migrations[i-1].Version
+	if err := m.Down(ctx, steps); err != nil {
+		t.Fatal(err)
+	}
+	if got := appliedVersions(t, m); len(got) != len(all)-steps || got[len(got)-1] != previous {
+		t.Errorf("applied %v after rolling back %d, want up to version %d", got, steps, previous)
+	}
+	if tableExists(t, s, "attachments") || !tableExists(t, s, "notifications") {
+		t.Error("rolling back kept the attachments table or dropped an earlier one")
+	}
+
+	// Up applies only what is pending.
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := appliedVersions(t, m); len(got) != len(all) || !tableExists(t, s, "attachments") {
+		t.Errorf("applied %v after migrating up again", got)
+	}
+
+	if err := m.Down(ctx, len(m.migrations)); err != nil {
+		t.Fatal(err)
+	}
+	if got := appliedVersions(t, m); len(got) != 0 || tableExists(t, s, "users") {
+		t.Errorf("applied %v after rolling back everything", got)
+	}
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	ctx := context.Background()
+	s := openTestSQLite(t, filepath.Join(t.TempDir(), "test.db"))
+	m := testMigrator(t, s)
+	var log bytes.Buffer
+	m.Log, m.DryRun = &log, true
+
+	// On an empty database, nothing is created, not even the bookkeeping.
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if tableExists(t, s, "schema_migrations") || tableExists(t, s, "users") {
+		t.Error("a dry run created tables")
+	}
+	if !strings.Contains(log.String(), "-- 0001_init (up)") || !strings.Contains(log.String(), "CREATE TABLE") {
+		t.Errorf("dry run printed %q, want the statements of every migration", log.String())
+	}
+
+	// On a migrated database, rolling back changes nothing either.
+	m.DryRun = false
+	m.Log = io.Discard
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	before := appliedVersions(t, m)
+	log.Reset()
+	m.Log, m.DryRun = &log, true
+	if err := m.Down(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if after := appliedVersions(t, m); len(after) != len(before) {
+		t.Errorf("applied %v after a dry run of down, want %v", after, before)
+	}
+	if last := m.migrations[len(m.migrations)-1]; !strings.Contains(log.String(), "-- "+last.String()+" (down)") {
+		t.Errorf("dry run printed %q, want the down statements of %s", log.String(), last)
+	}
+}
+
+func TestMigrateLock(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+	holder := openTestSQLite(t, path)
+	conn, err := holder.db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	unlock, err := holder.dialect.lockMigrations(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := testMigrator(t, openTestSQLite(t, path))
+	done := make(chan error, 1)
+	go func() { done <- m.Up(ctx) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("migrated while the lock was held: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if err := unlock(false); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("still waiting after the lock was released")
+	}
+	if got := appliedVersions(t, m); len(got) != len(m.migrations) {
+		t.Errorf("applied %v once the lock was released", got)
+	}
+}
+
+func TestMigrateRefusesUnversioned(t *testing.T) {
+	ctx := context.Background()
+	s := openTestSQLite(t, filepath.Join(t.TempDir(), "test.db"))
+	if _, err := s.db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, username TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	m := testMigrator(t, s)
+	if err := m.Up(ctx); err == nil || !strings.Contains(err.Error(), "no schema_migrations table") {
+		t.Fatalf("got %v, want the database refused", err)
+	}
+	if tableExists(t, s, "schema_migrations") || tableExists(t, s, "posts") {
+		t.Error("migrated a database that predates versioned migrations")
+	}
+}
+
+func TestMigrateDirty(t *testing.T) {
+	ctx := context.Background()
+	s := openTestSQLite(t, filepath.Join(t.TempDir(), "test.db"))
+	m := testMigrator(t, s)
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Outside a transaction, as under MySQL, a failure partway leaves the
+	// version dirty with its first statements applied.
+	broken := Migration{Version: 1000, Name: "broken", Up: "CREATE TABLE half (id INTEGER);\nNOT SQL;", Down: "DROP TABLE half;"}
+	if err := m.apply(ctx, conn, broken, true); err == nil {
+		t.Fatal("applied a broken migration")
+	}
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !applied[broken.Version].dirty || !tableExists(t, s, "half") {
+		t.Fatalf("got %+v, want the broken version dirty", applied[broken.Version])
+	}
+
+	// Migrating refuses to go on until the dirty version is resolved.
+	m.migrations = append(m.migrations, broken)
+	if err := m.Up(ctx); err == nil || !strings.Contains(err.Error(), "dirty") {
+		t.Errorf("got %v from up, want the dirty version reported", err)
+	}
+	if err := m.Down(ctx, 1); err == nil || !strings.Contains(err.Error(), "dirty") {
+		t.Errorf("got %v from down, want the dirty version reported", err)
+	}
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := statuses[len(statuses)-1]; !last.Dirty || statuses[0].Dirty {
+		t.Errorf("status reports dirty as %v and %v, want only the broken version", statuses[0].Dirty, last.Dirty)
+	}
+
+	// Once the table is dropped by hand, the version is recorded pending and
+	// applied again by a fixed migration.
+	if err := m.Force(ctx, m.migrations[0].Version, true); err == nil {
+		t.Error("forced a clean version")
+	}
+	if _, err := s.db.Exec("DROP TABLE half"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Force(ctx, broken.Version, false); err != nil {
+		t.Fatal(err)
+	}
+	m.migrations[len(m.migrations)-1].Up = "CREATE TABLE half (id INTEGER);"
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := appliedVersions(t, m); len(got) != len(m.migrations) || !tableExists(t, s, "half") {
+		t.Errorf("applied %v after fixing the broken version", got)
+	}
+}
+
+func TestMigrateAddsDirtyColumn(t *testing.T) {
+	ctx := context.Background()
+	s := openTestSQLite(t, filepath.Join(t.TempDir(), "test.db"))
+	m := testMigrator(t, s)
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec("ALTER TABLE schema_migrations DROP COLUMN dirty"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading a table from before the column works, and migrating adds it.
+	if got := appliedVersions(t, m); len(got) != len(m.migrations) {
+		t.Fatalf("applied %v without the dirty column", got)
+	}
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var exists int
+	if err := s.db.QueryRow(s.dialect.columnExistsQuery(), "schema_migrations", "dirty").Scan(&exists); err != nil {
+		t.Errorf("migrating did not add the dirty column: %v", err)
+	}
+}
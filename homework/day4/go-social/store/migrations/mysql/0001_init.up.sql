@@ -0,0 +1,85 @@
+-- Databases set up before versioned migrations existed are not adopted, as
+-- their tables may lack the cascading deletes: the migrator refuses them.
+
+CREATE TABLE users (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	username VARCHAR(50) NOT NULL,
+	password VARCHAR(255) NOT NULL,
+	userProfile VARCHAR(255),
+	createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+	PRIMARY KEY (id),
+	UNIQUE KEY(username)
+);
+
+CREATE TABLE posts (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	userID INT UNSIGNED NOT NULL,
+	content TEXT,
+	createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+	PRIMARY KEY (id),
+	FOREIGN KEY (userID) REFERENCES users(id)
+);
+
+CREATE TABLE likes (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	postID INT UNSIGNED NOT NULL,
+	userID INT UNSIGNED NOT NULL,
+	timestamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+	PRIMARY KEY (id),
+	FOREIGN KEY (postID) REFERENCES posts(id) ON DELETE CASCADE,
+	FOREIGN KEY (userID) REFERENCES users(id)
+);
+
+CREATE TABLE comments (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	postID INT UNSIGNED NOT NULL,
+	userID INT UNSIGNED NOT NULL,
+	content TEXT,
+	timestamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+	PRIMARY KEY (id),
+	FOREIGN KEY (postID) REFERENCES posts(id) ON DELETE CASCADE,
+	FOREIGN KEY (userID) REFERENCES users(id)
+);
+
+CREATE TABLE follows (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	followerID INT UNSIGNED NOT NULL,
+	followeeID INT UNSIGNED NOT NULL,
+	createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+	PRIMARY KEY (id),
+	UNIQUE KEY (followerID, followeeID),
+	KEY (followeeID),
+	FOREIGN KEY (followerID) REFERENCES users(id),
+	FOREIGN KEY (followeeID) REFERENCES users(id)
+);
+
+CREATE TABLE sessions (
+	id CHAR(32) NOT NULL,
+	userID INT UNSIGNED NOT NULL,
+	createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	revokedAt TIMESTAMP NULL,
+
+	PRIMARY KEY (id),
+	KEY (userID),
+	FOREIGN KEY (userID) REFERENCES users(id)
+);
+
+CREATE TABLE refresh_tokens (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	sessionID CHAR(32) NOT NULL,
+	userID INT UNSIGNED NOT NULL,
+	tokenHash CHAR(64) NOT NULL,
+	expiresAt TIMESTAMP NOT NULL,
+	usedAt TIMESTAMP NULL,
+	createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+	PRIMARY KEY (id),
+	UNIQUE KEY (tokenHash),
+	FOREIGN KEY (sessionID) REFERENCES sessions(id) ON DELETE CASCADE,
+	FOREIGN KEY (userID) REFERENCES users(id)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP INDEX ft_users_search;
+ALTER TABLE posts DROP INDEX ft_posts_content;
@@ -0,0 +1,3 @@
+-- Full-text indexes backing GET /search.
+ALTER TABLE posts ADD FULLTEXT INDEX ft_posts_content (content);
+ALTER TABLE users ADD FULLTEXT INDEX ft_users_search (username, userProfile);
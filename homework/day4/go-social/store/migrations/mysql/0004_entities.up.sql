@@ -0,0 +1,45 @@
+-- Hashtags and mentions parsed from the content of posts and comments.
+CREATE TABLE hashtags (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	-- Binary collation so that tags differing only in accents stay distinct.
+	tag VARCHAR(100) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+
+	PRIMARY KEY (id),
+	UNIQUE KEY (tag)
+);
+
+CREATE TABLE post_hashtags (
+	postID INT UNSIGNED NOT NULL,
+	hashtagID INT UNSIGNED NOT NULL,
+
+	PRIMARY KEY (postID, hashtagID),
+	KEY (hashtagID),
+	FOREIGN KEY (postID) REFERENCES posts(id) ON DELETE CASCADE,
+	FOREIGN KEY (hashtagID) REFERENCES hashtags(id)
+);
+
+CREATE TABLE comment_hashtags (
+	commentID INT UNSIGNED NOT NULL,
+	hashtagID INT UNSIGNED NOT NULL,
+
+	PRIMARY KEY (commentID, hashtagID),
+	KEY (hashtagID),
+	FOREIGN KEY (commentID) REFERENCES comments(id) ON DELETE CASCADE,
+	FOREIGN KEY (hashtagID) REFERENCES hashtags(id)
+);
+
+-- A mention of userID in post postID, or in comment commentID on that post.
+CREATE TABLE mentions (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	userID INT UNSIGNED NOT NULL,
+	postID INT UNSIGNED NOT NULL,
+	commentID INT UNSIGNED NULL,
+	createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+	PRIMARY KEY (id),
+	KEY (userID, createdAt),
+	KEY (postID),
+	FOREIGN KEY (userID) REFERENCES users(id),
+	FOREIGN KEY (postID) REFERENCES posts(id) ON DELETE CASCADE,
+	FOREIGN KEY (commentID) REFERENCES comments(id) ON DELETE CASCADE
+);
@@ -0,0 +1,32 @@
+-- Notifications of likes and comments on the posts of userID. The likes of
+-- a post are grouped into one notification while it is unread; its actors
+-- are the users who liked.
+CREATE TABLE notifications (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	userID INT UNSIGNED NOT NULL,
+	type VARCHAR(20) NOT NULL,
+	postID INT UNSIGNED NOT NULL,
+	commentID INT UNSIGNED NULL,
+	createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	-- Moves forward when an actor joins the group.
+	updatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	readAt TIMESTAMP NULL,
+
+	PRIMARY KEY (id),
+	KEY (userID, updatedAt),
+	KEY (postID, type),
+	FOREIGN KEY (userID) REFERENCES users(id),
+	FOREIGN KEY (postID) REFERENCES posts(id) ON DELETE CASCADE,
+	FOREIGN KEY (commentID) REFERENCES comments(id) ON DELETE CASCADE
+);
+
+CREATE TABLE notification_actors (
+	notificationID INT UNSIGNED NOT NULL,
+	userID INT UNSIGNED NOT NULL,
+	createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+	PRIMARY KEY (notificationID, userID),
+	KEY (userID),
+	FOREIGN KEY (notificationID) REFERENCES notifications(id) ON DELETE CASCADE,
+	FOREIGN KEY (userID) REFERENCES users(id)
+);
@@ -0,0 +1,20 @@
+-- Images attached to posts. The files live in the blob store under
+-- blobKey and thumbnailKey; deleting a post leaves them to the caller.
+CREATE TABLE attachments (
+	id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+	postID INT UNSIGNED NOT NULL,
+	blobKey VARCHAR(255) NOT NULL,
+	contentType VARCHAR(50) NOT NULL,
+	size BIGINT UNSIGNED NOT NULL,
+	width INT UNSIGNED NOT NULL,
+	height INT UNSIGNED NOT NULL,
+	-- Hex SHA-256 of the stored file, served as its ETag.
+	sha256 CHAR(64) NOT NULL,
+	thumbnailKey VARCHAR(255) NOT NULL,
+	thumbnailContentType VARCHAR(50) NOT NULL,
+	createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+	PRIMARY KEY (id),
+	KEY (postID),
+	FOREIGN KEY (postID) REFERENCES posts(id) ON DELETE CASCADE
+);
@@ -0,0 +1,4 @@
+ALTER TABLE users DROP INDEX ft_users_search;
+ALTER TABLE users ADD FULLTEXT INDEX ft_users_search (username, userProfile);
+ALTER TABLE posts DROP INDEX ft_posts_content;
+ALTER TABLE posts ADD FULLTEXT INDEX ft_posts_content (content);
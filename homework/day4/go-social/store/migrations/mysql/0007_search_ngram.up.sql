@@ -0,0 +1,11 @@
+-- Re-create the full-text indexes with the n-gram parser, which indexes
+-- CJK text and words of any length like search.Tokenize does; the default
+-- parser splits on spaces only and drops words shorter than
+-- innodb_ft_min_token_size. Stopwords are left out, as the parser would
+-- otherwise drop every n-gram containing one, such as "a" or "i".
+SET SESSION innodb_ft_enable_stopword = OFF;
+ALTER TABLE posts DROP INDEX ft_posts_content;
+ALTER TABLE posts ADD FULLTEXT INDEX ft_posts_content (content) WITH PARSER ngram;
+ALTER TABLE users DROP INDEX ft_users_search;
+ALTER TABLE users ADD FULLTEXT INDEX ft_users_search (username, userProfile) WITH PARSER ngram;
+SET SESSION innodb_ft_enable_stopword = ON;
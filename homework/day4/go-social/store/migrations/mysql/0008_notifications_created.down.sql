@@ -0,0 +1 @@
+ALTER TABLE notifications DROP INDEX userID, ADD KEY userID (userID, updatedAt);
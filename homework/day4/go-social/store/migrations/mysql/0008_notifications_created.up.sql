@@ -0,0 +1,2 @@
+-- Notifications are listed by creation rather than by their latest actor.
+ALTER TABLE notifications DROP INDEX userID, ADD KEY userID (userID, createdAt);
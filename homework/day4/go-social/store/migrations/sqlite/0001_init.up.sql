@@ -0,0 +1,62 @@
+-- Timestamps default to UTC in the fixed-width layout of sqliteTimeLayout
+-- so that comparing the text compares the times.
+
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username VARCHAR(50) NOT NULL UNIQUE,
+	password VARCHAR(255) NOT NULL,
+	userProfile VARCHAR(255),
+	createdAt TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS posts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	userID INTEGER NOT NULL REFERENCES users(id),
+	content TEXT,
+	createdAt TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS likes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	postID INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+	userID INTEGER NOT NULL REFERENCES users(id),
+	timestamp TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS comments (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	postID INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+	userID INTEGER NOT NULL REFERENCES users(id),
+	content TEXT,
+	timestamp TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS follows (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	followerID INTEGER NOT NULL REFERENCES users(id),
+	followeeID INTEGER NOT NULL REFERENCES users(id),
+	createdAt TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+
+	UNIQUE (followerID, followeeID)
+);
+
+CREATE INDEX IF NOT EXISTS follows_followeeID ON follows (followeeID);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	id CHAR(32) NOT NULL PRIMARY KEY,
+	userID INTEGER NOT NULL REFERENCES users(id),
+	createdAt TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	revokedAt TIMESTAMP NULL
+);
+
+CREATE INDEX IF NOT EXISTS sessions_userID ON sessions (userID);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	sessionID CHAR(32) NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+	userID INTEGER NOT NULL REFERENCES users(id),
+	tokenHash CHAR(64) NOT NULL UNIQUE,
+	expiresAt TIMESTAMP NOT NULL,
+	usedAt TIMESTAMP NULL,
+	createdAt TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
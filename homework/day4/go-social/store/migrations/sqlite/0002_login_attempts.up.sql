@@ -0,0 +1,7 @@
+-- Failed logins counted per key, which is either a username or a client IP.
+CREATE TABLE login_attempts (
+	attemptKey VARCHAR(255) NOT NULL PRIMARY KEY,
+	failures INTEGER NOT NULL,
+	lastFailureAt TIMESTAMP NOT NULL,
+	lockedUntil TIMESTAMP NULL
+);
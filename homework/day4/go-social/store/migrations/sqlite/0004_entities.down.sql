@@ -0,0 +1,4 @@
+DROP TABLE mentions;
+DROP TABLE comment_hashtags;
+DROP TABLE post_hashtags;
+DROP TABLE hashtags;
@@ -0,0 +1,35 @@
+-- Hashtags and mentions parsed from the content of posts and comments.
+CREATE TABLE hashtags (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	tag VARCHAR(100) NOT NULL UNIQUE
+);
+
+CREATE TABLE post_hashtags (
+	postID INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+	hashtagID INTEGER NOT NULL REFERENCES hashtags(id),
+
+	PRIMARY KEY (postID, hashtagID)
+);
+
+CREATE INDEX post_hashtags_hashtagID ON post_hashtags (hashtagID);
+
+CREATE TABLE comment_hashtags (
+	commentID INTEGER NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+	hashtagID INTEGER NOT NULL REFERENCES hashtags(id),
+
+	PRIMARY KEY (commentID, hashtagID)
+);
+
+CREATE INDEX comment_hashtags_hashtagID ON comment_hashtags (hashtagID);
+
+-- A mention of userID in post postID, or in comment commentID on that post.
+CREATE TABLE mentions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	userID INTEGER NOT NULL REFERENCES users(id),
+	postID INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+	commentID INTEGER NULL REFERENCES comments(id) ON DELETE CASCADE,
+	createdAt TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE INDEX mentions_userID ON mentions (userID, createdAt);
+CREATE INDEX mentions_postID ON mentions (postID);
@@ -0,0 +1,2 @@
+DROP TABLE notification_actors;
+DROP TABLE notifications;
@@ -0,0 +1,27 @@
+-- Notifications of likes and comments on the posts of userID. The likes of
+-- a post are grouped into one notification while it is unread; its actors
+-- are the users who liked.
+CREATE TABLE notifications (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	userID INTEGER NOT NULL REFERENCES users(id),
+	type VARCHAR(20) NOT NULL,
+	postID INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+	commentID INTEGER NULL REFERENCES comments(id) ON DELETE CASCADE,
+	createdAt TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	-- Moves forward when an actor joins the group.
+	updatedAt TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+	readAt TIMESTAMP NULL
+);
+
+CREATE INDEX notifications_userID ON notifications (userID, updatedAt);
+CREATE INDEX notifications_postID ON notifications (postID, type);
+
+CREATE TABLE notification_actors (
+	notificationID INTEGER NOT NULL REFERENCES notifications(id) ON DELETE CASCADE,
+	userID INTEGER NOT NULL REFERENCES users(id),
+	createdAt TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now')),
+
+	PRIMARY KEY (notificationID, userID)
+);
+
+CREATE INDEX notification_actors_userID ON notification_actors (userID);
@@ -0,0 +1,18 @@
+-- Images attached to posts. The files live in the blob store under
+-- blobKey and thumbnailKey; deleting a post leaves them to the caller.
+CREATE TABLE attachments (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	postID INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+	blobKey VARCHAR(255) NOT NULL,
+	contentType VARCHAR(50) NOT NULL,
+	size INTEGER NOT NULL,
+	width INTEGER NOT NULL,
+	height INTEGER NOT NULL,
+	-- Hex SHA-256 of the stored file, served as its ETag.
+	sha256 CHAR(64) NOT NULL,
+	thumbnailKey VARCHAR(255) NOT NULL,
+	thumbnailContentType VARCHAR(50) NOT NULL,
+	createdAt TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f+00:00', 'now'))
+);
+
+CREATE INDEX attachments_postID ON attachments (postID);
@@ -0,0 +1,3 @@
+-- Mentions look users up by username ignoring case, which the index of the
+-- UNIQUE constraint cannot serve.
+CREATE INDEX idx_users_username_nocase ON users (username COLLATE NOCASE);
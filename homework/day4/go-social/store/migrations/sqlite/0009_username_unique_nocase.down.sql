@@ -0,0 +1,2 @@
+DROP INDEX idx_users_username_nocase;
+CREATE INDEX idx_users_username_nocase ON users (username COLLATE NOCASE);
@@ -0,0 +1,4 @@
+-- Usernames are unique ignoring case, as under the default MySQL collation.
+-- The unique index also serves the lookups of 0008_username_nocase.
+DROP INDEX idx_users_username_nocase;
+CREATE UNIQUE INDEX idx_users_username_nocase ON users (username COLLATE NOCASE);
@@ -0,0 +1,2 @@
+DROP INDEX notifications_userID;
+CREATE INDEX notifications_userID ON notifications (userID, updatedAt);
@@ -0,0 +1,3 @@
+-- Notifications are listed by creation rather than by their latest actor.
+DROP INDEX notifications_userID;
+CREATE INDEX notifications_userID ON notifications (userID, createdAt);
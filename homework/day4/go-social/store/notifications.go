@@ -0,0 +1,262 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"gosocial/types"
+)
+
+// maxNotificationActors is how many of the most recent actors a
+// notification lists.
+const maxNotificationActors = 3
+
+// NotificationStorage keeps the notifications of likes and comments on the
+// posts of a user. Callers leave out the actions of users on their own
+// posts.
+type NotificationStorage interface {
+	// NotifyLike records that actorID liked the post postID of userID. The
+	// actor joins the unread like notification of the post, if there is one.
+	NotifyLike(ctx context.Context, userID, postID, actorID int) error
+	// WithdrawLike removes actorID from the unread like notification of the
+	// post, the one NotifyLike adds to, and deletes it if left without
+	// actors. Read notifications are history and stay as they are.
+	WithdrawLike(ctx context.Context, postID, actorID int) error
+	// NotifyComment records the comment pc on a post of userID.
+	NotifyComment(ctx context.Context, userID int, pc *types.PostComment) error
+	// GetNotifications returns up to limit notifications of the user, newest
+	// first, starting after the given cursor. They are ordered by creation
+	// rather than by their latest actor so that actors joining a group do
+	// not move it across the pages of a client.
+	GetNotifications(ctx context.Context, userID int, cursor *types.Cursor, limit int) ([]*types.Notification, error)
+	CountUnreadNotifications(ctx context.Context, userID int) (int, error)
+	// MarkNotificationsRead marks the notifications of the user with the
+	// given IDs read, or all of them if ids is empty. IDs of other users'
+	// notifications are ignored. It returns how many were unread.
+	MarkNotificationsRead(ctx context.Context, userID int, ids []int) (int, error)
+}
+
+func (store *sqlStorage) NotifyLike(ctx context.Context, userID, postID, actorID int) error {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the post so that concurrent likes of it find the notification
+	// the first one creates. Locking the notification itself would not do,
+	// as there may be none yet.
+	var locked int
+	err = tx.QueryRowContext(ctx, "SELECT id FROM posts WHERE id = ?"+store.dialect.forUpdate(), postID).Scan(&locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errReferenceNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	now := store.dialect.timeArg(time.Now())
+	q := "SELECT id FROM notifications WHERE userID = ? AND postID = ? AND type = ? AND readAt IS NULL"
+	var id int64
+	err = tx.QueryRowContext(ctx, q, userID, postID, types.NotificationLike).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		q := "INSERT INTO notifications (userID, type, postID, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?)"
+		var res sql.Result
+		res, err = tx.ExecContext(ctx, q, userID, types.NotificationLike, postID, now, now)
+		if err == nil {
+			id, err = res.LastInsertId()
+		}
+	} else if err == nil {
+		_, err = tx.ExecContext(ctx, "UPDATE notifications SET updatedAt = ? WHERE id = ?", now, id)
+	}
+	if store.dialect.isForeignKeyViolation(err) {
+		return errReferenceNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	// A like withdrawn before the notification was read leaves no actor
+	// behind, so the actor cannot already be in the group.
+	q = "INSERT INTO notification_actors (notificationID, userID, createdAt) VALUES (?, ?, ?)"
+	_, err = tx.ExecContext(ctx, q, id, actorID, now)
+	if store.dialect.isForeignKeyViolation(err) {
+		return errUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *sqlStorage) WithdrawLike(ctx context.Context, postID, actorID int) error {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	q := `
+	DELETE FROM notification_actors
+	WHERE userID = ? AND notificationID IN (
+		SELECT id FROM notifications WHERE postID = ? AND type = ? AND readAt IS NULL)`
+	if _, err := tx.ExecContext(ctx, q, actorID, postID, types.NotificationLike); err != nil {
+		return err
+	}
+	q = `
+	DELETE FROM notifications
+	WHERE postID = ? AND type = ? AND readAt IS NULL
+		AND NOT EXISTS (SELECT 1 FROM notification_actors na WHERE na.notificationID = notifications.id)`
+	if _, err := tx.ExecContext(ctx, q, postID, types.NotificationLike); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *sqlStorage) NotifyComment(ctx context.Context, userID int, pc *types.PostComment) error {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := store.dialect.timeArg(time.Now())
+	q := "INSERT INTO notifications (userID, type, postID, commentID, createdAt, updatedAt) VALUES (?, ?, ?, ?, ?, ?)"
+	res, err := tx.ExecContext(ctx, q, userID, types.NotificationComment, pc.PostID, pc.ID, now, now)
+	if store.dialect.isForeignKeyViolation(err) {
+		return errReferenceNotFound
+	}
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	q = "INSERT INTO notification_actors (notificationID, userID, createdAt) VALUES (?, ?, ?)"
+	if _, err := tx.ExecContext(ctx, q, id, pc.UserID, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *sqlStorage) GetNotifications(ctx context.Context, userID int, cursor *types.Cursor, limit int) ([]*types.Notification, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := `
+	SELECT n.id, n.type, n.postID, n.commentID, n.createdAt, n.updatedAt, n.readAt,
+		(SELECT COUNT(*) FROM notification_actors na WHERE na.notificationID = n.id)
+	FROM notifications n
+	WHERE n.userID = ?`
+	args := []any{userID}
+	q, args = store.appendCursorFilter(q, args, "n.createdAt", "n.id", cursor)
+	q += " ORDER BY n.createdAt DESC, n.id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := store.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []*types.Notification{}
+	byID := map[int]*types.Notification{}
+	for rows.Next() {
+		n := &types.Notification{Actors: []types.UserSummary{}}
+		var commentID sql.NullInt64
+		var readAt sql.NullTime
+		if err := rows.Scan(&n.ID, &n.Type, &n.PostID, &commentID, &n.CreatedAt, &n.UpdatedAt, &readAt, &n.ActorCount); err != nil {
+			return nil, err
+		}
+		n.CommentID = int(commentID.Int64)
+		n.Read = readAt.Valid
+		notifications = append(notifications, n)
+		byID[n.ID] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(notifications) == 0 {
+		return notifications, nil
+	}
+
+	q = `
+	SELECT na.notificationID, u.id, u.username, u.userProfile
+	FROM notification_actors na JOIN users u ON u.id = na.userID
+	WHERE na.notificationID IN (` + placeholders(len(notifications)) + `)
+	ORDER BY na.createdAt DESC, u.id DESC`
+	ids := make([]any, len(notifications))
+	for i, n := range notifications {
+		ids[i] = n.ID
+	}
+	rows, err = store.db.QueryContext(ctx, q, ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var u types.UserSummary
+		var profile sql.NullString
+		if err := rows.Scan(&id, &u.ID, &u.Username, &profile); err != nil {
+			return nil, err
+		}
+		u.UserProfile = profile.String
+		if n := byID[id]; len(n.Actors) < maxNotificationActors {
+			n.Actors = append(n.Actors, u)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+func (store *sqlStorage) CountUnreadNotifications(ctx context.Context, userID int) (int, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := "SELECT COUNT(*) FROM notifications WHERE userID = ? AND readAt IS NULL"
+	var count int
+	if err := store.db.QueryRowContext(ctx, q, userID).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (store *sqlStorage) MarkNotificationsRead(ctx context.Context, userID int, ids []int) (int, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := "UPDATE notifications SET readAt = ? WHERE userID = ? AND readAt IS NULL"
+	args := []any{store.dialect.timeArg(time.Now()), userID}
+	if len(ids) > 0 {
+		q += " AND id IN (" + placeholders(len(ids)) + ")"
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	res, err := store.db.ExecContext(ctx, q, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
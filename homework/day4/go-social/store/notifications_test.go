@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"gosocial/types"
+)
+
+func TestNotifyLikeConcurrently(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, s Storage) {
+		ctx := context.Background()
+		post := createTestPost(t, s, "alice")
+		const likers = 8
+		actors := make([]int, likers)
+		for i := range actors {
+			u := &types.User{Username: fmt.Sprintf("user%d", i), Password: "x"}
+			if err := s.CreateUser(ctx, u); err != nil {
+				t.Fatal(err)
+			}
+			actors[i] = u.ID
+		}
+
+		var wg sync.WaitGroup
+		for _, actorID := range actors {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := s.NotifyLike(ctx, post.UserID, post.ID, actorID); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		notifications, err := s.GetNotifications(ctx, post.UserID, nil, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(notifications) != 1 || notifications[0].ActorCount != likers {
+			t.Errorf("got %d notifications, want one grouping all %d likes", len(notifications), likers)
+		}
+	})
+}
+
+// TestNotificationPagesAreStable checks that a like joining a group does not
+// move it across the pages of a client that is paging through them.
+func TestNotificationPagesAreStable(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, s Storage) {
+		ctx := context.Background()
+		first := createTestPost(t, s, "alice")
+		second := &types.Post{UserID: first.UserID, Content: "post"}
+		if err := s.CreatePost(ctx, second); err != nil {
+			t.Fatal(err)
+		}
+		var actors []int
+		for _, name := range []string{"bob", "carol"} {
+			u := &types.User{Username: name, Password: "x"}
+			if err := s.CreateUser(ctx, u); err != nil {
+				t.Fatal(err)
+			}
+			actors = append(actors, u.ID)
+		}
+		for _, postID := range []int{first.ID, second.ID} {
+			if err := s.NotifyLike(ctx, first.UserID, postID, actors[0]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		page, err := s.GetNotifications(ctx, first.UserID, nil, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) != 1 || page[0].PostID != second.ID {
+			t.Fatalf("got %d notifications on the first page, want the likes of post %d", len(page), second.ID)
+		}
+
+		// Another like of the first post, which is on the next page.
+		if err := s.NotifyLike(ctx, first.UserID, first.ID, actors[1]); err != nil {
+			t.Fatal(err)
+		}
+		page, err = s.GetNotifications(ctx, first.UserID, &types.Cursor{CreatedAt: page[0].CreatedAt, ID: page[0].ID}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) != 1 || page[0].PostID != first.ID || page[0].ActorCount != 2 {
+			t.Errorf("got %d notifications on the second page, want the two likes of post %d", len(page), first.ID)
+		}
+	})
+}
@@ -0,0 +1,299 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"unicode/utf8"
+
+	"gosocial/logging"
+	"gosocial/search"
+	"gosocial/types"
+)
+
+// SearchStorage ranks posts and users by how well they match a search.
+type SearchStorage interface {
+	// SearchPosts returns up to limit posts containing any of terms, best
+	// match first, skipping the first offset matches. Terms are produced by
+	// search.Terms.
+	SearchPosts(ctx context.Context, terms []string, offset, limit int) ([]*types.PostSearchHit, error)
+	// SearchUsers is SearchPosts for users, matching their username and
+	// profile.
+	SearchUsers(ctx context.Context, terms []string, offset, limit int) ([]*types.UserSearchHit, error)
+}
+
+// searcher is the part of search that differs between SQL backends. The
+// changed and deleted hooks are called after the rows were written, for
+// searchers that keep an index of their own.
+type searcher interface {
+	// load runs from Init once the schema is up to date.
+	load(ctx context.Context, store *sqlStorage) error
+	searchPosts(ctx context.Context, store *sqlStorage, terms []string, offset, limit int) ([]*types.PostSearchHit, error)
+	searchUsers(ctx context.Context, store *sqlStorage, terms []string, offset, limit int) ([]*types.UserSearchHit, error)
+	postChanged(p *types.Post)
+	postDeleted(id int)
+	userChanged(ctx context.Context, store *sqlStorage, id int)
+}
+
+func (store *sqlStorage) SearchPosts(ctx context.Context, terms []string, offset, limit int) ([]*types.PostSearchHit, error) {
+	if len(terms) == 0 {
+		return []*types.PostSearchHit{}, nil
+	}
+	return store.searcher.searchPosts(ctx, store, terms, offset, limit)
+}
+
+func (store *sqlStorage) SearchUsers(ctx context.Context, terms []string, offset, limit int) ([]*types.UserSearchHit, error) {
+	if len(terms) == 0 {
+		return []*types.UserSearchHit{}, nil
+	}
+	return store.searcher.searchUsers(ctx, store, terms, offset, limit)
+}
+
+// fulltextSearcher uses the n-gram FULLTEXT indexes of MySQL in boolean
+// mode, ranking rows matching any of the terms.
+//
+// The n-gram parser matches terms anywhere in a word rather than whole
+// words, so it finds a superset of what the in-process index finds. Terms
+// shorter than ngram_token_size are matched as n-gram prefixes; with the
+// default size of 2, a single CJK character at the very end of a text is
+// therefore not found. Run MySQL with --ngram_token_size=1 to avoid that.
+type fulltextSearcher struct{}
+
+// booleanQuery turns terms into a MySQL boolean mode query matching any of
+// them. Terms only hold letters, digits and marks, so they need no
+// escaping; each is quoted so that the n-gram parser matches its n-grams
+// as a phrase, and single characters become prefix searches.
+func booleanQuery(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		if utf8.RuneCountInString(t) == 1 {
+			parts[i] = t + "*"
+		} else {
+			parts[i] = `"` + t + `"`
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func (fulltextSearcher) load(ctx context.Context, store *sqlStorage) error {
+	return nil
+}
+
+func (fulltextSearcher) searchPosts(ctx context.Context, store *sqlStorage, terms []string, offset, limit int) ([]*types.PostSearchHit, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := `
+	SELECT p.id, p.userID, p.content, p.createdAt,
+		(SELECT COUNT(*) FROM likes l WHERE l.postID = p.id),
+		(SELECT COUNT(*) FROM comments c WHERE c.postID = p.id),
+		MATCH(p.content) AGAINST (? IN BOOLEAN MODE) AS score
+	FROM posts p
+	WHERE MATCH(p.content) AGAINST (? IN BOOLEAN MODE)
+	ORDER BY score DESC, p.id DESC LIMIT ? OFFSET ?`
+	query := booleanQuery(terms)
+	rows, err := store.db.QueryContext(ctx, q, query, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hits := []*types.PostSearchHit{}
+	for rows.Next() {
+		h := new(types.PostSearchHit)
+		if err := rows.Scan(&h.ID, &h.UserID, &h.Content, &h.CreatedAt, &h.LikeCount, &h.CommentCount, &h.Score); err != nil {
+			return nil, err
+		}
+		hits = append(hits, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return hits, nil
+}
+
+func (fulltextSearcher) searchUsers(ctx context.Context, store *sqlStorage, terms []string, offset, limit int) ([]*types.UserSearchHit, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := `
+	SELECT id, username, userProfile,
+		MATCH(username, userProfile) AGAINST (? IN BOOLEAN MODE) AS score
+	FROM users
+	WHERE MATCH(username, userProfile) AGAINST (? IN BOOLEAN MODE)
+	ORDER BY score DESC, id DESC LIMIT ? OFFSET ?`
+	query := booleanQuery(terms)
+	rows, err := store.db.QueryContext(ctx, q, query, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hits := []*types.UserSearchHit{}
+	for rows.Next() {
+		h := new(types.UserSearchHit)
+		var profile sql.NullString
+		if err := rows.Scan(&h.ID, &h.Username, &profile, &h.Score); err != nil {
+			return nil, err
+		}
+		h.UserProfile = profile.String
+		hits = append(hits, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return hits, nil
+}
+
+func (fulltextSearcher) postChanged(p *types.Post) {}
+
+func (fulltextSearcher) postDeleted(id int) {}
+
+func (fulltextSearcher) userChanged(ctx context.Context, store *sqlStorage, id int) {}
+
+// indexSearcher ranks with in-process inverted indexes, for databases
+// without full-text search. The indexes are built from the database when
+// the storage is initialized and then follow the writes made through the
+// storage, so processes sharing the database file do not see each other's
+// changes until they restart.
+type indexSearcher struct {
+	posts *search.Index
+	users *search.Index
+}
+
+func newIndexSearcher() *indexSearcher {
+	return &indexSearcher{posts: search.NewIndex(), users: search.NewIndex()}
+}
+
+func (s *indexSearcher) load(ctx context.Context, store *sqlStorage) error {
+	rows, err := store.db.QueryContext(ctx, "SELECT id, content FROM posts")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var content sql.NullString
+		if err := rows.Scan(&id, &content); err != nil {
+			return err
+		}
+		s.posts.Put(id, content.String)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = store.db.QueryContext(ctx, "SELECT id, username, userProfile FROM users")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var username string
+		var profile sql.NullString
+		if err := rows.Scan(&id, &username, &profile); err != nil {
+			return err
+		}
+		s.users.Put(id, username, profile.String)
+	}
+	return rows.Err()
+}
+
+func (s *indexSearcher) searchPosts(ctx context.Context, store *sqlStorage, terms []string, offset, limit int) ([]*types.PostSearchHit, error) {
+	hits := s.posts.Search(terms, offset, limit)
+	if len(hits) == 0 {
+		return []*types.PostSearchHit{}, nil
+	}
+
+	q := feedPostColumns + " WHERE p.id IN (" + placeholders(len(hits)) + ")"
+	posts, err := store.queryFeedPosts(ctx, q, hitIDs(hits)...)
+	if err != nil {
+		return nil, err
+	}
+	byID := map[int]*types.FeedPost{}
+	for _, p := range posts {
+		byID[p.ID] = p
+	}
+
+	results := []*types.PostSearchHit{}
+	for _, h := range hits {
+		// A post deleted since it was found is left out.
+		if p, ok := byID[h.ID]; ok {
+			results = append(results, &types.PostSearchHit{FeedPost: *p, Score: h.Score})
+		}
+	}
+	return results, nil
+}
+
+func (s *indexSearcher) searchUsers(ctx context.Context, store *sqlStorage, terms []string, offset, limit int) ([]*types.UserSearchHit, error) {
+	hits := s.users.Search(terms, offset, limit)
+	if len(hits) == 0 {
+		return []*types.UserSearchHit{}, nil
+	}
+
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := "SELECT id, username, userProfile FROM users WHERE id IN (" + placeholders(len(hits)) + ")"
+	rows, err := store.db.QueryContext(ctx, q, hitIDs(hits)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := map[int]types.UserSummary{}
+	for rows.Next() {
+		var u types.UserSummary
+		var profile sql.NullString
+		if err := rows.Scan(&u.ID, &u.Username, &profile); err != nil {
+			return nil, err
+		}
+		u.UserProfile = profile.String
+		byID[u.ID] = u
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	results := []*types.UserSearchHit{}
+	for _, h := range hits {
+		if u, ok := byID[h.ID]; ok {
+			results = append(results, &types.UserSearchHit{UserSummary: u, Score: h.Score})
+		}
+	}
+	return results, nil
+}
+
+func (s *indexSearcher) postChanged(p *types.Post) {
+	s.posts.Put(p.ID, p.Content)
+}
+
+func (s *indexSearcher) postDeleted(id int) {
+	s.posts.Delete(id)
+}
+
+// userChanged reads the user back, since updates only carry the changed
+// fields. A failure leaves the index stale until the next restart, which is
+// not worth failing the update for.
+func (s *indexSearcher) userChanged(ctx context.Context, store *sqlStorage, id int) {
+	u, err := store.GetUserByID(ctx, id)
+	if err != nil {
+		logging.FromContext(ctx).Warn("indexing user for search failed", "user_id", id, "error", err)
+		return
+	}
+	s.users.Put(u.ID, u.Username, u.UserProfile)
+}
+
+// placeholders returns n comma-separated query placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func hitIDs(hits []search.Hit) []any {
+	ids := make([]any, len(hits))
+	for i, h := range hits {
+		ids[i] = h.ID
+	}
+	return ids
+}
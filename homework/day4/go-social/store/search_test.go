@@ -0,0 +1,69 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"gosocial/search"
+	"gosocial/types"
+)
+
+func TestBooleanQuery(t *testing.T) {
+	for terms, want := range map[string]string{
+		"go":          `"go"`,
+		"中文":          `中* 文*`,
+		"Golang 入門 x": `"golang" 入* 門* x*`,
+	} {
+		if got := booleanQuery(search.Terms(terms)); got != want {
+			t.Errorf("booleanQuery(%q) = %s, want %s", terms, got, want)
+		}
+	}
+}
+
+// TestSearchTerms checks that every backend finds the terms search.Terms
+// produces, including short words and CJK characters.
+func TestSearchTerms(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, s Storage) {
+		ctx := context.Background()
+		author := &types.User{Username: "li", Password: "x", UserProfile: "写代码"}
+		other := &types.User{Username: "someone", Password: "x", UserProfile: "hello"}
+		for _, u := range []*types.User{author, other} {
+			if err := s.CreateUser(ctx, u); err != nil {
+				t.Fatal(err)
+			}
+		}
+		posts := map[string]*types.Post{}
+		for _, content := range []string{"我在学习中文", "go is fun", "hello world"} {
+			p := &types.Post{UserID: author.ID, Content: content}
+			if err := s.CreatePost(ctx, p); err != nil {
+				t.Fatal(err)
+			}
+			posts[content] = p
+		}
+
+		for query, want := range map[string]int{
+			"中文":   posts["我在学习中文"].ID,
+			"go":   posts["go is fun"].ID,
+			"文":    posts["我在学习中文"].ID,
+			"學習 学": posts["我在学习中文"].ID,
+		} {
+			hits, err := s.SearchPosts(ctx, search.Terms(query), 0, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(hits) != 1 || hits[0].ID != want {
+				t.Errorf("posts matching %q: got %d hits, want post %d", query, len(hits), want)
+			}
+		}
+
+		for query, want := range map[string]int{"li": author.ID, "代码": author.ID} {
+			hits, err := s.SearchUsers(ctx, search.Terms(query), 0, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(hits) != 1 || hits[0].ID != want {
+				t.Errorf("users matching %q: got %d hits, want user %d", query, len(hits), want)
+			}
+		}
+	})
+}
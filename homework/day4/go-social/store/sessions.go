@@ -0,0 +1,183 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+
+	"gosocial/errs"
+	"gosocial/types"
+)
+
+type SessionStorage interface {
+	CreateSession(ctx context.Context, userID int) (*types.Session, error)
+	GetSessionByID(ctx context.Context, id string) (*types.Session, error)
+	RevokeSession(ctx context.Context, id string) error
+	RevokeUserSessions(ctx context.Context, userID int) error
+	CreateRefreshToken(context.Context, *types.RefreshToken) error
+	GetRefreshTokenByHash(ctx context.Context, hash string) (*types.RefreshToken, error)
+	MarkRefreshTokenUsed(ctx context.Context, id int) (bool, error)
+}
+
+// AuthStorage is what the authentication middleware needs to resolve a token
+// to a live session and user.
+type AuthStorage interface {
+	UserStorage
+	SessionStorage
+}
+
+func (store *sqlStorage) CreateSession(ctx context.Context, userID int) (*types.Session, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	q := "INSERT INTO sessions (id, userID) VALUES (?, ?)"
+	if _, err := store.db.ExecContext(ctx, q, id, userID); err != nil {
+		return nil, err
+	}
+	return store.GetSessionByID(ctx, id)
+}
+
+func (store *sqlStorage) GetSessionByID(ctx context.Context, id string) (*types.Session, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := "SELECT * FROM sessions WHERE id = ?"
+	rows, err := store.db.QueryContext(ctx, q, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sess := new(types.Session)
+	for rows.Next() {
+		if err := scanRowToSession(rows, sess); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if sess.ID == "" {
+		return nil, errs.NotFound("session_not_found", "session not found")
+	}
+	return sess, nil
+}
+
+func (store *sqlStorage) RevokeSession(ctx context.Context, id string) error {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := "UPDATE sessions SET revokedAt = CURRENT_TIMESTAMP WHERE id = ? AND revokedAt IS NULL"
+	_, err := store.db.ExecContext(ctx, q, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// RevokeUserSessions ends every active session of the user, invalidating all
+// of their access and refresh tokens.
+func (store *sqlStorage) RevokeUserSessions(ctx context.Context, userID int) error {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := "UPDATE sessions SET revokedAt = CURRENT_TIMESTAMP WHERE userID = ? AND revokedAt IS NULL"
+	_, err := store.db.ExecContext(ctx, q, userID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (store *sqlStorage) CreateRefreshToken(ctx context.Context, rt *types.RefreshToken) error {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := "INSERT INTO refresh_tokens (sessionID, userID, tokenHash, expiresAt) VALUES (?, ?, ?, ?)"
+	_, err := store.db.ExecContext(ctx, q, rt.SessionID, rt.UserID, rt.TokenHash, store.dialect.timeArg(rt.ExpiresAt))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (store *sqlStorage) GetRefreshTokenByHash(ctx context.Context, hash string) (*types.RefreshToken, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := "SELECT * FROM refresh_tokens WHERE tokenHash = ?"
+	rows, err := store.db.QueryContext(ctx, q, hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rt := new(types.RefreshToken)
+	for rows.Next() {
+		if err := scanRowToRefreshToken(rows, rt); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if rt.ID == 0 {
+		return nil, errs.NotFound("refresh_token_not_found", "refresh token not found")
+	}
+	return rt, nil
+}
+
+// MarkRefreshTokenUsed atomically marks the token as used. It reports false
+// if the token had already been used, which means it is being replayed.
+func (store *sqlStorage) MarkRefreshTokenUsed(ctx context.Context, id int) (bool, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := "UPDATE refresh_tokens SET usedAt = CURRENT_TIMESTAMP WHERE id = ? AND usedAt IS NULL"
+	res, err := store.db.ExecContext(ctx, q, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func scanRowToSession(rows *sql.Rows, s *types.Session) error {
+	return rows.Scan(
+		&s.ID,
+		&s.UserID,
+		&s.CreatedAt,
+		&s.RevokedAt,
+	)
+}
+
+func scanRowToRefreshToken(rows *sql.Rows, rt *types.RefreshToken) error {
+	return rows.Scan(
+		&rt.ID,
+		&rt.SessionID,
+		&rt.UserID,
+		&rt.TokenHash,
+		&rt.ExpiresAt,
+		&rt.UsedAt,
+		&rt.CreatedAt,
+	)
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"gosocial/types"
+)
+
+func TestMarkRefreshTokenUsedConcurrently(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, s Storage) {
+		ctx := context.Background()
+		u := &types.User{Username: "alice", Password: "x"}
+		if err := s.CreateUser(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+		session, err := s.CreateSession(ctx, u.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rt := &types.RefreshToken{SessionID: session.ID, UserID: u.ID, TokenHash: "hash", ExpiresAt: time.Now().Add(time.Hour)}
+		if err := s.CreateRefreshToken(ctx, rt); err != nil {
+			t.Fatal(err)
+		}
+		rt, err = s.GetRefreshTokenByHash(ctx, "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Of the refreshes racing with the same token, one rotates it and
+		// the others see it reused.
+		const attempts = 8
+		var wg sync.WaitGroup
+		results := make(chan bool, attempts)
+		for i := 0; i < attempts; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ok, err := s.MarkRefreshTokenUsed(ctx, rt.ID)
+				if err != nil {
+					t.Error(err)
+				}
+				results <- ok
+			}()
+		}
+		wg.Wait()
+		close(results)
+		rotated := 0
+		for ok := range results {
+			if ok {
+				rotated++
+			}
+		}
+		if rotated != 1 {
+			t.Errorf("%d of %d uses rotated the token, want 1", rotated, attempts)
+		}
+
+		rt, err = s.GetRefreshTokenByHash(ctx, "hash")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rt.UsedAt == nil {
+			t.Error("the used token has no UsedAt")
+		}
+	})
+}
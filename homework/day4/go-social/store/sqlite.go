@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqliteTimeLayout is the layout of timestamps written by the column
+// defaults of the SQLite migrations. Every timestamp is stored in UTC in this fixed-width form
+// so that comparing the text compares the times.
+const sqliteTimeLayout = "2006-01-02 15:04:05.000-07:00"
+
+// SQLiteStorage stores everything in a local SQLite database file using a
+// pure-Go driver, so it needs neither a database server nor cgo.
+type SQLiteStorage struct {
+	sqlStorage
+}
+
+func NewSQLiteStorage(path string, pool PoolConfig) (*SQLiteStorage, error) {
+	dsn := "file:" + path + "?" + url.Values{
+		"_pragma": {"foreign_keys(1)", "busy_timeout(5000)", "journal_mode(WAL)"},
+		// Every transaction writes, so it might as well wait for the write
+		// lock up front instead of failing to upgrade a stale read.
+		"_txlock": {"immediate"},
+	}.Encode()
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &SQLiteStorage{newSQLStorage(db, sqliteDialect{}, pool)}, nil
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) isDuplicateEntry(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+}
+
+func (sqliteDialect) isForeignKeyViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY
+}
+
+func (sqliteDialect) timeArg(t time.Time) any {
+	return t.UTC().Format(sqliteTimeLayout)
+}
+
+func (sqliteDialect) name() string {
+	return "sqlite"
+}
+
+func (sqliteDialect) tableExistsQuery() string {
+	return "SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?"
+}
+
+func (sqliteDialect) columnExistsQuery() string {
+	return "SELECT 1 FROM pragma_table_info(?) WHERE name = ?"
+}
+
+// lockMigrations opens a write transaction on conn, which blocks every other
+// writer of the database file until it ends. SQLite DDL is transactional, so
+// a failed run leaves the schema untouched.
+func (sqliteDialect) lockMigrations(ctx context.Context, conn *sql.Conn) (func(bool) error, error) {
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return nil, err
+	}
+	return func(failed bool) error {
+		stmt := "COMMIT"
+		if failed {
+			stmt = "ROLLBACK"
+		}
+		_, err := conn.ExecContext(context.Background(), stmt)
+		return err
+	}, nil
+}
+
+func (sqliteDialect) newSearcher() searcher {
+	return newIndexSearcher()
+}
+
+// forUpdate needs no clause: transactions begin immediately, taking the
+// write lock of the whole database before their first read.
+func (sqliteDialect) forUpdate() string {
+	return ""
+}
+
+func (sqliteDialect) caseInsensitive() string {
+	return " COLLATE NOCASE"
+}
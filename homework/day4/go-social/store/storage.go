@@ -1,250 +1,899 @@
 package store
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
+	"gosocial/entities"
+	"gosocial/errs"
+	"gosocial/logging"
 	"gosocial/types"
 	"github.com/go-sql-driver/mysql"
 )
 
 type UserStorage interface {
-	GetUserByID(int) (*types.User, error)
-	GetUserByUsername(string) (*types.User, error)
-	CreateUser(*types.User) error
-	UpdateUser(*types.User) error
+	GetUserByID(context.Context, int) (*types.User, error)
+	// GetUserByUsername compares usernames ignoring case, and CreateUser
+	// refuses a username that differs from a taken one only in case.
+	GetUserByUsername(context.Context, string) (*types.User, error)
+	CreateUser(context.Context, *types.User) error
+	UpdateUser(context.Context, *types.User) error
 }
 
-type MySQLStorage struct {
-	db *sql.DB
+type PostStorage interface {
+	CreatePost(context.Context, *types.Post) error
+	GetPostByID(context.Context, int) (*types.Post, error)
+	UpdatePost(context.Context, *types.Post) error
+	DeletePost(context.Context, int) error
+	GetFeed(ctx context.Context, userID int, cursor *types.Cursor, limit int) ([]*types.FeedPost, error)
+	GetPostsByUserID(ctx context.Context, userID int, cursor *types.Cursor, limit int) ([]*types.FeedPost, error)
 }
 
-func NewMySQLStorage(cfg mysql.Config) (*MySQLStorage, error) {
-	db, err := sql.Open("mysql", cfg.FormatDSN())
-	if err != nil {
-		return nil, err
-	}
-	return &MySQLStorage{
-		db: db,
-	}, nil
+type LikeStorage interface {
+	GetPostLikeByUserID(ctx context.Context, postID, userID int) (*types.PostLike, error)
+	GetPostLikeCount(ctx context.Context, postID int) (int, error)
+	GetPostLikers(ctx context.Context, postID int, cursor *types.Cursor, limit int) ([]*types.PostLiker, error)
+	LikePost(ctx context.Context, postID, userID int) error
+	UnlikePost(ctx context.Context, postID, userID int) error
 }
 
-func (store *MySQLStorage) Ping() error {
-	return store.db.Ping()
+type CommentStorage interface {
+	CommentPost(context.Context, *types.PostComment) error
+	GetCommentByID(context.Context, int) (*types.PostComment, error)
+	GetPostComments(ctx context.Context, postID int, cursor *types.Cursor, limit int) ([]*types.PostCommentEntry, error)
+	DeleteComment(context.Context, int) error
 }
 
-func (store *MySQLStorage) Init() error {
-	createUsersTableQuery := `
-	CREATE TABLE IF NOT EXISTS users (
-		id INT UNSIGNED NOT NULL AUTO_INCREMENT,
-		username VARCHAR(50) NOT NULL,
-		password VARCHAR(255) NOT NULL,
-		userProfile VARCHAR(255),
-		createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+type FollowStorage interface {
+	GetFollow(ctx context.Context, followerID, followeeID int) (*types.Follow, error)
+	FollowUser(ctx context.Context, followerID, followeeID int) error
+	UnfollowUser(ctx context.Context, followerID, followeeID int) error
+	GetFollowCounts(ctx context.Context, userID int) (followers int, following int, err error)
+	GetFollowers(ctx context.Context, userID int, cursor *types.Cursor, limit int) ([]*types.FollowListEntry, error)
+	GetFollowing(ctx context.Context, userID int, cursor *types.Cursor, limit int) ([]*types.FollowListEntry, error)
+}
 
-		PRIMARY KEY (id),
-		UNIQUE KEY(username)
-	);`
-	_, err := store.db.Exec(createUsersTableQuery)
-	if err != nil {
-		return err
-	}
+// Storage is everything the API server needs from a storage backend.
+type Storage interface {
+	Ping(context.Context) error
+	Init(context.Context) error
+	// CheckSchema reports an error if any table created by Init is missing.
+	CheckSchema(context.Context) error
+	Close() error
+	UserStorage
+	PostStorage
+	LikeStorage
+	CommentStorage
+	FollowStorage
+	SessionStorage
+	LoginAttemptStorage
+	SearchStorage
+	EntityStorage
+	NotificationStorage
+	AttachmentStorage
+}
 
-	createPostsTableQuery := `
-	CREATE TABLE IF NOT EXISTS posts (
-		id INT UNSIGNED NOT NULL AUTO_INCREMENT,
-		userID INT UNSIGNED NOT NULL,
-		content TEXT,
-		createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+var (
+	errUserNotFound      = errs.NotFound("user_not_found", "user not found")
+	errReferenceNotFound = errs.NotFound("reference_not_found", "referenced post or user not found")
+)
 
-		PRIMARY KEY (id),
-		FOREIGN KEY (userID) REFERENCES users(id)
-	);`
-	_, err = store.db.Exec(createPostsTableQuery)
-	if err != nil {
-		return err
-	}
+// sqlStorage implements Storage on top of database/sql. The queries are
+// portable between MySQL and SQLite; what differs is described by dialect.
+type sqlStorage struct {
+	db           *sql.DB
+	dialect      dialect
+	searcher     searcher
+	queryTimeout time.Duration
+}
 
-	createLikesTableQuery := `
-	CREATE TABLE IF NOT EXISTS likes (
-		id INT UNSIGNED NOT NULL AUTO_INCREMENT,
-		postID INT UNSIGNED NOT NULL,
-		userID INT UNSIGNED NOT NULL,
-		timestamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+// PoolConfig limits the connection pool of a SQL backend and bounds how long
+// a single store call may keep a connection busy.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// QueryTimeout is the deadline of every store call, on top of any
+	// deadline the caller's context already carries. Zero disables it.
+	QueryTimeout time.Duration
+}
 
-		PRIMARY KEY (id),
-		FOREIGN KEY (postID) REFERENCES posts(id),
-		FOREIGN KEY (userID) REFERENCES users(id)
-	);`
-	_, err = store.db.Exec(createLikesTableQuery)
-	if err != nil {
-		return err
+func newSQLStorage(db *sql.DB, d dialect, pool PoolConfig) sqlStorage {
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
+	return sqlStorage{db: db, dialect: d, searcher: d.newSearcher(), queryTimeout: pool.QueryTimeout}
+}
+
+// withTimeout derives the context a store call runs its queries with. Calls
+// that run out of time are logged with the logger of the request.
+func (store *sqlStorage) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if store.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, store.queryTimeout)
+	return ctx, func() {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			logging.FromContext(ctx).Warn("store call timed out", "timeout", store.queryTimeout)
+		}
+		cancel()
 	}
+}
+
+type dialect interface {
+	// isDuplicateEntry reports whether err is a unique key violation.
+	isDuplicateEntry(err error) bool
+	// isForeignKeyViolation reports whether err was caused by a reference
+	// to a missing parent row.
+	isForeignKeyViolation(err error) bool
+	// timeArg converts a time into the query argument that compares
+	// correctly against the timestamp columns of the dialect.
+	timeArg(t time.Time) any
+	// name selects the directory of embedded migrations for the dialect.
+	name() string
+	// tableExistsQuery returns a query selecting a row when the table named
+	// by its only argument exists.
+	tableExistsQuery() string
+	// columnExistsQuery returns a query selecting a row when the table named
+	// by its first argument has the column named by its second.
+	columnExistsQuery() string
+	// lockMigrations serializes schema changes between processes sharing
+	// the database. unlock releases the lock; failed reports whether the
+	// migrations run while it was held returned an error.
+	lockMigrations(ctx context.Context, conn *sql.Conn) (unlock func(failed bool) error, err error)
+	// newSearcher returns the search implementation of the dialect.
+	newSearcher() searcher
+	// forUpdate ends a SELECT run in a transaction so that the rows it reads
+	// stay locked until the transaction ends. Read-then-write transactions
+	// use it to keep concurrent ones from acting on the same stale read.
+	forUpdate() string
+	// caseInsensitive follows a text column to compare it ignoring the case
+	// of ASCII letters.
+	caseInsensitive() string
+}
 
-	createCommentsTableQuery := `
-	CREATE TABLE IF NOT EXISTS comments (
-		id INT UNSIGNED NOT NULL AUTO_INCREMENT,
-		postID INT UNSIGNED NOT NULL,
-		userID INT UNSIGNED NOT NULL,
-		content TEXT,
-		timestamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+type MySQLStorage struct {
+	sqlStorage
+}
 
-		PRIMARY KEY (id),
-		FOREIGN KEY (postID) REFERENCES posts(id),
-		FOREIGN KEY (userID) REFERENCES users(id)
-	);`
-	_, err = store.db.Exec(createCommentsTableQuery)
+func NewMySQLStorage(cfg mysql.Config, pool PoolConfig) (*MySQLStorage, error) {
+	db, err := sql.Open("mysql", cfg.FormatDSN())
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return &MySQLStorage{newSQLStorage(db, mysqlDialect{}, pool)}, nil
+}
+
+func (store *sqlStorage) Ping(ctx context.Context) error {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	return store.db.PingContext(ctx)
+}
 
+// schemaTables are the tables the migrations create.
+var schemaTables = []string{
+	"users", "posts", "likes", "comments", "follows", "sessions", "refresh_tokens",
+	"login_attempts", "hashtags", "post_hashtags", "comment_hashtags", "mentions",
+	"notifications", "notification_actors", "attachments",
+}
+
+func (store *sqlStorage) CheckSchema(ctx context.Context) error {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	var missing []string
+	for _, table := range schemaTables {
+		var exists int
+		err := store.db.QueryRowContext(ctx, store.dialect.tableExistsQuery(), table).Scan(&exists)
+		if errors.Is(err, sql.ErrNoRows) {
+			missing = append(missing, table)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing tables: %s", strings.Join(missing, ", "))
+	}
 	return nil
 }
 
-func (store *MySQLStorage) GetUserByID(id int) (*types.User, error) {
+// Stats returns the connection pool statistics.
+func (store *sqlStorage) Stats() sql.DBStats {
+	return store.db.Stats()
+}
+
+// Close closes the connection pool, waiting for running queries to finish.
+func (store *sqlStorage) Close() error {
+	return store.db.Close()
+}
+
+func (store *sqlStorage) GetUserByID(ctx context.Context, id int) (*types.User, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
 	q := "SELECT * FROM users WHERE id = ?"
-	rows, err := store.db.Query(q, id)
+	rows, err := store.db.QueryContext(ctx, q, id)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
 	u := new(types.User)
 	for rows.Next() {
 		if err := scanRowToUser(rows, u); err != nil {
 			return nil, err
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if u.ID == 0 {
+		return nil, errUserNotFound
+	}
 	return u, nil
 }
 
-func (store *MySQLStorage) GetUserByUsername(username string) (*types.User, error) {
-	q := "SELECT * FROM users WHERE username = ?"
-	rows, err := store.db.Query(q, username)
+func (store *sqlStorage) GetUserByUsername(ctx context.Context, username string) (*types.User, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := "SELECT * FROM users WHERE username" + store.dialect.caseInsensitive() + " = ?"
+	rows, err := store.db.QueryContext(ctx, q, username)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
 	u := new(types.User)
 	for rows.Next() {
 		if err := scanRowToUser(rows, u); err != nil {
 			return nil, err
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
 	if u.ID == 0 {
-		return nil, fmt.Errorf("user not found")
+		return nil, errUserNotFound
 	}
 	return u, nil
 }
 
-func (store *MySQLStorage) CreateUser(u *types.User) error {
+func (store *sqlStorage) CreateUser(ctx context.Context, u *types.User) error {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
 	q := "INSERT INTO users (username, password, userProfile) VALUES (?, ?, ?)"
-	_, err := store.db.Exec(q, u.Username, u.Password, u.UserProfile)
+	res, err := store.db.ExecContext(ctx, q, u.Username, u.Password, u.UserProfile)
+	if store.dialect.isDuplicateEntry(err) {
+		return errs.Conflict("username_taken", fmt.Sprintf("username %s already exists", u.Username))
+	}
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
 	if err != nil {
 		return err
 	}
-	
+	u.ID = int(id)
+	store.searcher.userChanged(ctx, store, u.ID)
 	return nil
 }
 
-func (store *MySQLStorage) UpdateUser(u *types.User) error {
+// UpdateUser updates the password and profile of the user, leaving empty
+// fields unchanged.
+func (store *sqlStorage) UpdateUser(ctx context.Context, u *types.User) error {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
 	var err error
 	if u.Password == "" {
 		q := "UPDATE users SET userProfile = ? WHERE id = ?;"
-		_, err = store.db.Exec(q, u.UserProfile, u.ID)
-		if err != nil {
-			return err
-		}
-	}
-	if u.UserProfile == "" {
+		_, err = store.db.ExecContext(ctx, q, u.UserProfile, u.ID)
+	} else if u.UserProfile == "" {
 		q := "UPDATE users SET password = ? WHERE id = ?;"
-		_, err = store.db.Exec(q, u.Password, u.ID)
-		if err != nil {
-			return err
-		}
+		_, err = store.db.ExecContext(ctx, q, u.Password, u.ID)
+	} else {
+		q := "UPDATE users SET password = ?, userProfile = ? WHERE id = ?;"
+		_, err = store.db.ExecContext(ctx, q, u.Password, u.UserProfile, u.ID)
 	}
-	q := "UPDATE users SET password = ?, userProfile = ? WHERE id = ?;"
-		_, err = store.db.Exec(q, u.Password, u.UserProfile, u.ID)
 	if err != nil {
 		return err
 	}
+
+	if u.UserProfile != "" {
+		store.searcher.userChanged(ctx, store, u.ID)
+	}
 	return nil
 }
 
-func (store *MySQLStorage) CreatePost(p *types.Post) error {
+// CreatePost inserts the post together with its hashtags and mentions in a
+// single transaction.
+func (store *sqlStorage) CreatePost(ctx context.Context, p *types.Post) error {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	tagIDs, err := store.hashtagIDs(ctx, entities.Tags(p.Entities))
+	if err != nil {
+		return err
+	}
+
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	q := "INSERT INTO posts (userID, content) VALUES (?, ?)"
-	_, err := store.db.Exec(q, p.UserID, p.Content)
+	res, err := tx.ExecContext(ctx, q, p.UserID, p.Content)
+	if store.dialect.isForeignKeyViolation(err) {
+		return errUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
 	if err != nil {
 		return err
 	}
-	
+	if err := store.writeEntities(ctx, tx, tagIDs, int(id), 0, p.Entities); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	p.ID = int(id)
+	store.searcher.postChanged(p)
 	return nil
 }
 
-func (store *MySQLStorage) GetPostByID(id int) (*types.Post, error) {
+func (store *sqlStorage) GetPostByID(ctx context.Context, id int) (*types.Post, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
 	q := "SELECT * FROM posts WHERE id = ?"
-	rows, err := store.db.Query(q, id)
+	rows, err := store.db.QueryContext(ctx, q, id)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
 	p := new(types.Post)
 	for rows.Next() {
 		if err := scanRowToPost(rows, p); err != nil {
 			return nil, err
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if p.ID == 0 {
+		return nil, errs.NotFound("post_not_found", "post not found")
+	}
 	return p, nil
 }
 
-func (store *MySQLStorage) UpdatePost(p *types.Post) error {
+// UpdatePost replaces the content of the post and its hashtags and mentions
+// in a single transaction.
+func (store *sqlStorage) UpdatePost(ctx context.Context, p *types.Post) error {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	tagIDs, err := store.hashtagIDs(ctx, entities.Tags(p.Entities))
+	if err != nil {
+		return err
+	}
+
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	q := "UPDATE posts SET content = ? WHERE id = ?"
-	_, err := store.db.Exec(q, p.Content, p.ID)
+	if _, err := tx.ExecContext(ctx, q, p.Content, p.ID); err != nil {
+		return err
+	}
+	if err := store.writeEntities(ctx, tx, tagIDs, p.ID, 0, p.Entities); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	store.searcher.postChanged(p)
+	return nil
+}
+
+const feedPostColumns = `
+	SELECT p.id, p.userID, p.content, p.createdAt,
+		(SELECT COUNT(*) FROM likes l WHERE l.postID = p.id),
+		(SELECT COUNT(*) FROM comments c WHERE c.postID = p.id)
+	FROM posts p`
+
+// GetFeed returns up to limit posts written by the user or by anyone they
+// follow, newest first, starting after the given cursor. A nil cursor starts
+// from the newest post.
+func (store *sqlStorage) GetFeed(ctx context.Context, userID int, cursor *types.Cursor, limit int) ([]*types.FeedPost, error) {
+	q := feedPostColumns + `
+	WHERE (p.userID = ? OR p.userID IN (SELECT followeeID FROM follows WHERE followerID = ?))`
+	args := []any{userID, userID}
+	q, args = store.appendCursorFilter(q, args, "p.createdAt", "p.id", cursor)
+	q += " ORDER BY p.createdAt DESC, p.id DESC LIMIT ?"
+	args = append(args, limit)
+
+	return store.queryFeedPosts(ctx, q, args...)
+}
+
+// GetPostsByUserID returns up to limit posts written by the given user,
+// newest first, starting after the given cursor.
+func (store *sqlStorage) GetPostsByUserID(ctx context.Context, userID int, cursor *types.Cursor, limit int) ([]*types.FeedPost, error) {
+	q := feedPostColumns + " WHERE p.userID = ?"
+	args := []any{userID}
+	q, args = store.appendCursorFilter(q, args, "p.createdAt", "p.id", cursor)
+	q += " ORDER BY p.createdAt DESC, p.id DESC LIMIT ?"
+	args = append(args, limit)
+
+	return store.queryFeedPosts(ctx, q, args...)
+}
+
+func (store *sqlStorage) queryFeedPosts(ctx context.Context, q string, args ...any) ([]*types.FeedPost, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := store.db.QueryContext(ctx, q, args...)
 	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []*types.FeedPost{}
+	for rows.Next() {
+		fp := new(types.FeedPost)
+		if err := scanRowToFeedPost(rows, fp); err != nil {
+			return nil, err
+		}
+		posts = append(posts, fp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// appendCursorFilter restricts a query ordered newest first by (tsCol, idCol)
+// to rows strictly older than the cursor.
+func (store *sqlStorage) appendCursorFilter(q string, args []any, tsCol, idCol string, cursor *types.Cursor) (string, []any) {
+	if cursor == nil {
+		return q, args
+	}
+	ts := store.dialect.timeArg(cursor.CreatedAt)
+	q += fmt.Sprintf(" AND (%[1]s < ? OR (%[1]s = ? AND %[2]s < ?))", tsCol, idCol)
+	return q, append(args, ts, ts, cursor.ID)
+}
+
+// DeletePost removes a post together with its likes and comments in a single
+// transaction.
+func (store *sqlStorage) DeletePost(ctx context.Context, id int) error {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM likes WHERE postID = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM comments WHERE postID = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM posts WHERE id = ?", id); err != nil {
 		return err
 	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	store.searcher.postDeleted(id)
 	return nil
 }
 
-func (store *MySQLStorage) GetPostLikeByUserID(postID, userID int) (*types.PostLike, error) {
+func (store *sqlStorage) GetPostLikeByUserID(ctx context.Context, postID, userID int) (*types.PostLike, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
 	q := "SELECT * FROM likes WHERE postID = ? AND userID = ?"
-	rows, err := store.db.Query(q, postID, userID)
+	rows, err := store.db.QueryContext(ctx, q, postID, userID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
 	like := new(types.PostLike)
 	for rows.Next() {
 		if err := scanRowToPostLike(rows, like); err != nil {
 			return nil, err
 		}
 	}
-	return like, nil
+	return like, rows.Err()
+}
+
+func (store *sqlStorage) GetPostLikeCount(ctx context.Context, postID int) (int, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	var count int
+	err := store.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM likes WHERE postID = ?", postID).Scan(&count)
+	return count, err
+}
+
+// GetPostLikers returns up to limit users who liked the post, most recent
+// like first, starting after the given cursor.
+func (store *sqlStorage) GetPostLikers(ctx context.Context, postID int, cursor *types.Cursor, limit int) ([]*types.PostLiker, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := `
+	SELECT u.id, u.username, u.userProfile, l.id, l.timestamp
+	FROM likes l JOIN users u ON u.id = l.userID
+	WHERE l.postID = ?`
+	args := []any{postID}
+	q, args = store.appendCursorFilter(q, args, "l.timestamp", "l.id", cursor)
+	q += " ORDER BY l.timestamp DESC, l.id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := store.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	likers := []*types.PostLiker{}
+	for rows.Next() {
+		l := new(types.PostLiker)
+		var profile sql.NullString
+		if err := rows.Scan(&l.ID, &l.Username, &profile, &l.LikeID, &l.LikedAt); err != nil {
+			return nil, err
+		}
+		l.UserProfile = profile.String
+		likers = append(likers, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return likers, nil
 }
 
-func (store *MySQLStorage) LikePost(postID, userID int) error {
+func (store *sqlStorage) LikePost(ctx context.Context, postID, userID int) error {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
 	q := "INSERT INTO likes (postID, userID) VALUES (?, ?)"
-	_, err := store.db.Exec(q, postID, userID)
+	_, err := store.db.ExecContext(ctx, q, postID, userID)
+	if store.dialect.isForeignKeyViolation(err) {
+		return errReferenceNotFound
+	}
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (store *MySQLStorage) UnlikePost(postID, userID int) error {
+func (store *sqlStorage) UnlikePost(ctx context.Context, postID, userID int) error {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
 	q := "DELETE FROM likes WHERE postID = ? AND userID = ?"
-	_, err := store.db.Exec(q, postID, userID)
+	_, err := store.db.ExecContext(ctx, q, postID, userID)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (store *MySQLStorage) CommentPost(pc *types.PostComment) error {
+// CommentPost inserts the comment together with its hashtags and mentions
+// in a single transaction.
+func (store *sqlStorage) CommentPost(ctx context.Context, pc *types.PostComment) error {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	tagIDs, err := store.hashtagIDs(ctx, entities.Tags(pc.Entities))
+	if err != nil {
+		return err
+	}
+
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	q := "INSERT INTO comments (postID, userID, content) VALUES (?, ?, ?)"
-	_, err := store.db.Exec(q, pc.PostID, pc.UserID, pc.Content)
+	res, err := tx.ExecContext(ctx, q, pc.PostID, pc.UserID, pc.Content)
+	if store.dialect.isForeignKeyViolation(err) {
+		return errReferenceNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
 	if err != nil {
 		return err
 	}
+	if err := store.writeEntities(ctx, tx, tagIDs, pc.PostID, int(id), pc.Entities); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	pc.ID = int(id)
 	return nil
 }
 
+func (store *sqlStorage) GetFollow(ctx context.Context, followerID, followeeID int) (*types.Follow, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := "SELECT * FROM follows WHERE followerID = ? AND followeeID = ?"
+	rows, err := store.db.QueryContext(ctx, q, followerID, followeeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	f := new(types.Follow)
+	for rows.Next() {
+		if err := scanRowToFollow(rows, f); err != nil {
+			return nil, err
+		}
+	}
+	return f, rows.Err()
+}
+
+func (store *sqlStorage) FollowUser(ctx context.Context, followerID, followeeID int) error {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := "INSERT INTO follows (followerID, followeeID) VALUES (?, ?)"
+	_, err := store.db.ExecContext(ctx, q, followerID, followeeID)
+	if store.dialect.isDuplicateEntry(err) {
+		return errs.Conflict("already_following", "already following user")
+	}
+	if store.dialect.isForeignKeyViolation(err) {
+		return errUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (store *sqlStorage) UnfollowUser(ctx context.Context, followerID, followeeID int) error {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := "DELETE FROM follows WHERE followerID = ? AND followeeID = ?"
+	_, err := store.db.ExecContext(ctx, q, followerID, followeeID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetFollowCounts returns how many users follow the given user and how many
+// users they follow.
+func (store *sqlStorage) GetFollowCounts(ctx context.Context, userID int) (followers int, following int, err error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := `SELECT
+		(SELECT COUNT(*) FROM follows WHERE followeeID = ?),
+		(SELECT COUNT(*) FROM follows WHERE followerID = ?)`
+	err = store.db.QueryRowContext(ctx, q, userID, userID).Scan(&followers, &following)
+	return followers, following, err
+}
+
+// GetFollowers returns up to limit users following the given user, most
+// recent follow first, starting after the given cursor.
+func (store *sqlStorage) GetFollowers(ctx context.Context, userID int, cursor *types.Cursor, limit int) ([]*types.FollowListEntry, error) {
+	q := `
+	SELECT u.id, u.username, u.userProfile, f.id, f.createdAt
+	FROM follows f JOIN users u ON u.id = f.followerID
+	WHERE f.followeeID = ?`
+	return store.queryFollowList(ctx, q, userID, cursor, limit)
+}
+
+// GetFollowing returns up to limit users the given user follows, most recent
+// follow first, starting after the given cursor.
+func (store *sqlStorage) GetFollowing(ctx context.Context, userID int, cursor *types.Cursor, limit int) ([]*types.FollowListEntry, error) {
+	q := `
+	SELECT u.id, u.username, u.userProfile, f.id, f.createdAt
+	FROM follows f JOIN users u ON u.id = f.followeeID
+	WHERE f.followerID = ?`
+	return store.queryFollowList(ctx, q, userID, cursor, limit)
+}
+
+func (store *sqlStorage) queryFollowList(ctx context.Context, q string, userID int, cursor *types.Cursor, limit int) ([]*types.FollowListEntry, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	args := []any{userID}
+	q, args = store.appendCursorFilter(q, args, "f.createdAt", "f.id", cursor)
+	q += " ORDER BY f.createdAt DESC, f.id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := store.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*types.FollowListEntry{}
+	for rows.Next() {
+		e := new(types.FollowListEntry)
+		var profile sql.NullString
+		if err := rows.Scan(&e.ID, &e.Username, &profile, &e.FollowID, &e.FollowedAt); err != nil {
+			return nil, err
+		}
+		e.UserProfile = profile.String
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (store *sqlStorage) GetCommentByID(ctx context.Context, id int) (*types.PostComment, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := "SELECT * FROM comments WHERE id = ?"
+	rows, err := store.db.QueryContext(ctx, q, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pc := new(types.PostComment)
+	for rows.Next() {
+		if err := scanRowToPostComment(rows, pc); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if pc.ID == 0 {
+		return nil, errs.NotFound("comment_not_found", "comment not found")
+	}
+	return pc, nil
+}
+
+// GetPostComments returns up to limit comments on the post with their
+// authors' usernames, newest first, starting after the given cursor.
+func (store *sqlStorage) GetPostComments(ctx context.Context, postID int, cursor *types.Cursor, limit int) ([]*types.PostCommentEntry, error) {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := `
+	SELECT c.id, c.postID, c.userID, c.content, c.timestamp, u.username
+	FROM comments c JOIN users u ON u.id = c.userID
+	WHERE c.postID = ?`
+	args := []any{postID}
+	q, args = store.appendCursorFilter(q, args, "c.timestamp", "c.id", cursor)
+	q += " ORDER BY c.timestamp DESC, c.id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := store.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []*types.PostCommentEntry{}
+	for rows.Next() {
+		c := new(types.PostCommentEntry)
+		if err := rows.Scan(&c.ID, &c.PostID, &c.UserID, &c.Content, &c.Timestamp, &c.Username); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+func (store *sqlStorage) DeleteComment(ctx context.Context, id int) error {
+	ctx, cancel := store.withTimeout(ctx)
+	defer cancel()
+
+	q := "DELETE FROM comments WHERE id = ?"
+	_, err := store.db.ExecContext(ctx, q, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) isDuplicateEntry(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
+
+func (mysqlDialect) isForeignKeyViolation(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1452
+}
+
+func (mysqlDialect) timeArg(t time.Time) any {
+	return t
+}
+
+func (mysqlDialect) name() string {
+	return "mysql"
+}
+
+func (mysqlDialect) tableExistsQuery() string {
+	return "SELECT 1 FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?"
+}
+
+func (mysqlDialect) columnExistsQuery() string {
+	return "SELECT 1 FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?"
+}
+
+// lockMigrations takes a named lock, which MySQL holds for the connection
+// until it is released. DDL statements commit implicitly in MySQL, so a
+// failed migration cannot be rolled back here; it is left dirty instead.
+func (mysqlDialect) lockMigrations(ctx context.Context, conn *sql.Conn) (func(bool) error, error) {
+	var acquired sql.NullInt64
+	err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrationLockName, migrationLockTimeoutInSeconds).Scan(&acquired)
+	if err != nil {
+		return nil, err
+	}
+	if acquired.Int64 != 1 {
+		return nil, errMigrationLocked
+	}
+	return func(bool) error {
+		_, err := conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", migrationLockName)
+		return err
+	}, nil
+}
+
+func (mysqlDialect) newSearcher() searcher {
+	return fulltextSearcher{}
+}
+
+func (mysqlDialect) forUpdate() string {
+	return " FOR UPDATE"
+}
+
+// caseInsensitive needs no clause: the default collation ignores case.
+func (mysqlDialect) caseInsensitive() string {
+	return ""
+}
+
 func scanRowToUser(rows *sql.Rows, u *types.User) error {
 	return rows.Scan(
 		&u.ID,
@@ -264,6 +913,36 @@ func scanRowToPost(rows *sql.Rows, p *types.Post) error {
 	)
 }
 
+func scanRowToFeedPost(rows *sql.Rows, fp *types.FeedPost) error {
+	return rows.Scan(
+		&fp.ID,
+		&fp.UserID,
+		&fp.Content,
+		&fp.CreatedAt,
+		&fp.LikeCount,
+		&fp.CommentCount,
+	)
+}
+
+func scanRowToFollow(rows *sql.Rows, f *types.Follow) error {
+	return rows.Scan(
+		&f.ID,
+		&f.FollowerID,
+		&f.FolloweeID,
+		&f.CreatedAt,
+	)
+}
+
+func scanRowToPostComment(rows *sql.Rows, pc *types.PostComment) error {
+	return rows.Scan(
+		&pc.ID,
+		&pc.PostID,
+		&pc.UserID,
+		&pc.Content,
+		&pc.Timestamp,
+	)
+}
+
 func scanRowToPostLike(rows *sql.Rows, pl *types.PostLike) error {
 	return rows.Scan(
 		&pl.ID,
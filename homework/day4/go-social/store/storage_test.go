@@ -0,0 +1,204 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+
+	"gosocial/errs"
+	"gosocial/types"
+)
+
+// TestSQLiteUsernameIgnoresCase checks that SQLite, like the default MySQL
+// collation, treats usernames differing only in case as the same.
+func TestSQLiteUsernameIgnoresCase(t *testing.T) {
+	s := newTestSQLite(t)
+	ctx := context.Background()
+	alice := &types.User{Username: "Alice", Password: "x"}
+	if err := s.CreateUser(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	err := s.CreateUser(ctx, &types.User{Username: "alice", Password: "x"})
+	var e *errs.Error
+	if !errors.As(err, &e) || e.Code != "username_taken" {
+		t.Errorf("got %v, want username_taken", err)
+	}
+
+	u, err := s.GetUserByUsername(ctx, "ALICE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != alice.ID || u.Username != "Alice" {
+		t.Errorf("got user %d %q, want %d %q", u.ID, u.Username, alice.ID, "Alice")
+	}
+}
+
+// TestPostCursors checks that a cursor resumes after the post it names, and
+// that posts created at the same instant are told apart by their IDs.
+func TestPostCursors(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, s Storage) {
+		ctx := context.Background()
+		first := createTestPost(t, s, "alice")
+		for i := 0; i < 2; i++ {
+			if err := s.CreatePost(ctx, &types.Post{UserID: first.UserID, Content: "post"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		posts, err := s.GetPostsByUserID(ctx, first.UserID, nil, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(posts) != 3 {
+			t.Fatalf("got %d posts, want 3", len(posts))
+		}
+
+		ids := func(cursor types.Cursor, limit int) []int {
+			t.Helper()
+			page, err := s.GetPostsByUserID(ctx, first.UserID, &cursor, limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []int
+			for _, p := range page {
+				ids = append(ids, p.ID)
+			}
+			return ids
+		}
+		middle := posts[1]
+		if got := ids(types.Cursor{CreatedAt: middle.CreatedAt, ID: middle.ID}, 10); !slices.Equal(got, []int{posts[2].ID}) {
+			t.Errorf("got %v after the middle post, want %v", got, []int{posts[2].ID})
+		}
+		// A cursor at the same instant but a higher ID stands for a post
+		// created alongside, which sorts before the middle one.
+		if got := ids(types.Cursor{CreatedAt: middle.CreatedAt, ID: posts[0].ID}, 1); !slices.Equal(got, []int{middle.ID}) {
+			t.Errorf("got %v after a tie with a higher ID, want %v", got, []int{middle.ID})
+		}
+		if got := ids(types.Cursor{CreatedAt: posts[2].CreatedAt, ID: posts[2].ID}, 10); len(got) != 0 {
+			t.Errorf("got %v after the oldest post, want nothing", got)
+		}
+	})
+}
+
+func TestFollowDuplicatesConcurrently(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, s Storage) {
+		ctx := context.Background()
+		var ids []int
+		for _, name := range []string{"alice", "bob"} {
+			u := &types.User{Username: name, Password: "x"}
+			if err := s.CreateUser(ctx, u); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, u.ID)
+		}
+
+		// Requests racing past the check of the handler all reach the
+		// storage, which lets one of them through.
+		const attempts = 8
+		results := make(chan error, attempts)
+		var wg sync.WaitGroup
+		for i := 0; i < attempts; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results <- s.FollowUser(ctx, ids[0], ids[1])
+			}()
+		}
+		wg.Wait()
+		close(results)
+
+		followed := 0
+		for err := range results {
+			var e *errs.Error
+			switch {
+			case err == nil:
+				followed++
+			case !errors.As(err, &e) || e.Code != "already_following":
+				t.Errorf("got %v, want already_following", err)
+			}
+		}
+		followers, following, err := s.GetFollowCounts(ctx, ids[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if followed != 1 || followers != 1 || following != 0 {
+			t.Errorf("%d follows succeeded and bob has %d followers, want 1 and 1", followed, followers)
+		}
+
+		if err := s.FollowUser(ctx, ids[0], 999); !errs.Is(err, errs.KindNotFound) {
+			t.Errorf("got %v following a missing user, want not found", err)
+		}
+	})
+}
+
+func TestDeletePostCascades(t *testing.T) {
+	forEachStorage(t, func(t *testing.T, s Storage) {
+		ctx := context.Background()
+		post := createTestPost(t, s, "alice")
+		other := &types.Post{UserID: post.UserID, Content: "other"}
+		if err := s.CreatePost(ctx, other); err != nil {
+			t.Fatal(err)
+		}
+		bob := &types.User{Username: "bob", Password: "x"}
+		if err := s.CreateUser(ctx, bob); err != nil {
+			t.Fatal(err)
+		}
+		var comments []*types.PostComment
+		for _, p := range []*types.Post{post, other} {
+			if err := s.LikePost(ctx, p.ID, bob.ID); err != nil {
+				t.Fatal(err)
+			}
+			pc := &types.PostComment{PostID: p.ID, UserID: bob.ID, Content: "nice"}
+			if err := s.CommentPost(ctx, pc); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.NotifyComment(ctx, post.UserID, pc); err != nil {
+				t.Fatal(err)
+			}
+			comments = append(comments, pc)
+		}
+
+		if err := s.DeletePost(ctx, post.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.GetPostByID(ctx, post.ID); !errs.Is(err, errs.KindNotFound) {
+			t.Errorf("got %v getting the deleted post, want not found", err)
+		}
+		if _, err := s.GetCommentByID(ctx, comments[0].ID); !errs.Is(err, errs.KindNotFound) {
+			t.Errorf("got %v getting a comment of the deleted post, want not found", err)
+		}
+		if like, err := s.GetPostLikeByUserID(ctx, post.ID, bob.ID); err != nil || like.ID != 0 {
+			t.Errorf("got %+v, %v, want the like of the deleted post gone", like, err)
+		}
+		notifications, err := s.GetNotifications(ctx, post.UserID, nil, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(notifications) != 1 || notifications[0].PostID != other.ID {
+			t.Errorf("got %d notifications, want only the one of the other post", len(notifications))
+		}
+
+		// The other post keeps everything.
+		count, err := s.GetPostLikeCount(ctx, other.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := s.GetPostComments(ctx, other.ID, nil, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 || len(entries) != 1 {
+			t.Errorf("the other post has %d likes and %d comments, want 1 and 1", count, len(entries))
+		}
+
+		// Deleting a comment takes its notification along.
+		if err := s.DeleteComment(ctx, comments[1].ID); err != nil {
+			t.Fatal(err)
+		}
+		if unread, err := s.CountUnreadNotifications(ctx, post.UserID); err != nil || unread != 0 {
+			t.Errorf("got %d unread notifications, %v, want none", unread, err)
+		}
+	})
+}
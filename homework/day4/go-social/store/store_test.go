@@ -0,0 +1,78 @@
+package store
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// forEachStorage runs the test against the memory and SQLite storages, and
+// against MySQL when STORE_TEST_MYSQL_DSN names a scratch database. The
+// MySQL schema is rolled back after the test, dropping its rows. The
+// server must run with --ngram_token_size=1, as in compose.yaml.
+func forEachStorage(t *testing.T, test func(t *testing.T, s Storage)) {
+	t.Run("memory", func(t *testing.T) {
+		s := NewMemoryStorage()
+		if err := s.Init(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		test(t, s)
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		test(t, newTestSQLite(t))
+	})
+	t.Run("mysql", func(t *testing.T) {
+		dsn := os.Getenv("STORE_TEST_MYSQL_DSN")
+		if dsn == "" {
+			t.Skip("STORE_TEST_MYSQL_DSN is not set")
+		}
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.ParseTime = true
+		s, err := NewMySQLStorage(*cfg, PoolConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		if err := s.Init(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			m, err := s.Migrator()
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.Log = io.Discard
+			if err := m.Down(context.Background(), len(m.migrations)); err != nil {
+				t.Error(err)
+			}
+		})
+		test(t, s)
+	})
+}
+
+func newTestSQLite(t *testing.T) *SQLiteStorage {
+	t.Helper()
+	s := openTestSQLite(t, filepath.Join(t.TempDir(), "test.db"))
+	if err := s.Init(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// openTestSQLite opens a database file without migrating it.
+func openTestSQLite(t *testing.T, path string) *SQLiteStorage {
+	t.Helper()
+	s, err := NewSQLiteStorage(path, PoolConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
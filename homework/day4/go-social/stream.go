@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gosocial/configs"
+	"gosocial/errs"
+	"gosocial/logging"
+	"gosocial/stream"
+	"gosocial/types"
+)
+
+// Event types sent on /stream.
+const (
+	eventPostCreated   = "post.created"
+	eventPostLiked     = "post.liked"
+	eventPostUnliked   = "post.unliked"
+	eventPostCommented = "post.commented"
+	// eventResync tells a resuming client that events were missed that the
+	// server no longer has, so it has to reload what it shows.
+	eventResync = "resync"
+	// eventTokenExpired ends a stream whose access token expired. The client
+	// reconnects with a fresh token and the ID of the last event.
+	eventTokenExpired = "token.expired"
+	// eventSessionRevoked ends a stream whose session was revoked by a
+	// logout or a password change. The client has to log in again.
+	eventSessionRevoked = "session.revoked"
+)
+
+// maxStreamFollowees bounds how many followed users a stream receives the
+// new posts of.
+const maxStreamFollowees = 5000
+
+// sseRetryMillis is the reconnection delay suggested to SSE clients.
+const sseRetryMillis = 3000
+
+// postsTopic carries the new posts of a user, activityTopic the likes and
+// comments on their posts.
+func postsTopic(userID int) string    { return "posts:" + strconv.Itoa(userID) }
+func activityTopic(userID int) string { return "activity:" + strconv.Itoa(userID) }
+
+type likeEvent struct {
+	PostID int               `json:"postID"`
+	User   types.UserSummary `json:"user"`
+}
+
+// streamMessage is an event as sent over WebSocket.
+type streamMessage struct {
+	ID   string          `json:"id,omitempty"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// publish sends data as an event of type typ on topic. Publishing never
+// fails the request that caused it; a failure only costs live clients an
+// event they can still load.
+func (s *apiServer) publish(ctx context.Context, topic, typ string, data any) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		logging.FromContext(ctx).Warn("encoding stream event failed", "type", typ, "error", err)
+		return
+	}
+	s.hub.Publish(topic, typ, payload)
+}
+
+// publishPost streams a new post to its author and their followers.
+func (s *apiServer) publishPost(ctx context.Context, post *types.Post) {
+	topic := postsTopic(post.UserID)
+	if !s.hub.Listening(topic) {
+		return
+	}
+	// Read the post back for the fields the database sets.
+	stored, err := s.store.GetPostByID(ctx, post.ID)
+	if err != nil {
+		logging.FromContext(ctx).Warn("loading post for stream failed", "post_id", post.ID, "error", err)
+		return
+	}
+	stored.Entities = post.Entities
+	// Attachments are uploaded after the post is created.
+	stored.Attachments = []*types.Attachment{}
+	s.publish(ctx, topic, eventPostCreated, &types.FeedPost{Post: *stored})
+}
+
+// publishLike streams a like or unlike by actorID to the author of post.
+func (s *apiServer) publishLike(ctx context.Context, post *types.Post, actorID int, liked bool) {
+	topic := activityTopic(post.UserID)
+	if post.UserID == actorID || !s.hub.Listening(topic) {
+		return
+	}
+	actor, err := s.store.GetUserByID(ctx, actorID)
+	if err != nil {
+		logging.FromContext(ctx).Warn("loading user for stream failed", "user_id", actorID, "error", err)
+		return
+	}
+	typ := eventPostLiked
+	if !liked {
+		typ = eventPostUnliked
+	}
+	s.publish(ctx, topic, typ, &likeEvent{
+		PostID: post.ID,
+		User:   types.UserSummary{ID: actor.ID, Username: actor.Username, UserProfile: actor.UserProfile},
+	})
+}
+
+// publishComment streams a new comment to the author of post.
+func (s *apiServer) publishComment(ctx context.Context, post *types.Post, pc *types.PostComment) {
+	topic := activityTopic(post.UserID)
+	if post.UserID == pc.UserID || !s.hub.Listening(topic) {
+		return
+	}
+	stored, err := s.store.GetCommentByID(ctx, pc.ID)
+	if err != nil {
+		logging.FromContext(ctx).Warn("loading comment for stream failed", "comment_id", pc.ID, "error", err)
+		return
+	}
+	author, err := s.store.GetUserByID(ctx, pc.UserID)
+	if err != nil {
+		logging.FromContext(ctx).Warn("loading user for stream failed", "user_id", pc.UserID, "error", err)
+		return
+	}
+	stored.Entities = pc.Entities
+	s.publish(ctx, topic, eventPostCommented, &types.PostCommentEntry{PostComment: *stored, Username: author.Username})
+}
+
+// streamTopics returns the topics of the events the user receives: new
+// posts by them and by the users they follow, as in their feed, and the
+// activity on their posts. Follows made while connected take effect on the
+// next connection.
+func (s *apiServer) streamTopics(ctx context.Context, userID int) ([]string, error) {
+	topics := []string{activityTopic(userID), postsTopic(userID)}
+	var cursor *types.Cursor
+	for len(topics) < maxStreamFollowees+2 {
+		following, err := s.store.GetFollowing(ctx, userID, cursor, int(configs.Envs.MaxPageSize))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range following {
+			topics = append(topics, postsTopic(f.ID))
+		}
+		if len(following) < int(configs.Envs.MaxPageSize) {
+			break
+		}
+		last := following[len(following)-1]
+		cursor = &types.Cursor{CreatedAt: last.FollowedAt, ID: last.FollowID}
+	}
+	return topics[:min(len(topics), maxStreamFollowees+2)], nil
+}
+
+// sessionRevoked reports whether the session of a stream has been revoked
+// or deleted since the stream opened. A failing storage keeps the stream
+// open, as it would not let the client reconnect either.
+func (s *apiServer) sessionRevoked(ctx context.Context, sessionID string) bool {
+	session, err := s.store.GetSessionByID(ctx, sessionID)
+	if errs.Is(err, errs.KindNotFound) {
+		return true
+	}
+	if err != nil {
+		logging.FromContext(ctx).Warn("checking stream session failed", "error", err)
+		return false
+	}
+	return session.RevokedAt != nil
+}
+
+// handleStream pushes the events of the current user as they happen, over
+// Server-Sent Events or, for upgrade requests, WebSocket. Clients resume
+// with the Last-Event-ID header, or the lastEventId parameter where headers
+// cannot be set. A client too slow to keep up is disconnected and resumes
+// the same way.
+func (s *apiServer) handleStream(w http.ResponseWriter, r *http.Request) error {
+	// WithJWTAuth has checked the token; its expiry ends the stream, and so
+	// does revoking its session, which is checked on every heartbeat.
+	claims, err := validateJWT(GetTokenFromRequest(r))
+	if err != nil {
+		return err
+	}
+
+	userID := GetUserIDFromContext(r.Context())
+	topics, err := s.streamTopics(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+
+	sessionID := GetSessionIDFromContext(r.Context())
+	if stream.IsWebSocketRequest(r) {
+		return s.serveWebSocket(w, r, topics, lastEventID, sessionID, claims.ExpiresAt.Time)
+	}
+	return s.serveSSE(w, r, topics, lastEventID, sessionID, claims.ExpiresAt.Time)
+}
+
+// serveSSE streams as Server-Sent Events. Once the response has started,
+// write errors mean the client left and end the stream quietly.
+func (s *apiServer) serveSSE(w http.ResponseWriter, r *http.Request, topics []string, lastEventID, sessionID string, expiresAt time.Time) error {
+	rc := http.NewResponseController(w)
+	writeTimeout := time.Second * time.Duration(configs.Envs.WriteTimeoutInSeconds)
+	write := func(format string, args ...any) error {
+		rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	writeEvent := func(id, typ string, data []byte) error {
+		if id != "" {
+			return write("id: %s\nevent: %s\ndata: %s\n\n", id, typ, data)
+		}
+		return write("event: %s\ndata: %s\n\n", typ, data)
+	}
+
+	sub := s.hub.Subscribe(topics, lastEventID)
+	defer sub.Close()
+	s.metrics.streams.Inc()
+	defer s.metrics.streams.Dec()
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// Keep proxies such as nginx from buffering the stream.
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	logger := logging.FromContext(r.Context())
+	err := write("retry: %d\n\n", sseRetryMillis)
+	if err == nil && sub.Resync {
+		err = writeEvent(sub.Head, eventResync, []byte("{}"))
+	}
+	for _, e := range sub.Backlog {
+		if err == nil {
+			err = writeEvent(e.ID, e.Type, e.Data)
+		}
+	}
+
+	heartbeat := time.NewTicker(s.streamHeartbeat)
+	defer heartbeat.Stop()
+	expiry := time.NewTimer(time.Until(expiresAt))
+	defer expiry.Stop()
+
+	for err == nil {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				logger.Info("stream ended", "reason", sub.Err())
+				return nil
+			}
+			err = writeEvent(e.ID, e.Type, e.Data)
+		case <-heartbeat.C:
+			if s.sessionRevoked(r.Context(), sessionID) {
+				writeEvent("", eventSessionRevoked, []byte("{}"))
+				return nil
+			}
+			err = write(": heartbeat\n\n")
+		case <-expiry.C:
+			writeEvent("", eventTokenExpired, []byte("{}"))
+			return nil
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+	// The client is gone or not reading; it resumes when it reconnects.
+	logger.Info("stream write failed", "error", err)
+	return nil
+}
+
+// serveWebSocket streams over a WebSocket connection. Once the connection
+// is taken over, errors can no longer be answered with a response; they end
+// the connection instead.
+func (s *apiServer) serveWebSocket(w http.ResponseWriter, r *http.Request, topics []string, lastEventID, sessionID string, expiresAt time.Time) error {
+	ws, err := stream.Upgrade(w, r)
+	if errors.Is(err, stream.ErrBadHandshake) {
+		return errs.BadRequest("invalid_handshake", "invalid websocket handshake")
+	}
+	if err != nil {
+		return err
+	}
+
+	sub := s.hub.Subscribe(topics, lastEventID)
+	defer sub.Close()
+	s.metrics.streams.Inc()
+	defer s.metrics.streams.Dec()
+
+	writeTimeout := time.Second * time.Duration(configs.Envs.WriteTimeoutInSeconds)
+	send := func(id, typ string, data []byte) error {
+		msg, err := json.Marshal(&streamMessage{ID: id, Type: typ, Data: data})
+		if err != nil {
+			return err
+		}
+		return ws.WriteText(msg, time.Now().Add(writeTimeout))
+	}
+
+	logger := logging.FromContext(r.Context())
+	if sub.Resync {
+		err = send(sub.Head, eventResync, []byte("{}"))
+	}
+	for _, e := range sub.Backlog {
+		if err == nil {
+			err = send(e.ID, e.Type, e.Data)
+		}
+	}
+
+	// The reader notices the client leaving, or going silent for two
+	// heartbeats without answering a ping.
+	readDone := make(chan error, 1)
+	go func() { readDone <- ws.ReadLoop(2 * s.streamHeartbeat) }()
+
+	heartbeat := time.NewTicker(s.streamHeartbeat)
+	defer heartbeat.Stop()
+	expiry := time.NewTimer(time.Until(expiresAt))
+	defer expiry.Stop()
+
+	for err == nil {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				logger.Info("stream ended", "reason", sub.Err())
+				if errors.Is(sub.Err(), stream.ErrSlowSubscriber) {
+					ws.Close(stream.CloseTryAgainLater, "too slow")
+				} else {
+					ws.Close(stream.CloseGoingAway, "")
+				}
+				return nil
+			}
+			err = send(e.ID, e.Type, e.Data)
+		case <-heartbeat.C:
+			if s.sessionRevoked(r.Context(), sessionID) {
+				send("", eventSessionRevoked, []byte("{}"))
+				ws.Close(stream.ClosePolicy, "session revoked")
+				return nil
+			}
+			err = ws.Ping(time.Now().Add(writeTimeout))
+		case <-expiry.C:
+			send("", eventTokenExpired, []byte("{}"))
+			ws.Close(stream.ClosePolicy, "token expired")
+			return nil
+		case err = <-readDone:
+			ws.Close(stream.CloseNormal, "")
+			if err == nil {
+				return nil
+			}
+		}
+	}
+	logger.Info("stream write failed", "error", err)
+	ws.Close(stream.CloseGoingAway, "")
+	return nil
+}
@@ -0,0 +1,215 @@
+// Package stream fans events out to the live connections of one process.
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrSlowSubscriber ends a subscription whose buffer filled up. The
+	// subscriber can resume from the last event it received.
+	ErrSlowSubscriber = errors.New("subscriber fell behind")
+	// ErrHubClosed ends every subscription when the hub closes.
+	ErrHubClosed = errors.New("hub closed")
+)
+
+// Event is a published event. IDs are unique to the hub: they start with
+// the time the hub was created, so that IDs from before a restart are
+// recognized as unknown instead of being mistaken for recent ones.
+type Event struct {
+	ID    string
+	Type  string
+	Topic string
+	Data  []byte
+
+	seq uint64
+}
+
+// Hub delivers events published on a topic to the subscribers of the
+// topic. It keeps the most recent events so that subscribers can resume
+// after a disconnect.
+type Hub struct {
+	mu      sync.Mutex
+	epoch   string
+	seq     uint64
+	history []Event // ring buffer of the last len(history) events
+	buffer  int
+	topics  map[string]map[*Subscription]struct{}
+	closed  bool
+}
+
+// NewHub returns a hub that remembers the last historySize events and
+// buffers up to bufferSize events per subscriber.
+func NewHub(historySize, bufferSize int) *Hub {
+	return &Hub{
+		epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+		history: make([]Event, historySize),
+		buffer:  bufferSize,
+		topics:  map[string]map[*Subscription]struct{}{},
+	}
+}
+
+// Publish sends an event to the subscribers of topic and returns it. A
+// subscriber whose buffer is full is dropped with ErrSlowSubscriber rather
+// than holding up the publisher.
+func (h *Hub) Publish(topic, typ string, data []byte) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	e := Event{ID: h.id(h.seq), Type: typ, Topic: topic, Data: data, seq: h.seq}
+	if len(h.history) > 0 {
+		h.history[h.seq%uint64(len(h.history))] = e
+	}
+	for sub := range h.topics[topic] {
+		select {
+		case sub.ch <- e:
+		default:
+			h.drop(sub, ErrSlowSubscriber)
+		}
+	}
+	return e
+}
+
+// Listening reports whether topic has subscribers, so that publishers can
+// skip building events nobody receives.
+func (h *Hub) Listening(topic string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.topics[topic]) > 0
+}
+
+// Subscribe subscribes to topics. With a lastEventID, the events of the
+// topics published after it are returned in the subscription's Backlog; if
+// the hub no longer has all of them, Resync is set instead.
+func (h *Hub) Subscribe(topics []string, lastEventID string) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := &Subscription{hub: h, topics: topics, ch: make(chan Event, h.buffer)}
+	sub.C = sub.ch
+	if h.closed {
+		sub.err = ErrHubClosed
+		close(sub.ch)
+		return sub
+	}
+
+	if h.seq > 0 {
+		sub.Head = h.id(h.seq)
+	}
+	if lastEventID != "" {
+		sub.Backlog, sub.Resync = h.since(lastEventID, topics)
+	}
+	for _, topic := range topics {
+		if h.topics[topic] == nil {
+			h.topics[topic] = map[*Subscription]struct{}{}
+		}
+		h.topics[topic][sub] = struct{}{}
+	}
+	return sub
+}
+
+func (h *Hub) id(seq uint64) string {
+	return fmt.Sprintf("%s-%d", h.epoch, seq)
+}
+
+// since returns the remembered events of topics published after the event
+// with the given ID, and false if some of them may have been forgotten.
+// Callers hold mu.
+func (h *Hub) since(id string, topics []string) ([]Event, bool) {
+	epoch, seqStr, _ := strings.Cut(id, "-")
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	oldest := h.seq - min(h.seq, uint64(len(h.history))) + 1
+	if epoch != h.epoch || err != nil || seq > h.seq || seq+1 < oldest {
+		return nil, true
+	}
+
+	var backlog []Event
+	for s := seq + 1; s <= h.seq; s++ {
+		e := h.history[s%uint64(len(h.history))]
+		for _, topic := range topics {
+			if e.Topic == topic {
+				backlog = append(backlog, e)
+				break
+			}
+		}
+	}
+	return backlog, false
+}
+
+// Close ends every subscription with ErrHubClosed. Later subscriptions end
+// immediately.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for _, subs := range h.topics {
+		for sub := range subs {
+			h.drop(sub, ErrHubClosed)
+		}
+	}
+}
+
+// drop ends a subscription. Callers hold mu.
+func (h *Hub) drop(sub *Subscription, err error) {
+	if sub.err != nil {
+		return
+	}
+	sub.err = err
+	for _, topic := range sub.topics {
+		delete(h.topics[topic], sub)
+		if len(h.topics[topic]) == 0 {
+			delete(h.topics, topic)
+		}
+	}
+	close(sub.ch)
+}
+
+// Subscription receives the events of its topics on C, which is closed when
+// the subscription ends.
+type Subscription struct {
+	C <-chan Event
+	// Backlog holds the events missed since the last event ID given to
+	// Subscribe. They precede the events on C.
+	Backlog []Event
+	// Resync reports that the missed events are no longer known, so the
+	// subscriber has to reload its state.
+	Resync bool
+	// Head is the ID of the newest event of the hub when the subscription
+	// started, or "" if there was none. Resuming from it skips the backlog.
+	Head string
+
+	hub    *Hub
+	topics []string
+	ch     chan Event
+	err    error
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	s.hub.drop(s, errClosed)
+}
+
+// Err returns why the subscription ended once C is closed: ErrSlowSubscriber,
+// ErrHubClosed, or nil if it was closed by its owner.
+func (s *Subscription) Err() error {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	if s.err == errClosed {
+		return nil
+	}
+	return s.err
+}
+
+var errClosed = errors.New("subscription closed")
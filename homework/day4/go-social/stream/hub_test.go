@@ -0,0 +1,92 @@
+package stream
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHub(t *testing.T) {
+	h := NewHub(4, 4)
+	a := h.Subscribe([]string{"a"}, "")
+	ab := h.Subscribe([]string{"a", "b"}, "")
+
+	h.Publish("a", "x", []byte("1"))
+	h.Publish("b", "y", []byte("2"))
+	h.Publish("c", "z", []byte("3"))
+	if h.Listening("c") || !h.Listening("b") {
+		t.Error("Listening does not match the subscriptions")
+	}
+
+	if e := <-a.C; e.Type != "x" || string(e.Data) != "1" {
+		t.Errorf("a got %+v", e)
+	}
+	if len(a.C) != 0 {
+		t.Errorf("a got %d events of other topics", len(a.C))
+	}
+	for _, want := range []string{"x", "y"} {
+		if e := <-ab.C; e.Type != want {
+			t.Errorf("ab got %q, want %q", e.Type, want)
+		}
+	}
+
+	a.Close()
+	if _, ok := <-a.C; ok || a.Err() != nil {
+		t.Errorf("closed subscription: got err %v", a.Err())
+	}
+	ab.Close()
+	if h.Listening("a") {
+		t.Error("topic still has listeners after every subscription closed")
+	}
+}
+
+func TestHubResume(t *testing.T) {
+	h := NewHub(3, 4)
+	first := h.Publish("a", "x", nil)
+	h.Publish("b", "x", nil)
+	third := h.Publish("a", "x", nil)
+
+	sub := h.Subscribe([]string{"a"}, first.ID)
+	if sub.Resync || len(sub.Backlog) != 1 || sub.Backlog[0].ID != third.ID || sub.Head != third.ID {
+		t.Errorf("got backlog %+v, resync %v, head %q", sub.Backlog, sub.Resync, sub.Head)
+	}
+
+	// Resuming from the newest event has nothing to replay.
+	if sub := h.Subscribe([]string{"a"}, third.ID); sub.Resync || len(sub.Backlog) != 0 {
+		t.Errorf("got backlog %+v, resync %v", sub.Backlog, sub.Resync)
+	}
+
+	// The first event has left the history once two more are published.
+	h.Publish("a", "x", nil)
+	h.Publish("a", "x", nil)
+	for _, id := range []string{first.ID, "garbage", "0-1", NewHub(3, 4).Publish("a", "x", nil).ID} {
+		if sub := h.Subscribe([]string{"a"}, id); !sub.Resync || len(sub.Backlog) != 0 {
+			t.Errorf("resuming from %q: got backlog %+v, resync %v", id, sub.Backlog, sub.Resync)
+		}
+	}
+}
+
+func TestHubSlowSubscriber(t *testing.T) {
+	h := NewHub(0, 1)
+	slow := h.Subscribe([]string{"a"}, "")
+	fast := h.Subscribe([]string{"a"}, "")
+
+	h.Publish("a", "x", nil)
+	<-fast.C
+	h.Publish("a", "x", nil)
+
+	<-slow.C
+	if _, ok := <-slow.C; ok || !errors.Is(slow.Err(), ErrSlowSubscriber) {
+		t.Errorf("slow subscriber: got err %v, want ErrSlowSubscriber", slow.Err())
+	}
+	if e, ok := <-fast.C; !ok || fast.Err() != nil {
+		t.Errorf("fast subscriber: got %+v, err %v", e, fast.Err())
+	}
+
+	h.Close()
+	if _, ok := <-fast.C; ok || !errors.Is(fast.Err(), ErrHubClosed) {
+		t.Errorf("after Close: got err %v, want ErrHubClosed", fast.Err())
+	}
+	if sub := h.Subscribe([]string{"a"}, ""); !errors.Is(sub.Err(), ErrHubClosed) {
+		t.Errorf("subscribing to a closed hub: got err %v", sub.Err())
+	}
+}
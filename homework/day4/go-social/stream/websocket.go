@@ -0,0 +1,137 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The server side of WebSocket streams, which only push text messages:
+// messages from the client are read and discarded, pings are answered and
+// closes are acknowledged.
+
+// maxClientMessage bounds the messages read from clients, which have
+// nothing to send but control frames.
+const maxClientMessage = 4096
+
+// Close codes.
+const (
+	CloseNormal        = websocket.CloseNormalClosure
+	CloseGoingAway     = websocket.CloseGoingAway
+	CloseProtocolError = websocket.CloseProtocolError
+	ClosePolicy        = websocket.ClosePolicyViolation
+	CloseTooBig        = websocket.CloseMessageTooBig
+	CloseTryAgainLater = websocket.CloseTryAgainLater
+)
+
+// ErrBadHandshake is returned by Upgrade for requests that are not a valid
+// WebSocket handshake.
+var ErrBadHandshake = errors.New("bad websocket handshake")
+
+// IsWebSocketRequest reports whether r asks to upgrade to WebSocket.
+func IsWebSocketRequest(r *http.Request) bool {
+	return websocket.IsWebSocketUpgrade(r)
+}
+
+// WebSocket is a server-side WebSocket connection. Writes may be called
+// concurrently with ReadLoop.
+type WebSocket struct {
+	conn *websocket.Conn
+}
+
+// Upgrade completes the handshake of r and takes over its connection. It
+// returns ErrBadHandshake, without writing a response, if r is not a valid
+// handshake.
+func Upgrade(w http.ResponseWriter, r *http.Request) (*WebSocket, error) {
+	var status int
+	upgrader := websocket.Upgrader{
+		// Streams authenticate with a token rather than cookies, so pages
+		// of other origins cannot open one on behalf of a user.
+		CheckOrigin: func(*http.Request) bool { return true },
+		// Leave the response to the caller.
+		Error: func(_ http.ResponseWriter, _ *http.Request, s int, _ error) { status = s },
+	}
+	conn, err := upgrader.Upgrade(hijacker(w), r, nil)
+	if err != nil {
+		if status >= 400 && status < 500 {
+			return nil, fmt.Errorf("%w: %v", ErrBadHandshake, err)
+		}
+		return nil, err
+	}
+	return &WebSocket{conn: conn}, nil
+}
+
+// hijacker returns the writer w wraps that can take over the connection,
+// as the upgrader does not look through wrapping writers.
+func hijacker(w http.ResponseWriter) http.ResponseWriter {
+	for {
+		if _, ok := w.(http.Hijacker); ok {
+			return w
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return w
+		}
+		w = u.Unwrap()
+	}
+}
+
+// WriteText sends a text message, failing if it cannot be written by the
+// deadline.
+func (ws *WebSocket) WriteText(data []byte, deadline time.Time) error {
+	ws.conn.SetWriteDeadline(deadline)
+	return ws.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// Ping sends a ping, which the client answers with a pong that ReadLoop
+// counts as activity.
+func (ws *WebSocket) Ping(deadline time.Time) error {
+	return ws.conn.WriteControl(websocket.PingMessage, nil, deadline)
+}
+
+// Close sends a close message with the given code and closes the
+// connection without waiting for the client to acknowledge.
+func (ws *WebSocket) Close(code int, reason string) error {
+	ws.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	return ws.conn.Close()
+}
+
+// ReadLoop reads from the client until it closes the connection or the
+// connection fails, answering pings and discarding messages. A client that
+// sends nothing, not even a pong, for idle is disconnected. It returns nil
+// when the client closed the connection.
+func (ws *WebSocket) ReadLoop(idle time.Duration) error {
+	ws.conn.SetReadLimit(maxClientMessage)
+	extend := func() { ws.conn.SetReadDeadline(time.Now().Add(idle)) }
+	ping := ws.conn.PingHandler()
+	ws.conn.SetPingHandler(func(data string) error {
+		extend()
+		return ping(data)
+	})
+	ws.conn.SetPongHandler(func(string) error {
+		extend()
+		return nil
+	})
+
+	for {
+		extend()
+		_, r, err := ws.conn.NextReader()
+		if err == nil {
+			_, err = io.Copy(io.Discard, r)
+		}
+		if err != nil {
+			// The library has answered closes, messages over the limit and
+			// protocol errors with a close message of its own.
+			ws.conn.Close()
+			var closed *websocket.CloseError
+			if errors.As(err, &closed) {
+				return nil
+			}
+			return err
+		}
+	}
+}
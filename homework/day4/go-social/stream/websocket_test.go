@@ -0,0 +1,214 @@
+package stream
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wrappedWriter hides the Hijacker of the writer it wraps, as the logging
+// and metrics middleware do.
+type wrappedWriter struct {
+	w http.ResponseWriter
+}
+
+func (w *wrappedWriter) Header() http.Header         { return w.w.Header() }
+func (w *wrappedWriter) Write(b []byte) (int, error) { return w.w.Write(b) }
+func (w *wrappedWriter) WriteHeader(status int)      { w.w.WriteHeader(status) }
+func (w *wrappedWriter) Unwrap() http.ResponseWriter { return w.w }
+
+// testConn is the client side of a connection to a server running ReadLoop.
+type testConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	done chan error
+}
+
+func dialTestServer(t *testing.T) *testConn {
+	t.Helper()
+	done := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := Upgrade(&wrappedWriter{w}, r)
+		if err != nil {
+			done <- err
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		done <- ws.ReadLoop(time.Second)
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got %d, want a switch to websocket", resp.StatusCode)
+	}
+	return &testConn{t: t, conn: conn, r: r, done: done}
+}
+
+// write sends a frame, masked as clients must unless masked is false.
+func (c *testConn) write(fin bool, opcode byte, payload []byte, masked bool) {
+	c.t.Helper()
+	head := opcode
+	if fin {
+		head |= 0x80
+	}
+	frame := []byte{head}
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+	if n := len(payload); n < 126 {
+		frame = append(frame, maskBit|byte(n))
+	} else {
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	}
+	payload = append([]byte(nil), payload...)
+	if masked {
+		mask := []byte{1, 2, 3, 4}
+		frame = append(frame, mask...)
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	if _, err := c.conn.Write(append(frame, payload...)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// read returns the opcode and payload of the next server frame.
+func (c *testConn) read() (byte, []byte) {
+	c.t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		c.t.Fatal(err)
+	}
+	payload := make([]byte, head[1]&0x7F)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		c.t.Fatal(err)
+	}
+	return head[0] & 0x0F, payload
+}
+
+// expectClose reads the close frame of the server and its code.
+func (c *testConn) expectClose(code int) {
+	c.t.Helper()
+	opcode, payload := c.read()
+	if opcode != 0x8 || len(payload) < 2 || int(binary.BigEndian.Uint16(payload)) != code {
+		c.t.Fatalf("got opcode %#x with %q, want a close with code %d", opcode, payload, code)
+	}
+}
+
+func closePayload(code int) []byte {
+	return binary.BigEndian.AppendUint16(nil, uint16(code))
+}
+
+func TestWebSocketFragmentedMessages(t *testing.T) {
+	c := dialTestServer(t)
+
+	// A message in fragments is discarded, with a ping between them
+	// answered.
+	c.write(false, 0x1, []byte("hel"), true)
+	c.write(true, 0x9, []byte("ping"), true)
+	c.write(false, 0x0, []byte("lo "), true)
+	c.write(true, 0x0, []byte("there"), true)
+	if opcode, payload := c.read(); opcode != 0xA || string(payload) != "ping" {
+		t.Fatalf("got opcode %#x with %q, want the pong", opcode, payload)
+	}
+
+	c.write(true, 0x8, closePayload(CloseGoingAway), true)
+	c.expectClose(CloseGoingAway)
+	if err := <-c.done; err != nil {
+		t.Errorf("got %v, want a normal end", err)
+	}
+}
+
+func TestWebSocketOversizedMessage(t *testing.T) {
+	for name, fragments := range map[string][][]byte{
+		"single":     {make([]byte, maxClientMessage+1)},
+		"fragmented": {make([]byte, maxClientMessage/2), make([]byte, maxClientMessage/2), []byte("x")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := dialTestServer(t)
+			for i, payload := range fragments {
+				opcode := byte(0x0)
+				if i == 0 {
+					opcode = 0x2
+				}
+				c.write(i == len(fragments)-1, opcode, payload, true)
+			}
+			c.expectClose(CloseTooBig)
+			if err := <-c.done; err == nil {
+				t.Error("got a normal end, want an error")
+			}
+		})
+	}
+}
+
+func TestWebSocketUnmaskedFrame(t *testing.T) {
+	c := dialTestServer(t)
+	c.write(true, 0x1, []byte("hello"), false)
+	c.expectClose(CloseProtocolError)
+	if err := <-c.done; err == nil {
+		t.Error("got a normal end, want an error")
+	}
+}
+
+func TestWebSocketIdle(t *testing.T) {
+	c := dialTestServer(t)
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Errorf("got %v, want the silent client disconnected", err)
+	}
+	if err := <-c.done; err == nil {
+		t.Error("got a normal end, want a timeout")
+	}
+}
+
+func TestWebSocketBadHandshake(t *testing.T) {
+	var got error
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, got = Upgrade(w, r)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !errors.Is(got, ErrBadHandshake) || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got %v and %d, want a bad handshake left to the caller", got, resp.StatusCode)
+	}
+}
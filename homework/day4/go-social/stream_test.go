@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gosocial/blob"
+	"gosocial/store"
+	"gosocial/stream"
+	"gosocial/types"
+)
+
+// newStreamTestClient is newTestClient with a short stream heartbeat. The
+// hub is closed before the server, which otherwise waits for open streams.
+func newStreamTestClient(t *testing.T) *testClient {
+	t.Helper()
+	storage := store.NewMemoryStorage()
+	if err := storage.Init(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewAPIServer("", storage, blob.NewLocalStore(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.streamHeartbeat = 50 * time.Millisecond
+	srv := httptest.NewServer(s.routes())
+	t.Cleanup(srv.Close)
+	t.Cleanup(s.hub.Close)
+	return &testClient{t: t, srv: srv}
+}
+
+type sseEvent struct {
+	id, typ, data string
+}
+
+// sseStream reads the events of an SSE response.
+type sseStream struct {
+	t    *testing.T
+	body io.ReadCloser
+	r    *bufio.Reader
+}
+
+// openSSE opens a stream, authenticating with the token parameter as
+// EventSource clients do.
+func (c *testClient) openSSE(token, lastEventID string) *sseStream {
+	c.t.Helper()
+	req, err := http.NewRequest(http.MethodGet, c.srv.URL+"/stream?token="+token, nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := c.srv.Client().Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		c.t.Fatalf("got %d %q, want an event stream", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	c.t.Cleanup(func() { resp.Body.Close() })
+	return &sseStream{t: c.t, body: resp.Body, r: bufio.NewReader(resp.Body)}
+}
+
+// next returns the next event, skipping heartbeats and the retry field.
+func (s *sseStream) next() sseEvent {
+	s.t.Helper()
+	var e sseEvent
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			s.t.Fatalf("reading stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if e.typ != "" {
+				return e
+			}
+			continue
+		}
+		field, value, _ := strings.Cut(line, ": ")
+		switch field {
+		case "id":
+			e.id = value
+		case "event":
+			e.typ = value
+		case "data":
+			e.data = value
+		}
+	}
+}
+
+func TestStreamSSE(t *testing.T) {
+	c := newStreamTestClient(t)
+	alice, _ := c.signup("alice")
+	bob, bobID := c.signup("bob")
+	c.expect(http.StatusOK, http.MethodPost, "/users/1/follow", bob.Token, nil, nil)
+
+	aliceStream := c.openSSE(alice.Token, "")
+	bobStream := c.openSSE(bob.Token, "")
+
+	c.createPost(alice.Token, "hello #go")
+	for name, s := range map[string]*sseStream{"alice": aliceStream, "bob": bobStream} {
+		e := s.next()
+		var post types.FeedPost
+		if err := json.Unmarshal([]byte(e.data), &post); err != nil {
+			t.Fatal(err)
+		}
+		if e.typ != eventPostCreated || e.id == "" || post.ID != 1 || post.Content != "hello #go" || len(post.Entities) != 1 {
+			t.Errorf("%s got %+v, want alice's post", name, e)
+		}
+	}
+
+	// Only the author hears about activity, and not about their own.
+	c.expect(http.StatusOK, http.MethodPost, "/posts/1/like", alice.Token, nil, nil)
+	c.expect(http.StatusOK, http.MethodPost, "/posts/1/like", bob.Token, nil, nil)
+	e := aliceStream.next()
+	var like likeEvent
+	if err := json.Unmarshal([]byte(e.data), &like); err != nil {
+		t.Fatal(err)
+	}
+	if e.typ != eventPostLiked || like.PostID != 1 || like.User.ID != bobID {
+		t.Errorf("got %+v, want bob's like", e)
+	}
+
+	c.expect(http.StatusOK, http.MethodPost, "/posts/1/comment", bob.Token, map[string]string{"content": "nice"}, nil)
+	e = aliceStream.next()
+	var comment types.PostCommentEntry
+	if err := json.Unmarshal([]byte(e.data), &comment); err != nil {
+		t.Fatal(err)
+	}
+	if e.typ != eventPostCommented || comment.Content != "nice" || comment.Username != "bob" {
+		t.Errorf("got %+v, want bob's comment", e)
+	}
+
+	// Bob's posts reach him but not alice, who does not follow him.
+	c.createPost(bob.Token, "bob's post")
+	if e := bobStream.next(); e.typ != eventPostCreated || !strings.Contains(e.data, "bob's post") {
+		t.Errorf("bob got %+v, want his post", e)
+	}
+	c.createPost(alice.Token, "second")
+	if e := aliceStream.next(); !strings.Contains(e.data, "second") {
+		t.Errorf("alice got %+v, want her second post", e)
+	}
+}
+
+func TestStreamResume(t *testing.T) {
+	c := newStreamTestClient(t)
+	alice, _ := c.signup("alice")
+	bob, _ := c.signup("bob")
+
+	s := c.openSSE(alice.Token, "")
+	c.createPost(alice.Token, "one")
+	last := s.next()
+	s.body.Close()
+
+	c.createPost(alice.Token, "two")
+	c.createPost(bob.Token, "not followed")
+	c.expect(http.StatusOK, http.MethodPost, "/posts/1/like", bob.Token, nil, nil)
+
+	// Missed events come back in order, without bob's post.
+	s = c.openSSE(alice.Token, last.id)
+	if e := s.next(); e.typ != eventPostCreated || !strings.Contains(e.data, `"two"`) {
+		t.Fatalf("got %+v, want the missed post", e)
+	}
+	if e := s.next(); e.typ != eventPostLiked {
+		t.Fatalf("got %+v, want the missed like", e)
+	}
+
+	// An unknown ID asks the client to reload.
+	s = c.openSSE(alice.Token, "unknown-1")
+	if e := s.next(); e.typ != eventResync || e.id == "" {
+		t.Errorf("got %+v, want a resync", e)
+	}
+}
+
+func TestStreamEndsOnLogout(t *testing.T) {
+	c := newStreamTestClient(t)
+	alice, _ := c.signup("alice")
+	other := new(types.TokenResponse)
+	c.expect(http.StatusOK, http.MethodPost, "/login", "", map[string]string{"username": "alice", "password": "password123"}, other)
+
+	s := c.openSSE(alice.Token, "")
+	c.expect(http.StatusOK, http.MethodPost, "/logout", alice.Token, nil, nil)
+	if e := s.next(); e.typ != eventSessionRevoked {
+		t.Fatalf("got %+v, want the stream ended", e)
+	}
+	if _, err := s.r.ReadString('\n'); err != io.EOF {
+		t.Errorf("got %v, want the stream closed", err)
+	}
+
+	// Streams of the user's other sessions go on.
+	s = c.openSSE(other.Token, "")
+	c.createPost(other.Token, "still here")
+	if e := s.next(); e.typ != eventPostCreated {
+		t.Errorf("got %+v, want the new post", e)
+	}
+}
+
+func TestStreamAuth(t *testing.T) {
+	c := newStreamTestClient(t)
+	c.expectError(http.StatusUnauthorized, "invalid_token", http.MethodGet, "/stream", "", nil)
+}
+
+func TestStreamWebSocket(t *testing.T) {
+	c := newStreamTestClient(t)
+	alice, _ := c.signup("alice")
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(c.srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req, _ := http.NewRequest(http.MethodGet, c.srv.URL+"/stream?token="+alice.Token, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") == "" {
+		t.Fatalf("got %d %v, want a switch to websocket", resp.StatusCode, resp.Header)
+	}
+
+	// readFrame reads an unmasked server frame.
+	readFrame := func() (byte, []byte) {
+		t.Helper()
+		var head [2]byte
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			t.Fatal(err)
+		}
+		n := int(head[1] & 0x7F)
+		if n == 126 {
+			var ext [2]byte
+			io.ReadFull(r, ext[:])
+			n = int(binary.BigEndian.Uint16(ext[:]))
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			t.Fatal(err)
+		}
+		return head[0] & 0x0F, payload
+	}
+
+	c.createPost(alice.Token, "over websocket")
+	var msg streamMessage
+	for {
+		opcode, payload := readFrame()
+		if opcode == 0x9 { // ping
+			continue
+		}
+		if opcode != 0x1 {
+			t.Fatalf("got opcode %#x, want a text message", opcode)
+		}
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			t.Fatal(err)
+		}
+		break
+	}
+	if msg.Type != eventPostCreated || msg.ID == "" || !strings.Contains(string(msg.Data), "over websocket") {
+		t.Errorf("got %+v, want the new post", msg)
+	}
+
+	// A masked close frame is answered with a close frame.
+	mask := []byte{1, 2, 3, 4}
+	payload := binary.BigEndian.AppendUint16(nil, stream.CloseNormal)
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	frame := append([]byte{0x88, 0x80 | byte(len(payload))}, mask...)
+	conn.Write(append(frame, payload...))
+	for {
+		opcode, payload := readFrame()
+		if opcode == 0x8 {
+			if code := binary.BigEndian.Uint16(payload); code != stream.CloseNormal {
+				t.Errorf("got close code %d, want %d", code, stream.CloseNormal)
+			}
+			break
+		}
+	}
+
+	// A plain request with an upgrade header but no key is rejected.
+	req, _ = http.NewRequest(http.MethodGet, c.srv.URL+"/stream", nil)
+	req.Header.Set("Authorization", alice.Token)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	res, err := c.srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("got %d for a bad handshake, want 400", res.StatusCode)
+	}
+}
@@ -3,27 +3,29 @@ package types
 import "time"
 
 type UserSignupRequest struct {
-	Username    string `json:"username"`
-	Password    string `json:"password"`
-	UserProfile string `json:"userProfile"`
+	Username    string `json:"username" validate:"required,min=3,max=50,charset=username"`
+	Password    string `json:"password" validate:"required,min=8,maxbytes=72"`
+	UserProfile string `json:"userProfile" validate:"max=255,charset=text"`
 }
 
 type UserLoginRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username string `json:"username" validate:"required,max=50"`
+	Password string `json:"password" validate:"required,maxbytes=72"`
 }
 
 type UserUpdateRequest struct {
-	Password    string `json:"password"`
-	UserProfile string `json:"userProfile"`
+	Password    string `json:"password" validate:"min=8,maxbytes=72"`
+	UserProfile string `json:"userProfile" validate:"max=255,charset=text"`
 }
 
 type User struct {
-	ID          int       `json:"id"`
-	Username    string    `json:"username"`
-	Password    string    `json:"-"`
-	UserProfile string    `json:"userProfile"`
-	CreatedAt   time.Time `json:"createdAt"`
+	ID             int       `json:"id"`
+	Username       string    `json:"username"`
+	Password       string    `json:"-"`
+	UserProfile    string    `json:"userProfile"`
+	CreatedAt      time.Time `json:"createdAt"`
+	FollowerCount  int       `json:"followerCount"`
+	FollowingCount int       `json:"followingCount"`
 }
 
 func NewUser(username, password, profile string) *User {
@@ -34,51 +36,257 @@ func NewUser(username, password, profile string) *User {
 	}
 }
 
+// UserSummary is the public part of a user shown in lists.
+type UserSummary struct {
+	ID          int    `json:"id"`
+	Username    string `json:"username"`
+	UserProfile string `json:"userProfile"`
+}
+
+type Follow struct {
+	ID         int
+	FollowerID int
+	FolloweeID int
+	CreatedAt  time.Time
+}
+
+// FollowListEntry is a user in a followers or following list.
+type FollowListEntry struct {
+	UserSummary
+	FollowID   int       `json:"-"`
+	FollowedAt time.Time `json:"followedAt"`
+}
+
 type PostCreateRequest struct {
-	Content string
+	Content string `json:"content" validate:"required,max=5000,charset=text"`
 }
 
 type PostUpdateRequest struct {
-	Content string
+	Content string `json:"content" validate:"required,max=5000,charset=text"`
 }
 
 type PostCommentRequest struct {
-	Content string
+	Content string `json:"content" validate:"required,max=1000,charset=text"`
 }
 
 type Post struct {
-	ID        int
-	UserID    int
-	Content   string
-	CreatedAt time.Time
+	ID        int       `json:"id"`
+	UserID    int       `json:"userID"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Entities are the hashtags and mentions of Content. On writes the
+	// store records the tags and the mentions that carry a user ID.
+	Entities []Entity `json:"entities"`
+	// Attachments are the images attached to the post, oldest first. They
+	// are added after the post is created.
+	Attachments []*Attachment `json:"attachments"`
 }
 
 func NewPost(userID int, content string) *Post {
 	return &Post{
-		UserID: userID,
+		UserID:  userID,
 		Content: content,
 	}
 }
 
-type PostComment struct {
+// FeedPost is a post as it appears in a timeline, together with its
+// engagement counters.
+type FeedPost struct {
+	Post
+	LikeCount    int `json:"likeCount"`
+	CommentCount int `json:"commentCount"`
+}
+
+// Cursor marks a position in a list ordered by (createdAt, id), newest first.
+type Cursor struct {
+	CreatedAt time.Time
 	ID        int
-	PostID    int
-	UserID    int
-	Content   string
-	Timestamp time.Time
+}
+
+type PostComment struct {
+	ID        int       `json:"id"`
+	PostID    int       `json:"postID"`
+	UserID    int       `json:"userID"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+	Entities  []Entity  `json:"entities"`
+}
+
+// PostCommentEntry is a comment together with its author's username.
+type PostCommentEntry struct {
+	PostComment
+	Username string `json:"username"`
+}
+
+// PostDetail is a single post with its author, engagement and a page of
+// comments.
+type PostDetail struct {
+	Post
+	Author             UserSummary         `json:"author"`
+	LikeCount          int                 `json:"likeCount"`
+	LikedByMe          bool                `json:"likedByMe"`
+	Comments           []*PostCommentEntry `json:"comments"`
+	NextCommentsCursor string              `json:"nextCommentsCursor,omitempty"`
 }
 
 func NewPostComment(postID, userID int, content string) *PostComment {
 	return &PostComment{
-		PostID: postID,
-		UserID: userID,
+		PostID:  postID,
+		UserID:  userID,
 		Content: content,
 	}
 }
 
+// PostLiker is a user in the list of users who liked a post.
+type PostLiker struct {
+	UserSummary
+	LikeID  int       `json:"-"`
+	LikedAt time.Time `json:"likedAt"`
+}
+
+// PostSearchHit is a post matching a search, with its relevance score.
+// Scores are only comparable within one search.
+type PostSearchHit struct {
+	FeedPost
+	Score float64 `json:"score"`
+}
+
+// UserSearchHit is a user whose name or profile matches a search.
+type UserSearchHit struct {
+	UserSummary
+	Score float64 `json:"score"`
+}
+
+const (
+	EntityHashtag = "hashtag"
+	EntityMention = "mention"
+)
+
+// Entity is a hashtag or mention in the content of a post or comment. Start
+// and End count characters (Unicode code points), End exclusive, so that
+// clients can turn the span into a link.
+type Entity struct {
+	Type  string `json:"type"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	// Text is the entity as written, including its '#' or '@'.
+	Text string `json:"text"`
+	// Tag is the lowercased hashtag without '#'.
+	Tag      string `json:"tag,omitempty"`
+	Username string `json:"username,omitempty"`
+	UserID   int    `json:"userID,omitempty"`
+}
+
+// Mention is a post, or a comment on a post, that mentions a user.
+type Mention struct {
+	ID          int          `json:"-"`
+	Post        Post         `json:"post"`
+	Comment     *PostComment `json:"comment,omitempty"`
+	MentionedAt time.Time    `json:"mentionedAt"`
+}
+
 type PostLike struct {
 	ID        int
 	PostID    int
 	UserID    int
 	Timestamp time.Time
 }
+
+// Session groups the access and refresh tokens issued from a single login.
+// Revoking it invalidates all of them.
+type Session struct {
+	ID        string
+	UserID    int
+	CreatedAt time.Time
+	RevokedAt *time.Time
+}
+
+// RefreshToken is stored by the SHA-256 hash of its value only. Each token can
+// be used once; presenting a used token again revokes its session.
+type RefreshToken struct {
+	ID        int
+	SessionID string
+	UserID    int
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+type TokenRefreshRequest struct {
+	RefreshToken string `json:"refreshToken" validate:"required,max=128"`
+}
+
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int64  `json:"expiresIn"`
+}
+
+type HealthCheck struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type HealthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+// LoginAttempt counts the recent failed logins for a username or client IP.
+type LoginAttempt struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+const (
+	NotificationLike    = "like"
+	NotificationComment = "comment"
+)
+
+// Notification tells a user about likes or a comment on one of their posts.
+// The likes of a post are grouped into one notification until it is read.
+type Notification struct {
+	ID        int    `json:"id"`
+	Type      string `json:"type"`
+	PostID    int    `json:"postID"`
+	CommentID int    `json:"commentID,omitempty"`
+	// Actors are the most recent users who liked or commented, newest
+	// first. ActorCount counts all of them.
+	Actors     []UserSummary `json:"actors"`
+	ActorCount int           `json:"actorCount"`
+	Read       bool          `json:"read"`
+	CreatedAt  time.Time     `json:"createdAt"`
+	// UpdatedAt is the time of the most recent actor.
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type NotificationReadRequest struct {
+	// IDs selects the notifications to mark read; All selects every one.
+	IDs []int `json:"ids"`
+	All bool  `json:"all"`
+}
+
+// Attachment is an image attached to a post. The image and its thumbnail
+// are kept in the blob store under BlobKey and ThumbnailKey; URL and
+// ThumbnailURL are where the API serves them.
+type Attachment struct {
+	ID           int       `json:"id"`
+	PostID       int       `json:"postID"`
+	ContentType  string    `json:"contentType"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnailURL"`
+	CreatedAt    time.Time `json:"createdAt"`
+
+	// SHA256 is the hex digest of the stored image.
+	SHA256               string `json:"-"`
+	BlobKey              string `json:"-"`
+	ThumbnailKey         string `json:"-"`
+	ThumbnailContentType string `json:"-"`
+}
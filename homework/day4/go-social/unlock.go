@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"gosocial/configs"
+)
+
+const unlockUsage = `usage: gosocial unlock [-user username] [-ip address]
+
+Lifts the login lockout of a username or client IP and forgets its failed
+attempts.`
+
+// runUnlock implements the unlock subcommand against the storage backend
+// selected by DB_DRIVER.
+func runUnlock(args []string) error {
+	fs := flag.NewFlagSet("unlock", flag.ContinueOnError)
+	username := fs.String("user", "", "username to unlock")
+	ip := fs.String("ip", "", "client IP address to unlock")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *username == "" && *ip == "" {
+		return errors.New(unlockUsage)
+	}
+	if configs.Envs.DBDriver == "memory" {
+		return fmt.Errorf("DB_DRIVER %q keeps no state between processes", configs.Envs.DBDriver)
+	}
+
+	storage, err := newStorage(configs.Envs)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	ctx := context.Background()
+	if *username != "" {
+		if err := storage.ClearLoginAttempt(ctx, loginUserKey(*username)); err != nil {
+			return err
+		}
+		fmt.Printf("unlocked user %s\n", *username)
+	}
+	if *ip != "" {
+		if err := storage.ClearLoginAttempt(ctx, loginIPKey(*ip)); err != nil {
+			return err
+		}
+		fmt.Printf("unlocked IP %s\n", *ip)
+	}
+	return nil
+}
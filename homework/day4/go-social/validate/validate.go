@@ -0,0 +1,132 @@
+// Package validate checks request structs against rules declared in their
+// `validate` struct tags, e.g.
+//
+//	Username string `json:"username" validate:"required,min=3,max=50,charset=username"`
+//
+// Supported rules on string fields:
+//
+//	required     the value must not be blank
+//	min=N        at least N characters
+//	max=N        at most N characters
+//	maxbytes=N   at most N bytes once UTF-8 encoded
+//	charset=X    only characters of the named charset (see charsets)
+//
+// Rules other than required are skipped for empty values, so optional fields
+// only need to be valid when present.
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gosocial/errs"
+)
+
+type charset struct {
+	allowed func(r rune) bool
+	desc    string
+}
+
+var charsets = map[string]charset{
+	"username": {
+		allowed: func(r rune) bool {
+			return r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.')
+		},
+		desc: "letters, digits, '_' and '.'",
+	},
+	"text": {
+		allowed: func(r rune) bool {
+			return r == '\n' || r == '\t' || !unicode.IsControl(r)
+		},
+		desc: "printable characters",
+	},
+}
+
+// Struct validates every tagged field of the struct v points to and returns
+// an errs.KindValidation error listing all failing fields, or nil.
+func Struct(v any) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var fields []errs.FieldError
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		tag, ok := sf.Tag.Lookup("validate")
+		if !ok || sf.Type.Kind() != reflect.String {
+			continue
+		}
+		if fe := checkField(fieldName(sf), rv.Field(i).String(), tag); fe != nil {
+			fields = append(fields, *fe)
+		}
+	}
+
+	if len(fields) > 0 {
+		return errs.InvalidFields(fields)
+	}
+	return nil
+}
+
+// checkField applies the rules in tag to value and reports the first failure.
+func checkField(name, value, tag string) *errs.FieldError {
+	blank := strings.TrimSpace(value) == ""
+	for _, rule := range strings.Split(tag, ",") {
+		key, arg, _ := strings.Cut(rule, "=")
+		if key != "required" && value == "" {
+			continue
+		}
+
+		switch key {
+		case "required":
+			if blank {
+				return &errs.FieldError{Field: name, Code: "required", Message: "is required"}
+			}
+		case "min":
+			if n := mustAtoi(arg); utf8.RuneCountInString(value) < n {
+				return &errs.FieldError{Field: name, Code: "too_short", Message: fmt.Sprintf("must be at least %d characters", n)}
+			}
+		case "max":
+			if n := mustAtoi(arg); utf8.RuneCountInString(value) > n {
+				return &errs.FieldError{Field: name, Code: "too_long", Message: fmt.Sprintf("must be at most %d characters", n)}
+			}
+		case "maxbytes":
+			if n := mustAtoi(arg); len(value) > n {
+				return &errs.FieldError{Field: name, Code: "too_long", Message: fmt.Sprintf("must be at most %d bytes", n)}
+			}
+		case "charset":
+			cs, ok := charsets[arg]
+			if !ok {
+				panic(fmt.Sprintf("validate: unknown charset %q", arg))
+			}
+			if !utf8.ValidString(value) || strings.IndexFunc(value, func(r rune) bool { return !cs.allowed(r) }) >= 0 {
+				return &errs.FieldError{Field: name, Code: "invalid_charset", Message: "may only contain " + cs.desc}
+			}
+		default:
+			panic(fmt.Sprintf("validate: unknown rule %q", rule))
+		}
+	}
+	return nil
+}
+
+// fieldName returns the JSON name of the field so errors match the request
+// body the client sent.
+func fieldName(sf reflect.StructField) string {
+	if name, _, _ := strings.Cut(sf.Tag.Get("json"), ","); name != "" && name != "-" {
+		return name
+	}
+	return sf.Name
+}
+
+func mustAtoi(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		panic(fmt.Sprintf("validate: invalid rule argument %q", s))
+	}
+	return n
+}
@@ -0,0 +1,125 @@
+package validate
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gosocial/errs"
+)
+
+type request struct {
+	Username string `json:"username" validate:"required,min=3,max=5,charset=username"`
+	Password string `json:"password" validate:"maxbytes=4"`
+	Profile  string `json:"profile,omitempty" validate:"charset=text"`
+	Internal string `validate:"required"`
+	Ignored  string
+	Count    int `validate:"required"`
+}
+
+func valid() request {
+	return request{Username: "bob", Internal: "x"}
+}
+
+// fieldErrors returns the failing fields of err by name.
+func fieldErrors(t *testing.T, err error) map[string]string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var e *errs.Error
+	if !errors.As(err, &e) || e.Kind != errs.KindValidation {
+		t.Fatalf("got %v, want a validation error", err)
+	}
+	codes := map[string]string{}
+	for _, f := range e.Fields {
+		codes[f.Field] = f.Code
+	}
+	return codes
+}
+
+func TestStruct(t *testing.T) {
+	for name, tc := range map[string]struct {
+		edit func(r *request)
+		want map[string]string
+	}{
+		"valid":              {func(r *request) {}, nil},
+		"required blank":     {func(r *request) { r.Username = "   " }, map[string]string{"username": "required"}},
+		"required empty":     {func(r *request) { r.Username = "" }, map[string]string{"username": "required"}},
+		"min":                {func(r *request) { r.Username = "bo" }, map[string]string{"username": "too_short"}},
+		"max":                {func(r *request) { r.Username = "robert" }, map[string]string{"username": "too_long"}},
+		"maxbytes":           {func(r *request) { r.Password = "pässw" }, map[string]string{"password": "too_long"}},
+		"maxbytes at limit":  {func(r *request) { r.Password = "päs" }, nil},
+		"charset":            {func(r *request) { r.Username = "bo-b" }, map[string]string{"username": "invalid_charset"}},
+		"charset non-ascii":  {func(r *request) { r.Username = "bób" }, map[string]string{"username": "invalid_charset"}},
+		"charset text":       {func(r *request) { r.Profile = "a\x00b" }, map[string]string{"profile": "invalid_charset"}},
+		"charset text lines": {func(r *request) { r.Profile = "a\n\tb" }, nil},
+		"invalid utf-8":      {func(r *request) { r.Profile = "a\xffb" }, map[string]string{"profile": "invalid_charset"}},
+		"optional empty":     {func(r *request) { r.Password = "" }, nil},
+		"go field name":      {func(r *request) { r.Internal = "" }, map[string]string{"Internal": "required"}},
+		"every field": {
+			func(r *request) { r.Username, r.Password, r.Internal = "", "12345", "" },
+			map[string]string{"username": "required", "password": "too_long", "Internal": "required"},
+		},
+	} {
+		r := valid()
+		tc.edit(&r)
+		got := fieldErrors(t, Struct(&r))
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: got %v, want %v", name, got, tc.want)
+			continue
+		}
+		for field, code := range tc.want {
+			if got[field] != code {
+				t.Errorf("%s: got %v, want %v", name, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestStructFirstFailingRule(t *testing.T) {
+	// Username fails min and charset; only the first rule is reported.
+	r := valid()
+	r.Username = "b-"
+	if got := fieldErrors(t, Struct(&r)); got["username"] != "too_short" {
+		t.Errorf("got %v, want too_short", got)
+	}
+}
+
+func TestStructNonStruct(t *testing.T) {
+	s := "x"
+	if err := Struct(&s); err != nil {
+		t.Errorf("got %v for a non-struct", err)
+	}
+}
+
+// mustPanic checks that fn panics with a message containing want.
+func mustPanic(t *testing.T, want string, fn func()) {
+	t.Helper()
+	defer func() {
+		t.Helper()
+		r := recover()
+		if msg, _ := r.(string); !strings.Contains(msg, want) {
+			t.Errorf("got panic %v, want one mentioning %q", r, want)
+		}
+	}()
+	fn()
+}
+
+func TestStructPanicsOnBadTags(t *testing.T) {
+	mustPanic(t, `unknown rule "pattern=x"`, func() {
+		Struct(&struct {
+			A string `validate:"pattern=x"`
+		}{A: "a"})
+	})
+	mustPanic(t, `unknown charset "hex"`, func() {
+		Struct(&struct {
+			A string `validate:"charset=hex"`
+		}{A: "a"})
+	})
+	mustPanic(t, `invalid rule argument "ten"`, func() {
+		Struct(&struct {
+			A string `validate:"max=ten"`
+		}{A: "a"})
+	})
+}